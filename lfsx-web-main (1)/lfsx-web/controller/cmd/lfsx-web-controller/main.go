@@ -4,6 +4,7 @@ import (
 	"gitea.hama.de/LFS/go-logger"
 	"gitea.hama.de/LFS/go-webserver/webserver"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/api"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/metrics"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
 )
 
@@ -27,6 +28,11 @@ func main() {
 	}
 	webApp.Setup(api.Routes)
 
+	// Serve the metrics on a separate address so that they are not public
+	if conf.MetricsAddress != "" {
+		go metrics.Serve(conf.MetricsAddress)
+	}
+
 	// Start the application
 	logger.Info("Started up the controller (v%s)", conf.Version)
 	webApp.Start()
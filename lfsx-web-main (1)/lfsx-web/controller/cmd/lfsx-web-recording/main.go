@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/recording"
+)
+
+// Renders a guacamole session recording into PNG keyframes so that
+// a recorded session can be viewed without a browser.
+//
+// Usage: lfsx-web-recording [-interval 5s] [-output dir] <recording.guac>
+func main() {
+	defer logger.CloseFile()
+
+	interval := flag.Duration("interval", 5*time.Second, "Minimal time between two keyframes")
+	output := flag.String("output", "", "Directory for the keyframes and the timeline (defaults to \"<recording>-frames\")")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <recording%s>\n", filepath.Base(os.Args[0]), recording.Extension)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input := flag.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(input, recording.Extension) + "-frames"
+	}
+
+	file, err := os.Open(input)
+	if err != nil {
+		logger.Fatal("Failed to open recording: %s", err)
+	}
+	defer file.Close()
+
+	timeline, err := recording.Render(file, *output, *interval)
+	if err != nil {
+		logger.Fatal("Failed to render recording %q: %s", input, err)
+	}
+
+	logger.Info("Rendered %d keyframes of %s (%dx%d) into %q", len(timeline.Keyframes), time.Duration(timeline.Duration)*time.Millisecond, timeline.Width, timeline.Height, *output)
+}
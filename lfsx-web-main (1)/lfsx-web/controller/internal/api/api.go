@@ -10,10 +10,19 @@ import (
 	"gitea.hama.de/LFS/go-webserver/response"
 	"gitea.hama.de/LFS/go-webserver/webserver"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/api/api_proxy"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/api/gc"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/api/kubernetes"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/api/notifications"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/api/pool"
+	apiRecording "gitea.hama.de/LFS/lfsx-web/controller/internal/api/recording"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/api/sessions"
 	vnc "gitea.hama.de/LFS/lfsx-web/controller/internal/api/vnc_proxy"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/api/workspaces"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/backend"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/kuber"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/recording"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/registry"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -25,6 +34,9 @@ type Api struct {
 
 	// VNC Service used for proxy the logout request
 	vncService *vnc.VncProxy
+
+	// Kubernetes client. Nil when the sessions aren't running inside kubernetes
+	kuber *kuber.Kuber
 }
 
 // Routes Setups and initializes all the api endpoints and registers the routes
@@ -60,7 +72,7 @@ func Routes(server *webserver.WebServer[Api]) http.Handler {
 			noAuth.Post("/login", api.login)
 
 			// Register kubernetes health endpoints
-			kubernetes.RegisterHandlers(noAuth)
+			kubernetes.RegisterHandlers(noAuth, &api)
 		})
 	})
 
@@ -130,17 +142,43 @@ func (api *Api) allowQaOrigin(w http.ResponseWriter, r *http.Request) bool {
 // routes (with authentication) under the main API path
 func (api *Api) routes(r chi.Router) {
 
-	// Shared kubernetes client
-	kuber, err := kuber.NewKuber(api.Config)
-	if err != nil {
-		logger.Fatal("Failed to create kubernetes client: %s", err)
-	}
+	// Backend that provides the LFS.X sessions of the users
+	var sessionBackend backend.SessionBackend
+	// Registry of the replicas owning the sessions. Only needed for multiple replicas inside kubernetes
+	var sessionRegistry registry.Registry = registry.NewLocalRegistry()
+	// Notifications of the administrators. Shared by all replicas inside kubernetes
+	var notificationStore registry.NotificationStore = registry.NewLocalNotificationStore()
+	// Garbage collector of the LFS pods and jobs. Only available inside kubernetes
+	var garbageCollector *kuber.GarbageCollector
+	// Persistent workspaces of the users. Only available inside kubernetes
+	var workspaceService workspaces.Service
+	// Pool of the placeholders. Only available inside kubernetes
+	var poolService *kuber.Pool
+	if api.Config.SessionBackend == models.SessionBackendProcess {
+		logger.Info("Starting the LFS.X sessions as local processes with %q", api.Config.ProcessBackend.Command)
+		sessionBackend = backend.NewProcessBackend(api.Config)
+	} else {
+		// Shared kubernetes client
+		kuber, err := kuber.NewKuber(api.Config)
+		if err != nil {
+			logger.Fatal("Failed to create kubernetes client: %s", err)
+		}
 
-	// Start generic tasks
-	api.startTasks(kuber)
+		// Start generic tasks
+		api.startTasks(kuber)
+		api.kuber = kuber
+		sessionBackend = kuber
+		sessionRegistry = kuber.Registry
+		notificationStore = kuber
+		garbageCollector = kuber.GC
+		if api.Config.Workspace.Enabled {
+			workspaceService = kuber
+		}
+		poolService = kuber.Pool
+	}
 
 	// VNC endpoints handling the WebSocket connection
-	vncService, err := vnc.NewVncProxy(context.Background(), kuber, api.Config)
+	vncService, err := vnc.NewVncProxy(context.Background(), sessionBackend, sessionRegistry, notificationStore, api.Config)
 	if err != nil {
 		logger.Fatal(err.Error())
 	}
@@ -149,6 +187,39 @@ func (api *Api) routes(r chi.Router) {
 
 	// Register proxy endpoints
 	api_proxy.RegisterHandlers(r, vncService)
+
+	// Register admin endpoints
+	r.Group(func(admin chi.Router) {
+		admin.Use(api.AdminMiddleware)
+
+		// Live sessions connected to this replica
+		sessions.RegisterHandlers(admin, vncService)
+
+		// Notifications to all connected users
+		notifications.RegisterHandlers(admin, vncService)
+
+		// Expose the state of the placeholder pool
+		if poolService != nil {
+			pool.RegisterHandlers(admin, poolService)
+		}
+
+		// Report and run the garbage collection of the LFS pods and jobs
+		if garbageCollector != nil {
+			gc.RegisterHandlers(admin, garbageCollector)
+		}
+
+		// Show and reset the workspaces of the users
+		if workspaceService != nil {
+			workspaces.RegisterHandlers(admin, workspaceService)
+		}
+
+		// Recordings of the sessions
+		if api.Config.Recording.Path != "" {
+			store := recording.NewStore(api.Config.Recording)
+			go store.Run(context.Background())
+			apiRecording.RegisterHandlers(admin, store)
+		}
+	})
 }
 
 // startTasks runs generic kubernetes tasks that are performed
@@ -156,7 +227,7 @@ func (api *Api) routes(r chi.Router) {
 // This method does not block. The tasks are only started
 func (api *Api) startTasks(kuber *kuber.Kuber) {
 
-	// Start a new thread to remove completed jobs
+	// Start a new thread to watch for a new image version
 	go func() {
 		ticker := time.NewTicker(60 * time.Second)
 		context := context.Background()
@@ -165,42 +236,37 @@ func (api *Api) startTasks(kuber *kuber.Kuber) {
 		for {
 			select {
 			case <-ticker.C:
-				if err := kuber.DeleteCompletedLfsPods(); err != nil {
-					logger.Warning("Failed to delete completed pods: %s", err)
-				}
-
-				// Check if image of lFS was changed
+				// Check if image of lFS was changed. The pool drains the old placeholders and creates new ones for that version
 				if lastImageVersion != api.Config.GetLfsImageVersion() {
 					logger.Info("Changed image version of the LFS.X: %s", api.Config.GetLfsImageVersion())
 					lastImageVersion = api.Config.GetLfsImageVersion()
-					api.createAtLeastOneJob(kuber)
+					kuber.Pool.Trigger()
 				}
 			case <-context.Done():
-				logger.Info("Stopped looking for completed pods")
+				logger.Info("Stopped looking for a new image version")
 				ticker.Stop()
 				return
 			}
 		}
 	}()
 
-	// The initial pulling and creation of a container (image) takes long. So create at least one pod at start
-	go func() {
-		api.createAtLeastOneJob(kuber)
-	}()
+	// The initial pulling and creation of a container (image) takes long. So keep the placeholders warm
+	// and remove stale, orphaned and stuck pods and jobs. Only the leading replica runs them
+	go kuber.RunLeaderTasks(context.Background())
+
+	// Keep the ownership of the connected sessions
+	go kuber.Registry.Run(context.Background())
+
+	// Reload the overridden templates when they change
+	go kuber.WatchTemplates(context.Background())
 }
 
-// createAtLeastOneJob checks if at least one job is created for the
-// current image version.
-// If not, a single placeholder job is created
-func (api *Api) createAtLeastOneJob(kuber *kuber.Kuber) {
-	jobs, err := kuber.GetPlaceholders()
-	if err == nil && len(jobs.Items) == 0 {
-		if _, err := kuber.CreatePlaceholderJob(); err != nil {
-			logger.Warning("Failed to create placeholders on startup / on image change")
-		}
-	} else if err != nil {
-		logger.Debug("Failed to create placeholders: %s", err)
-	} else {
-		logger.Debug("No creation of placeholders is required (already started %d pods)", len(jobs.Items))
+// Ready implements kubernetes.Service. The controller isn't ready while no
+// valid template for the LFS jobs is available
+func (api *Api) Ready() error {
+	if api.kuber != nil {
+		return api.kuber.TemplateError()
 	}
+
+	return nil
 }
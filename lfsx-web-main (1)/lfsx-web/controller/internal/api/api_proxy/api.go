@@ -15,6 +15,7 @@ import (
 
 type Service interface {
 	IsUserConnected(user *models.User) bool
+	ForwardToOwner(user *models.User, response http.ResponseWriter, request *http.Request) bool
 	ProxyLfsxRequest(user *models.User, response http.ResponseWriter, request *http.Request) error
 	ProxyLfsxWebsocket(user *models.User, response http.ResponseWriter, request *http.Request) error
 	ProxyHostRequest(user *models.User, response http.ResponseWriter, request *http.Request) error
@@ -39,6 +40,11 @@ func (res ressource) ProxyLfs(w http.ResponseWriter, r *http.Request) {
 	// Get the user of the request
 	user := r.Context().Value(models.KeyUser).(*models.User)
 
+	// The session may be owned by another replica
+	if res.service.ForwardToOwner(user, w, r) {
+		return
+	}
+
 	// Remove '/app' from path
 	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/api/app")
 
@@ -58,6 +64,11 @@ func (res ressource) ProxyHost(w http.ResponseWriter, r *http.Request) {
 	// Get the user of the request
 	user := r.Context().Value(models.KeyUser).(*models.User)
 
+	// The session may be owned by another replica
+	if res.service.ForwardToOwner(user, w, r) {
+		return
+	}
+
 	// Remove '/host' from path
 	r.URL.Path = "/api" + strings.TrimPrefix(r.URL.Path, "/api/host")
 
@@ -90,6 +101,11 @@ func (res ressource) onWebsocket(w http.ResponseWriter, r *http.Request) {
 	// Get the user of the request
 	user := r.Context().Value(models.KeyUser).(*models.User)
 
+	// The session may be owned by another replica
+	if res.service.ForwardToOwner(user, w, r) {
+		return
+	}
+
 	// Proxy the request
 	if err := res.service.ProxyLfsxWebsocket(user, w, r); err != nil {
 		logger.Trc("Failed to proxy lfsx: %s", err)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 	"gitea.hama.de/LFS/go-webserver/response"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/api/api_proxy"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/jwto"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/metrics"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
 )
 
@@ -65,10 +67,34 @@ func (api *Api) AuthenticationMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// AdminMiddleware only allows requests of users that are members of the
+// admin group (APP_USER_GROUPS). The membership is neither a role of the
+// JWT nor a separate credential: any valid login of a listed database
+// user grants access. It has to be used after the AuthenticationMiddleware
+func (api *Api) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(models.KeyUser).(*models.User)
+
+		if !api.Config.IsInGroup(user, models.GroupAdmin) {
+			logger.Debug("User %q of database %s is not allowed to access the admin endpoint %q", user.DbUser, user.Database, r.URL.Path)
+			response.WriteText("Forbidden", 403, w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Login makes a login request to the LFS service endpoint.
 // If the login was successfull, the cookie will be forwarded to the
 // own domain
-func (api Api) login(w http.ResponseWriter, r *http.Request) {
+func (api Api) login(rw http.ResponseWriter, r *http.Request) {
+
+	// Count the login results
+	w := metrics.NewStatusRecorder(rw)
+	defer func() {
+		metrics.Logins.WithLabelValues(strconv.Itoa(w.Status)).Inc()
+	}()
 
 	// Parse the form
 	err := r.ParseForm()
@@ -0,0 +1,36 @@
+package gc
+
+import (
+	"net/http"
+
+	"gitea.hama.de/LFS/go-webserver/response"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/kuber"
+	"github.com/go-chi/chi/v5"
+)
+
+type Service interface {
+	Collect(dryRun bool) kuber.GCReport
+}
+
+type ressource struct {
+	service Service
+}
+
+// RegisterHandlers register the admin endpoints to report and run the
+// garbage collection of the LFS pods and jobs
+func RegisterHandlers(r chi.Router, service Service) {
+	res := ressource{service: service}
+
+	r.Get("/admin/gc", res.Report)
+	r.Post("/admin/gc", res.Collect)
+}
+
+// Report returns the pods and jobs the garbage collector would remove (dry run)
+func (res ressource) Report(w http.ResponseWriter, r *http.Request) {
+	response.WriteJson(res.service.Collect(true), 200, w)
+}
+
+// Collect removes the pods and jobs immediately and returns what was removed
+func (res ressource) Collect(w http.ResponseWriter, r *http.Request) {
+	response.WriteJson(res.service.Collect(false), 200, w)
+}
@@ -3,16 +3,24 @@ package kubernetes
 import (
 	"net/http"
 
+	"gitea.hama.de/LFS/go-logger"
 	"gitea.hama.de/LFS/go-webserver/response"
 	"github.com/go-chi/chi/v5"
 )
 
-type ressource struct{}
+type Service interface {
+	// Ready returns an error if the app can't serve requests
+	Ready() error
+}
+
+type ressource struct {
+	service Service
+}
 
 // RegisterHandlers register endpoints that are needed for kubernetes
 // to check the current status of the pod.
-func RegisterHandlers(r chi.Router) {
-	res := ressource{}
+func RegisterHandlers(r chi.Router, service Service) {
+	res := ressource{service: service}
 
 	r.Get("/healthz", res.HealthCheck)
 	r.Get("/readyz", res.ReadinessCheck)
@@ -23,5 +31,11 @@ func (res *ressource) HealthCheck(w http.ResponseWriter, r *http.Request) {
 }
 
 func (res *ressource) ReadinessCheck(w http.ResponseWriter, r *http.Request) {
+	if err := res.service.Ready(); err != nil {
+		logger.Debug("Readiness check failed: %s", err)
+		response.WriteText(err.Error(), 503, w)
+		return
+	}
+
 	response.WriteText("OK", 200, w)
 }
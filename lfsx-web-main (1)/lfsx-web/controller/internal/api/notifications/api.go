@@ -0,0 +1,63 @@
+package notifications
+
+import (
+	"net/http"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/go-webserver/errors"
+	"gitea.hama.de/LFS/go-webserver/response"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type Service interface {
+	Notifications() ([]models.Notification, error)
+	Broadcast(admin *models.User, notification models.Notification) (models.Notification, error)
+}
+
+type ressource struct {
+	service Service
+}
+
+// RegisterHandlers register the admin endpoints to broadcast
+// notifications to all connected users
+func RegisterHandlers(r chi.Router, service Service) {
+	res := ressource{service: service}
+
+	r.Get("/admin/notifications", res.List)
+	r.Post("/admin/notifications", res.Broadcast)
+}
+
+// List returns all notifications that are still shown to connecting users
+func (res ressource) List(w http.ResponseWriter, r *http.Request) {
+	notifications, err := res.service.Notifications()
+	if err != nil {
+		logger.Warning("%s", err)
+		errors.Write(w, errors.NewError("Failed to get the notifications", 500))
+		return
+	}
+
+	response.WriteJson(notifications, 200, w)
+}
+
+// Broadcast sends the notification of the body to all connected users. The
+// fields "text", "severity" (info, warning or critical) and optionally
+// "countdownTo" and "expiresAt" are used
+func (res ressource) Broadcast(w http.ResponseWriter, r *http.Request) {
+	admin := r.Context().Value(models.KeyUser).(*models.User)
+
+	notification, err := utils.DecodeBody(&models.Notification{}, r)
+	if err != nil {
+		errors.Write(w, err)
+		return
+	}
+
+	created, err := res.service.Broadcast(admin, *notification)
+	if err != nil {
+		errors.Write(w, err)
+		return
+	}
+
+	response.WriteJson(created, 200, w)
+}
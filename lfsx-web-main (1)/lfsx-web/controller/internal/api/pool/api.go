@@ -0,0 +1,30 @@
+package pool
+
+import (
+	"net/http"
+
+	"gitea.hama.de/LFS/go-webserver/response"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/kuber"
+	"github.com/go-chi/chi/v5"
+)
+
+type Service interface {
+	State() kuber.PoolState
+}
+
+type ressource struct {
+	service Service
+}
+
+// RegisterHandlers register endpoints to inspect the pool of
+// placeholder jobs
+func RegisterHandlers(r chi.Router, service Service) {
+	res := ressource{service: service}
+
+	r.Get("/pool", res.GetState)
+}
+
+// GetState returns how many placeholders are warm, pending and claimed
+func (res ressource) GetState(w http.ResponseWriter, r *http.Request) {
+	response.WriteJson(res.service.State(), 200, w)
+}
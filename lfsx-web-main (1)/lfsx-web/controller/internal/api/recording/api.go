@@ -0,0 +1,85 @@
+package recording
+
+import (
+	"net/http"
+	"os"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/go-webserver/errors"
+	"gitea.hama.de/LFS/go-webserver/response"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/recording"
+	"github.com/go-chi/chi/v5"
+)
+
+type Service interface {
+	List() ([]recording.Recording, error)
+	Open(name string) (*os.File, error)
+	Delete(name string) error
+}
+
+type ressource struct {
+	service Service
+}
+
+// RegisterHandlers register the admin endpoints to list, download
+// and delete the recordings of the sessions
+func RegisterHandlers(r chi.Router, service Service) {
+	res := ressource{service: service}
+
+	r.Get("/admin/recordings", res.List)
+	r.Get("/admin/recordings/{name}", res.Download)
+	r.Delete("/admin/recordings/{name}", res.Delete)
+}
+
+func (res ressource) List(w http.ResponseWriter, r *http.Request) {
+	recordings, err := res.service.List()
+	if err != nil {
+		logger.Warning("%s", err)
+		errors.Write(w, errors.NewError("Failed to list recordings", 500))
+		return
+	}
+
+	response.WriteJson(recordings, 200, w)
+}
+
+func (res ressource) Download(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	file, err := res.service.Open(name)
+	if err != nil {
+		res.writeError(w, name, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		res.writeError(w, name, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+func (res ressource) Delete(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	if err := res.service.Delete(name); err != nil {
+		res.writeError(w, name, err)
+		return
+	}
+
+	logger.Info("Deleted recording %q", name)
+	response.WriteText("OK", 200, w)
+}
+
+// writeError writes the error of accessing the given recording
+func (res ressource) writeError(w http.ResponseWriter, name string, err error) {
+	if os.IsNotExist(err) {
+		errors.Write(w, errors.NewError("Recording not found", 404))
+	} else {
+		logger.Warning("Failed to access recording %q: %s", name, err)
+		errors.Write(w, errors.NewError("Failed to access recording", 400))
+	}
+}
@@ -0,0 +1,68 @@
+package sessions
+
+import (
+	"net/http"
+
+	"gitea.hama.de/LFS/go-webserver/errors"
+	"gitea.hama.de/LFS/go-webserver/response"
+	vnc "gitea.hama.de/LFS/lfsx-web/controller/internal/api/vnc_proxy"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type Service interface {
+	Sessions(r *http.Request) []vnc.SessionInfo
+	CloseSession(admin *models.User, target *models.User, reason string, deletePod bool) error
+	ForwardToOwner(user *models.User, w http.ResponseWriter, r *http.Request) bool
+}
+
+type ressource struct {
+	service Service
+}
+
+// RegisterHandlers register the admin endpoints to list and
+// close the connected sessions
+func RegisterHandlers(r chi.Router, service Service) {
+	res := ressource{service: service}
+
+	r.Get("/admin/sessions", res.List)
+	r.Delete("/admin/sessions/{db}/{user}", res.Close)
+}
+
+// List returns the connected sessions of all controller replicas
+func (res ressource) List(w http.ResponseWriter, r *http.Request) {
+	response.WriteJson(res.service.Sessions(r), 200, w)
+}
+
+// Close closes the connection of the user. The query parameter "reason" is shown
+// to the user and "deletePod=true" deletes the session (pod) of the user as well
+func (res ressource) Close(w http.ResponseWriter, r *http.Request) {
+	admin := r.Context().Value(models.KeyUser).(*models.User)
+	db, ok := models.ParseDatabase(chi.URLParam(r, "db"))
+	if !ok {
+		errors.Write(w, errors.BadRequest("Unknown database"))
+		return
+	}
+	target := &models.User{
+		DbUser:      chi.URLParam(r, "user"),
+		DatabaseStr: chi.URLParam(r, "db"),
+		Database:    db,
+	}
+
+	// The connection may be held by another replica
+	if res.service.ForwardToOwner(target, w, r) {
+		return
+	}
+
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "Closed by an administrator"
+	}
+
+	if err := res.service.CloseSession(admin, target, reason, r.URL.Query().Get("deletePod") == "true"); err != nil {
+		errors.Write(w, err)
+		return
+	}
+
+	response.WriteText("OK", 200, w)
+}
@@ -2,18 +2,21 @@ package vnc
 
 import (
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"gitea.hama.de/LFS/go-logger"
 	"gitea.hama.de/LFS/go-webserver/errors"
 	"gitea.hama.de/LFS/go-webserver/response"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
-	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
 	"github.com/go-chi/chi/v5"
 )
 
 type Service interface {
 	Proxy(w http.ResponseWriter, r *http.Request, user *models.User, useGuacamole bool, vncSettings VncConnectionSettings) error
 	Probe(user *models.User, vncSettings VncConnectionSettings) error
+	Shadow(w http.ResponseWriter, r *http.Request, supporter *models.User, target *models.User) error
+	ProxyHTTPTunnel(w http.ResponseWriter, r *http.Request, user *models.User)
 }
 
 type ressource struct {
@@ -25,6 +28,27 @@ type ressource struct {
 type VncConnectionSettings struct {
 	// Scaling factor of the application based on 100%
 	Scaling int
+
+	// Close an already existing connection of the user instead of
+	// rejecting this connection
+	Takeover bool
+
+	// Picture quality of guacamole ("high", "medium" or "low")
+	Quality string
+}
+
+// NewVncConnectionSettings reads the settings from the given connection parameters
+func NewVncConnectionSettings(values url.Values) VncConnectionSettings {
+	scaling, err := strconv.Atoi(values.Get("scale"))
+	if err != nil {
+		scaling = 100
+	}
+
+	return VncConnectionSettings{
+		Scaling:  scaling,
+		Takeover: values.Get("takeover") == "true",
+		Quality:  values.Get("quality"),
+	}
 }
 
 func RegisterHandlers(r chi.Router, service Service) {
@@ -32,6 +56,11 @@ func RegisterHandlers(r chi.Router, service Service) {
 
 	r.Get("/vnc/ws", res.onWebsocket)
 	r.Get("/vnc/ws/probe", res.probeConnection)
+	r.Get("/vnc/shadow/ws", res.onShadowWebsocket)
+
+	// Fallback for clients that cannot use a WebSocket
+	r.Get("/vnc/tunnel", res.onHTTPTunnel)
+	r.Post("/vnc/tunnel", res.onHTTPTunnel)
 }
 
 func (res ressource) onWebsocket(w http.ResponseWriter, r *http.Request) {
@@ -54,6 +83,47 @@ func (res ressource) onWebsocket(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// onShadowWebsocket joins the guacamole session of the user given by the
+// query parameters "user" and "db" read-only
+func (res ressource) onShadowWebsocket(w http.ResponseWriter, r *http.Request) {
+
+	// Get the user of the request
+	supporter := r.Context().Value(models.KeyUser).(*models.User)
+
+	// Get the user to watch
+	db, ok := models.ParseDatabase(r.URL.Query().Get("db"))
+	if !ok {
+		errors.Write(w, errors.BadRequest("Unknown database"))
+		return
+	}
+	target := &models.User{
+		DbUser:      r.URL.Query().Get("user"),
+		DatabaseStr: r.URL.Query().Get("db"),
+		Database:    db,
+	}
+	if target.DbUser == "" {
+		errors.Write(w, errors.NewError("No user given", 400))
+		return
+	}
+
+	if err := res.service.Shadow(w, r, supporter, target); err != nil {
+		logger.Debug("Received error from shadow proxy: %s", err)
+
+		if _, ok := err.(errors.ErrorResponse); ok {
+			errors.Write(w, err)
+		}
+	}
+}
+
+// onHTTPTunnel handles the requests of the guacamole HTTP tunnel
+func (res ressource) onHTTPTunnel(w http.ResponseWriter, r *http.Request) {
+
+	// Get the user of the request
+	user := r.Context().Value(models.KeyUser).(*models.User)
+
+	res.service.ProxyHTTPTunnel(w, r, user)
+}
+
 func (res ressource) probeConnection(w http.ResponseWriter, r *http.Request) {
 	// Get the user of the request
 	user := r.Context().Value(models.KeyUser).(*models.User)
@@ -69,7 +139,5 @@ func (res ressource) probeConnection(w http.ResponseWriter, r *http.Request) {
 }
 
 func (res ressource) getVncSettings(r *http.Request) VncConnectionSettings {
-	return VncConnectionSettings{
-		Scaling: utils.GetQueryValueInt("scale", 100, r),
-	}
+	return NewVncConnectionSettings(r.URL.Query())
 }
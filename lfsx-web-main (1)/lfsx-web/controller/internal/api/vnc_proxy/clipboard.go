@@ -0,0 +1,266 @@
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/guacamole"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+)
+
+// Index of the stream the controller uses to send the clipboard to guacamole
+// clients. guacd allocates its own streams from the lowest indices
+const clipboardStreamIndex = "1023"
+
+// Maximum number of base64 characters within a single blob instruction
+const clipboardBlobSize = 6144
+
+// The clipboard of the session as returned by the host API
+type sessionClipboard struct {
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+// clipboardBridge synchronizes the clipboard of the client with the wayland
+// clipboard of the session.
+//
+// guacd and the VNC server only support Latin-1 for the clipboard. So the
+// clipboard is exchanged with the host API of the session instead
+type clipboardBridge struct {
+	lock sync.Mutex
+
+	// The text that was exchanged last. Prevents sending a change back
+	// to where it came from
+	last string
+
+	// Index of the clipboard stream the guacamole client is currently sending
+	stream string
+	// The decoded data of the current stream
+	data bytes.Buffer
+}
+
+// watchSessionClipboard waits for changes of the clipboard of the session and sends
+// them to the client until the given context is done
+func (p *peer) watchSessionClipboard(ctx context.Context) {
+	// Don't send the content the clipboard already has on connect
+	version := -1
+
+	for ctx.Err() == nil {
+		clipboard, changed, err := p.waitForSessionClipboard(ctx, version)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Debug("Failed to watch the clipboard of the session of user %q: %s", p.user.Username, err)
+
+			select {
+			case <-time.After(watchRetryDelay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		firstRead := version == -1
+		version = clipboard.Version
+
+		p.clipboard.lock.Lock()
+		isNew := clipboard.Text != p.clipboard.last
+		p.clipboard.last = clipboard.Text
+		p.clipboard.lock.Unlock()
+
+		if isNew && !firstRead {
+			p.sendClipboard(clipboard.Text)
+		}
+	}
+}
+
+// waitForSessionClipboard returns the clipboard of the session as soon as the version is
+// newer than the given version. A negative version returns the current clipboard
+func (p *peer) waitForSessionClipboard(ctx context.Context, version int) (sessionClipboard, bool, error) {
+	url := fmt.Sprintf("http://%s/api/clipboard", p.session.HostAddress())
+	if version >= 0 {
+		url += "?since=" + strconv.Itoa(version)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return sessionClipboard{}, false, err
+	}
+
+	// The host API answers after at most 25 seconds
+	client := http.Client{Timeout: 35 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return sessionClipboard{}, false, fmt.Errorf("request to LFS.X host: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return sessionClipboard{}, false, nil
+	}
+	if resp.StatusCode != 200 {
+		bodyR, _ := io.ReadAll(resp.Body)
+		return sessionClipboard{}, false, fmt.Errorf("%d: %s", resp.StatusCode, bodyR)
+	}
+
+	var clipboard sessionClipboard
+	if err := json.NewDecoder(resp.Body).Decode(&clipboard); err != nil {
+		return sessionClipboard{}, false, fmt.Errorf("failed to decode the clipboard: %s", err)
+	}
+
+	return clipboard, true, nil
+}
+
+// setSessionClipboard writes the given text into the clipboard of the session
+func (p *peer) setSessionClipboard(text string) {
+	p.clipboard.lock.Lock()
+	if text == p.clipboard.last {
+		p.clipboard.lock.Unlock()
+		return
+	}
+	p.clipboard.last = text
+	p.clipboard.lock.Unlock()
+
+	body, err := json.Marshal(sessionClipboard{Text: text})
+	if err != nil {
+		logger.Warning("Failed to marshal the clipboard: %s", err)
+		return
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(fmt.Sprintf("http://%s/api/clipboard", p.session.HostAddress()), "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Warning("Failed to write the clipboard of the session of user %q: %s", p.user.Username, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		bodyR, _ := io.ReadAll(resp.Body)
+		logger.Warning("Failed to write the clipboard of the session of user %q: %d: %s", p.user.Username, resp.StatusCode, bodyR)
+	}
+}
+
+// sendClipboard sends the given text as the new clipboard to the client
+func (p *peer) sendClipboard(text string) {
+	if !p.guacamole.Used {
+		p.notifyClient(models.NewClipboard(text))
+		return
+	}
+
+	instructions := []*guacamole.Instruction{
+		guacamole.NewInstruction("clipboard", clipboardStreamIndex, "text/plain"),
+	}
+	data := base64.StdEncoding.EncodeToString([]byte(text))
+	for len(data) > 0 {
+		size := clipboardBlobSize
+		if len(data) < size {
+			size = len(data)
+		}
+		instructions = append(instructions, guacamole.NewInstruction("blob", clipboardStreamIndex, data[:size]))
+		data = data[size:]
+	}
+	instructions = append(instructions, guacamole.NewInstruction("end", clipboardStreamIndex))
+
+	p.sendInstructions(instructions...)
+}
+
+// receiveClipboardMessages writes the clipboard messages of noVNC clients into
+// the clipboard of the session until the given context is done
+func (p *peer) receiveClipboardMessages(ctx context.Context) {
+	updates := Subscribe(ctx, p.lfsxPeer, models.ClipboardType, models.FromClient)
+
+	for {
+		select {
+		case up := <-updates:
+			go p.setSessionClipboard(up.Payload.Text)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// onStream handles a clipboard instruction of the guacamole client that
+// starts a new clipboard stream
+func (c *clipboardBridge) onStream(p *peer, ins *guacamole.Instruction) {
+	if len(ins.Args) < 2 {
+		return
+	}
+
+	// Only text can be written into the clipboard of the session
+	if !strings.HasPrefix(ins.Args[1], "text/") {
+		p.sendAck(ins.Args[0], guacamole.Unsupported)
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.stream = ins.Args[0]
+	c.data.Reset()
+}
+
+// onStreamData handles a blob, end or ack instruction of the guacamole client. It returns
+// false when the instruction doesn't belong to a clipboard stream
+func (c *clipboardBridge) onStreamData(p *peer, ins *guacamole.Instruction) bool {
+	if len(ins.Args) < 1 {
+		return false
+	}
+
+	// Acknowledgements of the client for the clipboard sent by the controller
+	if ins.Opcode == "ack" {
+		return ins.Args[0] == clipboardStreamIndex
+	}
+
+	c.lock.Lock()
+	if c.stream == "" || ins.Args[0] != c.stream {
+		c.lock.Unlock()
+		return false
+	}
+
+	if ins.Opcode == "end" {
+		text := c.data.String()
+		c.stream = ""
+		c.data.Reset()
+		c.lock.Unlock()
+
+		// Written synchronously so that following key events (Ctrl + V) are
+		// handled after the clipboard was changed
+		if p.session != nil {
+			p.setSessionClipboard(text)
+		}
+		return true
+	}
+
+	status := guacamole.Success
+	if data, err := base64.StdEncoding.DecodeString(ins.Args[len(ins.Args)-1]); err != nil || len(ins.Args) < 2 {
+		status = guacamole.ClientBadRequest
+	} else if c.data.Len()+len(data) > models.MaxClipboardSize {
+		status = guacamole.ClientOverrun
+	} else {
+		c.data.Write(data)
+	}
+
+	// The client closes the stream on an error
+	if status != guacamole.Success {
+		c.stream = ""
+		c.data.Reset()
+	}
+	c.lock.Unlock()
+
+	p.sendAck(ins.Args[0], status)
+	return true
+}
@@ -0,0 +1,104 @@
+package vnc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+)
+
+// The outbox of the session as returned by the host API
+type sessionOutbox struct {
+	Version int `json:"version"`
+	Files   []struct {
+		Name       string    `json:"name"`
+		Size       int64     `json:"size"`
+		ModifiedAt time.Time `json:"modifiedAt"`
+	} `json:"files"`
+}
+
+// watchDownloads waits for files the LFS.X exports into the outbox directory of the
+// session and notifies the client about them until the given context is done
+func (p *peer) watchDownloads(ctx context.Context) {
+	// Files that already existed on connect are not reported
+	version := -1
+
+	for ctx.Err() == nil {
+		outbox, changed, err := p.waitForOutbox(ctx, version)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Debug("Failed to watch the outbox of the session of user %q: %s", p.user.Username, err)
+
+			select {
+			case <-time.After(watchRetryDelay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		firstRead := version == -1
+		version = outbox.Version
+		if firstRead {
+			continue
+		}
+
+		for _, file := range outbox.Files {
+			logger.Debug("File %q of user %q is ready to download", file.Name, p.user.Username)
+			p.notifyClient(models.NewFileDownloadReady(models.FileDownloadReady{
+				Name:       file.Name,
+				Size:       file.Size,
+				ModifiedAt: file.ModifiedAt,
+				URL:        "/api/host/files/" + url.PathEscape(file.Name),
+			}))
+		}
+	}
+}
+
+// waitForOutbox returns the files that became ready after the given version. A
+// negative version returns all files of the outbox
+func (p *peer) waitForOutbox(ctx context.Context, version int) (sessionOutbox, bool, error) {
+	endpoint := fmt.Sprintf("http://%s/api/files", p.session.HostAddress())
+	if version >= 0 {
+		endpoint += "?since=" + strconv.Itoa(version)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return sessionOutbox{}, false, err
+	}
+
+	// The host API answers after at most 25 seconds
+	client := http.Client{Timeout: 35 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return sessionOutbox{}, false, fmt.Errorf("request to LFS.X host: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return sessionOutbox{}, false, nil
+	}
+	if resp.StatusCode != 200 {
+		bodyR, _ := io.ReadAll(resp.Body)
+		return sessionOutbox{}, false, fmt.Errorf("%d: %s", resp.StatusCode, bodyR)
+	}
+
+	var outbox sessionOutbox
+	if err := json.NewDecoder(resp.Body).Decode(&outbox); err != nil {
+		return sessionOutbox{}, false, fmt.Errorf("failed to decode the outbox: %s", err)
+	}
+
+	return outbox, true, nil
+}
@@ -2,6 +2,7 @@ package vnc
 
 import (
 	"io"
+	"sync"
 
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/guacamole"
 )
@@ -11,5 +12,32 @@ type Guacamole struct {
 
 	Stream *guacamole.Stream
 
+	// Tunnel synchronizing the access to the stream. HTTP tunnel clients
+	// are accessing the stream only through the tunnel
+	Tunnel *guacamole.SimpleTunnel
+
 	Writer io.Writer
+
+	// Instructions of the controller that are returned to HTTP tunnel clients
+	// with the next read request
+	pending     []byte
+	pendingLock sync.Mutex
+}
+
+// queue adds the given instructions to the pending instructions
+func (g *Guacamole) queue(data []byte) {
+	g.pendingLock.Lock()
+	defer g.pendingLock.Unlock()
+
+	g.pending = append(g.pending, data...)
+}
+
+// takePending returns and removes all pending instructions
+func (g *Guacamole) takePending() []byte {
+	g.pendingLock.Lock()
+	defer g.pendingLock.Unlock()
+
+	data := g.pending
+	g.pending = nil
+	return data
 }
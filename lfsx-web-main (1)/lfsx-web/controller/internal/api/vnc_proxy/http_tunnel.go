@@ -0,0 +1,185 @@
+package vnc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/go-webserver/errors"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/guacamole"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/metrics"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+)
+
+// Close reason of a peer whose HTTP tunnel was closed
+var errHTTPTunnelClosed = fmt.Errorf("the HTTP tunnel was closed")
+
+// httpTunnel is the tunnel of a peer whose client uses the HTTP tunnel of
+// guacamole-common-js instead of a WebSocket.
+//
+// The traffic passing the tunnel is counted for the peer and closing the
+// tunnel closes the peer
+type httpTunnel struct {
+	*guacamole.SimpleTunnel
+
+	peer *peer
+}
+
+// AcquireReader acquires the reader lock and returns a reader counting the traffic
+func (t *httpTunnel) AcquireReader() guacamole.InstructionReader {
+	return &httpTunnelReader{InstructionReader: t.SimpleTunnel.AcquireReader(), peer: t.peer}
+}
+
+// AcquireWriter acquires the writer lock and returns a writer counting the traffic
+func (t *httpTunnel) AcquireWriter() io.Writer {
+	return &httpTunnelWriter{writer: t.SimpleTunnel.AcquireWriter(), peer: t.peer}
+}
+
+// Close closes the peer of the tunnel
+func (t *httpTunnel) Close() error {
+	t.peer.Close(errHTTPTunnelClosed, 1)
+	return nil
+}
+
+// httpTunnelReader reads the instructions of guacd for the client
+type httpTunnelReader struct {
+	guacamole.InstructionReader
+
+	peer *peer
+
+	// Weather the close reason was already returned
+	reasonSent bool
+}
+
+// ReadSome returns the next instruction of guacd. After the peer was closed
+// the reason is returned as an error instruction if the user has to see it.
+//
+// Instructions of the controller are returned before the next instruction of guacd
+func (r *httpTunnelReader) ReadSome() ([]byte, error) {
+	if pending := r.peer.guacamole.takePending(); len(pending) > 0 {
+		return pending, nil
+	}
+
+	ins, err := r.InstructionReader.ReadSome()
+	if err != nil {
+		if reason := r.peer.closeErr.Load(); reason != nil && isClientVisible(*reason) && !r.reasonSent {
+			r.reasonSent = true
+			return errorInstruction(*reason), nil
+		}
+
+		return nil, err
+	}
+
+	r.peer.traffic.countOut(len(ins))
+	r.peer.traffic.countFrames(ins)
+	metrics.ProxiedBytes.WithLabelValues(ProtocolGuacamole, metrics.DirectionToClient).Add(float64(len(ins)))
+
+	return ins, nil
+}
+
+// httpTunnelWriter writes the instructions of the client to guacd. Instructions
+// that are handled by the peer itself are not passed to guacd
+type httpTunnelWriter struct {
+	writer io.Writer
+
+	peer *peer
+
+	// Incomplete instruction of the last write
+	pending []byte
+}
+
+func (w *httpTunnelWriter) Write(data []byte) (int, error) {
+	w.pending = append(w.pending, data...)
+
+	// The client sends multiple instructions within a single request
+	out := make([]byte, 0, len(w.pending))
+	for {
+		ins, rest, err := guacamole.NextInstruction(w.pending)
+		if err != nil {
+			return 0, err
+		}
+		if ins == nil {
+			break
+		}
+		w.pending = rest
+
+		if !w.peer.interceptInstruction(ins) {
+			out = append(out, ins...)
+		}
+	}
+
+	if len(out) > 0 {
+		if _, err := w.writer.Write(out); err != nil {
+			return 0, err
+		}
+	}
+
+	w.peer.traffic.countIn(len(data))
+	metrics.ProxiedBytes.WithLabelValues(ProtocolGuacamole, metrics.DirectionToSession).Add(float64(len(data)))
+
+	return len(data), nil
+}
+
+// ProxyHTTPTunnel serves the guacamole HTTP tunnel for the given user. It's used
+// by clients that cannot establish a WebSocket connection
+func (vnc *VncProxy) ProxyHTTPTunnel(w http.ResponseWriter, r *http.Request, user *models.User) {
+	// The tunnel lives on the replica that owns the session
+	if vnc.ForwardToOwner(user, w, r) {
+		return
+	}
+
+	vnc.httpTunnelServer.ServeHTTP(w, r)
+}
+
+// connectHTTPTunnel connects the user of the given "connect" request to its
+// session and returns the tunnel to guacd.
+//
+// The connection parameters are sent within the request body
+func (vnc *VncProxy) connectHTTPTunnel(r *http.Request) (guacamole.Tunnel, error) {
+	user := r.Context().Value(models.KeyUser).(*models.User)
+
+	if err := r.ParseForm(); err != nil {
+		return nil, guacamole.ErrClient.NewError("Invalid connection parameters", err.Error())
+	}
+	settings := NewVncConnectionSettings(r.PostForm)
+
+	// Validate the user request
+	if err := vnc.validateUserRequest(user, settings.Takeover); err != nil {
+		return nil, tunnelError(err)
+	}
+	if settings.Takeover {
+		vnc.takeoverSession(user, r)
+	}
+	if err := vnc.acquireSession(user); err != nil {
+		return nil, tunnelError(err)
+	}
+
+	peer := NewPeer(user, vnc.onPeerDisconnect)
+	if err := vnc.connectPeer(peer, r, nil, true, settings); err != nil {
+		// There is no client connection that would close the peer
+		peer.ready.Store(true)
+		peer.Close(err, 1)
+		return nil, tunnelError(err)
+	}
+
+	logger.Info("Opened HTTP tunnel for user %q (%s): %s", user.Username, user.Database, r.RemoteAddr)
+	return &httpTunnel{SimpleTunnel: peer.guacamole.Tunnel, peer: peer}, nil
+}
+
+// tunnelError converts the given error into an error of the guacamole tunnel
+func tunnelError(err error) error {
+	errResponse, ok := err.(errors.ErrorResponse)
+	if !ok {
+		return guacamole.ErrUpstream.NewError(err.Error())
+	}
+
+	switch errResponse.Status {
+	case 409:
+		return guacamole.ErrSessionConflict.NewError(errResponse.Message)
+	case 403:
+		return guacamole.ErrSecurity.NewError(errResponse.Message)
+	default:
+		return guacamole.ErrServer.NewError(errResponse.Message)
+	}
+}
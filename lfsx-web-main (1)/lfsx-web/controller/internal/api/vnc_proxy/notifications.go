@@ -0,0 +1,161 @@
+package vnc
+
+import (
+	"fmt"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/go-webserver/errors"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
+)
+
+// Time a notification without a countdown is shown to users that connect later
+const defaultNotificationDuration = time.Hour
+
+// Interval in which the notifications broadcasted by other replicas are delivered
+const notificationSyncInterval = 5 * time.Second
+
+// Notifications returns all notifications that are still shown to connecting users
+func (vnc *VncProxy) Notifications() ([]models.Notification, error) {
+	return vnc.notifications.Notifications()
+}
+
+// Broadcast sends the given notification to all users connected to this replica and
+// stores it for the users that connect before it expires. The other replicas deliver
+// the stored notification to their users
+func (vnc *VncProxy) Broadcast(admin *models.User, notification models.Notification) (models.Notification, error) {
+	id, err := utils.GenerateRandomString(16)
+	if err != nil {
+		return notification, fmt.Errorf("failed to generate an ID: %s", err)
+	}
+	notification.ID = id
+	notification.CreatedAt = time.Now()
+
+	if notification.Severity == "" {
+		notification.Severity = models.NotificationInfo
+	}
+	if notification.ExpiresAt.IsZero() {
+		if notification.CountdownTo != nil {
+			notification.ExpiresAt = *notification.CountdownTo
+		} else {
+			notification.ExpiresAt = notification.CreatedAt.Add(defaultNotificationDuration)
+		}
+	}
+
+	if err := notification.Validate(); err != nil {
+		return notification, errors.BadRequest(err.Error())
+	}
+	if !notification.IsActive(time.Now()) {
+		return notification, errors.BadRequest("The notification is already expired")
+	}
+
+	// Keep the notification for users connecting later
+	if err := vnc.notifications.AddNotification(notification); err != nil {
+		return notification, err
+	}
+
+	logger.Info("Administrator %q broadcasts a notification (%s): %s", admin.Username, notification.Severity, notification.Text)
+	vnc.deliverNotifications(notification)
+
+	return notification, nil
+}
+
+// activeNotifications returns the notifications that are shown to connecting users.
+// Errors are only logged because the connection doesn't depend on them
+func (vnc *VncProxy) activeNotifications() []models.Notification {
+	notifications, err := vnc.notifications.Notifications()
+	if err != nil {
+		logger.Warning("Failed to get the notifications: %s", err)
+	}
+
+	return notifications
+}
+
+// runNotificationWatcher delivers the notifications that were broadcasted by other
+// replicas to the users connected to this replica until the base context is canceled.
+//
+// This method does block
+func (vnc *VncProxy) runNotificationWatcher() {
+	ticker := time.NewTicker(notificationSyncInterval)
+	defer ticker.Stop()
+
+	// Users connecting later receive the existing notifications on connect
+	vnc.deliverNotifications(vnc.activeNotifications()...)
+
+	for {
+		select {
+		case <-ticker.C:
+			notifications := vnc.activeNotifications()
+			vnc.deliverNotifications(notifications...)
+			vnc.forgetExpiredNotifications(notifications)
+		case <-vnc.baseContext.Done():
+			return
+		}
+	}
+}
+
+// forgetExpiredNotifications removes the delivered notifications that aren't
+// contained in the given active ones anymore
+func (vnc *VncProxy) forgetExpiredNotifications(active []models.Notification) {
+	ids := make(map[string]bool, len(active))
+	for _, n := range active {
+		ids[n.ID] = true
+	}
+
+	vnc.deliveredLock.Lock()
+	defer vnc.deliveredLock.Unlock()
+	for id := range vnc.delivered {
+		if !ids[id] {
+			delete(vnc.delivered, id)
+		}
+	}
+}
+
+// deliverNotifications sends the given notifications that weren't delivered yet
+// to all users connected to this replica
+func (vnc *VncProxy) deliverNotifications(notifications ...models.Notification) {
+	vnc.deliveredLock.Lock()
+	pending := make([]models.Notification, 0, len(notifications))
+	for _, n := range notifications {
+		if !vnc.delivered[n.ID] {
+			vnc.delivered[n.ID] = true
+			pending = append(pending, n)
+		}
+	}
+	vnc.deliveredLock.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	vnc.peerSync.RLock()
+	peers := make([]*peer, 0, len(vnc.peer))
+	for _, p := range vnc.peer {
+		peers = append(peers, p)
+	}
+	vnc.peerSync.RUnlock()
+
+	for _, p := range peers {
+		p.sendNotifications(true, vnc.config.NotifyLfsx, pending...)
+	}
+}
+
+// sendNotifications sends the given notifications to the client and / or the LFS.X
+func (p *peer) sendNotifications(toClient bool, toLfsx bool, notifications ...models.Notification) {
+	if len(notifications) == 0 || p.lfsxPeer == nil {
+		return
+	}
+
+	messages := make([]models.WebSocketMessage, 0, len(notifications))
+	for _, n := range notifications {
+		messages = append(messages, models.NewNotification(n))
+	}
+
+	if toClient && p.lfsxPeer.HasClient() {
+		p.lfsxPeer.SendMessageToClient(models.NewWebSocketData(0, messages...))
+	}
+	if toLfsx {
+		p.lfsxPeer.SendMessageToLFS(models.NewWebSocketData(0, messages...))
+	}
+}
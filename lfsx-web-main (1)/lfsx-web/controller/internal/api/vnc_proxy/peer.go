@@ -2,17 +2,22 @@ package vnc
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
 	"net/http/httputil"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/backend"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/guacamole"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/metrics"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
 	"github.com/lesismal/nbio"
 	"github.com/lesismal/nbio/nbhttp/websocket"
@@ -38,6 +43,31 @@ type peer struct {
 	// The request user
 	user *models.User
 
+	// The session (pod) the peer is connected to
+	session *backend.Session
+
+	// Time when the client did connect
+	connectedAt time.Time
+
+	// Traffic between the client and the session
+	traffic trafficCounter
+
+	// Applies the size of the browser to the session
+	resize resizer
+
+	// Synchronizes the clipboard of the client with the session
+	clipboard clipboardBridge
+
+	// Files the guacamole client uploads into the session
+	uploads fileUploads
+
+	// Stops the watchers of the session
+	stopWatchers     context.CancelFunc
+	stopWatchersLock sync.Mutex
+
+	// Weather the client was told that the session runs an outdated image version
+	outdatedNotified atomic.Bool
+
 	// The Peer to the LFS.X Kubernetes WebSocket
 	lfsxPeer *lfsxPeer
 
@@ -57,6 +87,9 @@ type peer struct {
 	// A reverse proxy to the host API
 	hostAPI *httputil.ReverseProxy
 
+	// The reason this peer was closed with
+	closeErr atomic.Pointer[error]
+
 	// function that is called when this peer goes offline
 	onDisconnect func(*peer, error, int)
 }
@@ -69,6 +102,7 @@ type peer struct {
 func NewPeer(user *models.User, onDisconnect func(*peer, error, int)) *peer {
 	return &peer{
 		user:         user,
+		connectedAt:  time.Now(),
 		onDisconnect: onDisconnect,
 	}
 }
@@ -77,6 +111,58 @@ const InternalDataOpcode = ""
 
 var internalOpcodeIns = []byte(fmt.Sprint(len(InternalDataOpcode), ".", InternalDataOpcode))
 
+// Close reason of a peer whose session was taken over by another connection
+const SessionTakenOver = "SESSION_TAKEN_OVER"
+
+// takeoverError is the close reason of a peer whose session was taken over
+// by a new connection
+type takeoverError struct {
+	remoteAddr string
+	userAgent  string
+}
+
+func (e takeoverError) Error() string {
+	return closeReason(fmt.Sprintf("%s: %s (%s)", SessionTakenOver, e.remoteAddr, e.userAgent))
+}
+
+// Close reason of a peer whose session was closed by an administrator
+const SessionClosedByAdmin = "SESSION_CLOSED_BY_ADMIN"
+
+// adminCloseError is the close reason of a peer that was closed by an administrator
+type adminCloseError struct {
+	reason string
+}
+
+func (e adminCloseError) Error() string {
+	return closeReason(fmt.Sprintf("%s: %s", SessionClosedByAdmin, e.reason))
+}
+
+// closeReason truncates the given reason to the maximum length
+// of a WebSocket close message (123 bytes)
+func closeReason(reason string) string {
+	if len(reason) > 123 {
+		return reason[:123]
+	}
+	return reason
+}
+
+// isClientVisible returns weather the given close reason has to be shown to the user
+func isClientVisible(err error) bool {
+	switch err.(type) {
+	case takeoverError, adminCloseError:
+		return true
+	}
+
+	return false
+}
+
+// errorInstruction returns the guacamole error instruction that shows the
+// given close reason to the user
+func errorInstruction(err error) []byte {
+	code := strconv.Itoa(guacamole.SessionConflict.GetGuacamoleStatusCode())
+	return guacamole.NewInstruction("error", err.Error(), code).Byte()
+}
+
 // SetConnection is setting both connections for the peer
 // and making it so ready for usage
 func (p *peer) SetConnections(source *websocket.Conn, target *nbio.Conn, targetGuacamole *net.Conn, lfsx *httputil.ReverseProxy, hostAPI *httputil.ReverseProxy) {
@@ -90,6 +176,46 @@ func (p *peer) SetConnections(source *websocket.Conn, target *nbio.Conn, targetG
 	p.ready.Store(true)
 }
 
+// Time to wait before the session is watched again after an error
+const watchRetryDelay = 5 * time.Second
+
+// startWatching starts watching the clipboard, the outbox and the requested uploads of the session
+// until the peer is closed
+func (p *peer) startWatching(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	p.stopWatchersLock.Lock()
+	p.stopWatchers = cancel
+	p.stopWatchersLock.Unlock()
+
+	// The peer may be closed in the meantime
+	if p.closed.Load() {
+		cancel()
+		return
+	}
+
+	go p.watchSessionClipboard(ctx)
+	if !p.guacamole.Used && p.lfsxPeer != nil {
+		go p.receiveClipboardMessages(ctx)
+	}
+	if p.lfsxPeer != nil {
+		go p.watchDownloads(ctx)
+	}
+	if p.guacamole.Used && p.lfsxPeer != nil {
+		go p.watchUploadRequests(ctx)
+	}
+}
+
+// stopWatching stops all watchers of the session
+func (p *peer) stopWatching() {
+	p.stopWatchersLock.Lock()
+	defer p.stopWatchersLock.Unlock()
+
+	if p.stopWatchers != nil {
+		p.stopWatchers()
+	}
+}
+
 // IsReady returns weather this peer is ready
 func (p *peer) IsReady() bool {
 	return p.ready.Load()
@@ -125,6 +251,9 @@ func (p *peer) Close(err error, fromWhich int) {
 	} else {
 		p.closed.Store(true)
 	}
+	if err != nil {
+		p.closeErr.Store(&err)
+	}
 
 	// We wait until all connections are fully initialized. In some scenarious the disconnect happens right
 	// after connecting to the LFS.X or VNC connection.
@@ -152,6 +281,9 @@ func (p *peer) Close(err error, fromWhich int) {
 		logger.Warning("Needed to wait %d milliseconds until connections could be closed", 400*i)
 	}
 
+	p.stopWatching()
+	p.uploads.abort()
+
 	// Close the LFS.X peer if available
 	if p.lfsxPeer != nil {
 		// We don't pass the information from which the connection was closed further down.
@@ -164,6 +296,12 @@ func (p *peer) Close(err error, fromWhich int) {
 		// Close the WebSocket with a reason if one was given.
 		// When we receive a nil error, the connection was closed on the server side
 		if err != nil {
+			// Guacamole clients don't expose the reason of a close message. So send the reasons that are shown to the user
+			// additionally as an error instruction
+			if isClientVisible(err) && p.guacamole.Used {
+				p.source.WriteMessage(websocket.TextMessage, errorInstruction(err))
+			}
+
 			var codeBytes = make([]byte, 2)
 			binary.BigEndian.PutUint16(codeBytes, 1008)
 			codeBytes = append(codeBytes, err.Error()...)
@@ -196,6 +334,10 @@ func (p *peer) OnSourceMessage(c *websocket.Conn, messageType websocket.MessageT
 	if p.guacamole.Used {
 		if bytes.HasPrefix(data, internalOpcodeIns) {
 			// messages starting with the InternalDataOpcode are never sent to guacd
+			p.onInternalInstruction(data)
+			return
+		}
+		if p.interceptInstruction(data) {
 			return
 		}
 
@@ -206,9 +348,95 @@ func (p *peer) OnSourceMessage(c *websocket.Conn, messageType websocket.MessageT
 	} else {
 		if _, err := p.target.Write(data); err != nil {
 			logger.Warning("Failed to write message to VNC backend for user %q: %s", p.user.Username, err)
+			return
 		}
 	}
 
+	p.traffic.countIn(len(data))
+	metrics.ProxiedBytes.WithLabelValues(p.protocol(), metrics.DirectionToSession).Add(float64(len(data)))
+
+}
+
+// onInternalInstruction handles an instruction of the guacamole client that is addressed
+// to the tunnel itself.
+//
+// The client pings the tunnel periodically and closes the connection when
+// the ping isn't answered
+func (p *peer) onInternalInstruction(data []byte) {
+	ins, err := guacamole.Parse(data)
+	if err != nil {
+		logger.Debug("Received an invalid internal instruction from the guacamole client: %s", err)
+		return
+	}
+
+	if len(ins.Args) >= 2 && ins.Args[0] == "ping" {
+		pong := guacamole.NewInstruction(InternalDataOpcode, "ping", ins.Args[1])
+		if err := p.source.WriteMessage(websocket.TextMessage, pong.Byte()); err != nil {
+			logger.Debug("Failed to answer the ping of the guacamole client: %s", err)
+		}
+	}
+}
+
+// Start of the client instructions that are handled by the controller
+var (
+	sizeInstruction      = []byte("4.size,")
+	clipboardInstruction = []byte("9.clipboard,")
+	blobInstruction      = []byte("4.blob,")
+	endInstruction       = []byte("3.end,")
+	ackInstruction       = []byte("3.ack,")
+	fileInstruction      = []byte("4.file,")
+)
+
+// interceptInstruction handles the instructions of the guacamole client that are not
+// passed to guacd. It returns true when the instruction was handled
+func (p *peer) interceptInstruction(data []byte) bool {
+	if !bytes.HasPrefix(data, sizeInstruction) && !bytes.HasPrefix(data, clipboardInstruction) &&
+		!bytes.HasPrefix(data, blobInstruction) && !bytes.HasPrefix(data, endInstruction) &&
+		!bytes.HasPrefix(data, ackInstruction) && !bytes.HasPrefix(data, fileInstruction) {
+		return false
+	}
+
+	ins, err := guacamole.Parse(data)
+	if err != nil {
+		logger.Debug("Received an invalid instruction from the guacamole client of user %q: %s", p.user.Username, err)
+		return false
+	}
+
+	switch ins.Opcode {
+	case "size":
+		p.onSizeInstruction(ins)
+		return true
+	case "clipboard":
+		p.clipboard.onStream(p, ins)
+		return true
+	case "file":
+		p.uploads.onStream(p, ins)
+		return true
+	case "blob", "end", "ack":
+		// Only the clipboard and file streams are handled
+		return p.clipboard.onStreamData(p, ins) || p.uploads.onStreamData(p, ins)
+	}
+
+	return false
+}
+
+// sendInstructions sends the given instructions of the controller to the
+// guacamole client
+func (p *peer) sendInstructions(instructions ...*guacamole.Instruction) {
+	var data []byte
+	for _, ins := range instructions {
+		data = append(data, ins.Byte()...)
+	}
+
+	// HTTP tunnel clients receive the instructions with the next read request
+	if p.source == nil {
+		p.guacamole.queue(data)
+		return
+	}
+
+	if err := p.source.WriteMessage(websocket.TextMessage, data); err != nil {
+		logger.Debug("Failed to send instructions to the guacamole client of user %q: %s", p.user.Username, err)
+	}
 }
 
 // OnSourceMessage handles the proxing of a message that was received from the VNC backend
@@ -220,18 +448,44 @@ func (p *peer) OnTargetMessage(c *nbio.Conn, data []byte) {
 
 	if err := p.source.WriteMessage(websocket.BinaryMessage, data); err != nil {
 		logger.Warning("Failed to write messsage to WebSocket client %q: %s", p.user.Username, err)
+		return
+	}
+
+	p.traffic.countOut(len(data))
+	metrics.ProxiedBytes.WithLabelValues(ProtocolVnc, metrics.DirectionToClient).Add(float64(len(data)))
+}
+
+// protocol returns the protocol the client uses to connect to the session
+func (p *peer) protocol() string {
+	if p.targetGuacamole != nil {
+		return ProtocolGuacamole
+	}
+
+	return ProtocolVnc
+}
+
+// notifyClient sends the given message to the client over the LFS.X WebSocket
+func (p *peer) notifyClient(msg models.WebSocketMessage) {
+	if p.lfsxPeer == nil {
+		logger.Debug("Not sending %q to the client because no LFS.X WebSocket is available", msg.Type)
+		return
 	}
+
+	p.lfsxPeer.SendMessageToClient(models.NewWebSocketData(0, msg))
 }
 
-// proxyGuacamole establishes a connection to guacamole
-func (p *peer) proxyGuacamole(quality string) error {
+// proxyGuacamole establishes a connection to guacamole.
+//
+// If a recording name is given, guacd records the session into the given
+// path (inside the session)
+func (p *peer) proxyGuacamole(quality string, recordingPath string, recordingName string) error {
 	p.guacamole.Used = true
 
 	// Generate config
 	config := guacamole.NewGuacamoleConfiguration()
 	config.Protocol = "vnc"
 	config.Parameters["hostname"] = "127.0.0.1"
-	config.Parameters["port"] = "5910"
+	config.Parameters["port"] = strconv.Itoa(p.session.VncPort)
 	config.Parameters["cursor"] = "local"
 	config.Parameters["autoretry"] = "true"
 
@@ -250,6 +504,40 @@ func (p *peer) proxyGuacamole(quality string) error {
 
 	config.AudioMimetypes = []string{"audio/L16", "rate=44100", "channels=2"}
 
+	// The VNC clipboard only supports Latin-1. The clipboard is exchanged by the controller instead
+	config.Parameters["disable-copy"] = "true"
+	config.Parameters["disable-paste"] = "true"
+
+	// Record the session
+	if recordingName != "" {
+		config.Parameters["recording-path"] = recordingPath
+		config.Parameters["create-recording-path"] = "true"
+		config.Parameters["recording-name"] = recordingName
+	}
+
+	return p.proxyGuacamoleConfig(config)
+}
+
+// joinGuacamole joins the existing guacd connection with the given ID read-only.
+// All inputs of this peer are ignored by guacd
+func (p *peer) joinGuacamole(connectionID string) error {
+	p.guacamole.Used = true
+
+	config := guacamole.NewGuacamoleConfiguration()
+	config.ConnectionID = connectionID
+	config.Parameters["read-only"] = "true"
+	config.ImageMimetypes = []string{"image/webp", "image/jpeg", "image/png"}
+
+	return p.proxyGuacamoleConfig(config)
+}
+
+// proxyGuacamoleConfig connects to guacd with the given configuration and
+// proxies all messages between guacd and the WebSocket client.
+//
+// Peers without a WebSocket client (HTTP tunnel) are only connected. The client
+// reads and writes the messages through the tunnel of the peer
+func (p *peer) proxyGuacamoleConfig(config *guacamole.Config) error {
+
 	// Connect to guacd
 	logger.Debug("Connecting to guacd")
 	stream := guacamole.NewStream(*p.targetGuacamole, KeepAliveTimeout)
@@ -258,10 +546,20 @@ func (p *peer) proxyGuacamole(quality string) error {
 	}
 	p.guacamole.Stream = stream
 
+	// Create tunnel
+	tunnel := guacamole.NewSimpleTunnel(stream)
+	p.guacamole.Tunnel = tunnel
+	if p.source == nil {
+		return nil
+	}
+
+	// The client identifies the tunnel by the UUID that is sent as the first instruction
+	if err := p.source.WriteMessage(websocket.TextMessage, guacamole.NewInstruction(InternalDataOpcode, tunnel.GetUUID()).Byte()); err != nil {
+		return fmt.Errorf("failed to send the UUID of the tunnel: %s", err)
+	}
+
 	// Proxy from WebSocket -> Guacd
 	go func() {
-		// Create tunnel
-		tunnel := guacamole.NewSimpleTunnel(stream)
 		writer := tunnel.AcquireWriter()
 		p.guacamole.Writer = writer
 		reader := tunnel.AcquireReader()
@@ -272,6 +570,7 @@ func (p *peer) proxyGuacamole(quality string) error {
 
 		// Proxy from Guacd -> WebSocket
 		buf := bytes.NewBuffer(make([]byte, 0, guacamole.MaxGuacMessage*2))
+		proxiedBytes := metrics.ProxiedBytes.WithLabelValues(ProtocolGuacamole, metrics.DirectionToClient)
 
 		for {
 			ins, err := reader.ReadSome()
@@ -295,6 +594,7 @@ func (p *peer) proxyGuacamole(quality string) error {
 				logger.Debug("Failed to buffer guacd to ws: %s", err)
 				return
 			}
+			p.traffic.countFrames(ins)
 
 			// if the buffer has more data in it or we've reached the max buffer size, send the data and reset
 			if !reader.Available() || buf.Len() >= guacamole.MaxGuacMessage {
@@ -307,6 +607,8 @@ func (p *peer) proxyGuacamole(quality string) error {
 					}
 					return
 				}
+				p.traffic.countOut(buf.Len())
+				proxiedBytes.Add(float64(buf.Len()))
 				buf.Reset()
 			}
 		}
@@ -5,13 +5,14 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"net"
 	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/backend"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/metrics"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
 	"github.com/lesismal/nbio/nbhttp"
 	"github.com/lesismal/nbio/nbhttp/websocket"
@@ -29,8 +30,8 @@ type lfsxPeer struct {
 
 	// Websocket backend connection to the LFS.X
 	target *websocket.Conn
-	// The raw IP address of the remote pod to connect to
-	targetIp *net.UDPAddr
+	// The session (pod) to connect to
+	targetSession *backend.Session
 	// Mutext to synchronize the connection access
 	targetSync sync.Mutex
 
@@ -48,6 +49,9 @@ type lfsxPeer struct {
 	// If this peer should not reconnect again
 	wasIntentiallyClosed atomic.Bool
 
+	// Weather the WebSocket to the LFS.X is currently established
+	connected atomic.Bool
+
 	// Function that is called when this peer goes offline
 	onDisconnect func(*lfsxPeer, error, int)
 
@@ -57,14 +61,14 @@ type lfsxPeer struct {
 	// The ping pong manager
 	pingPong *ClientMgr
 
-	// All observers of the update chanel
-	observers    []chan Update
-	observerLock sync.RWMutex
+	// All subscriptions to the received messages
+	subscriptions    map[*subscription]struct{}
+	subscriptionLock sync.RWMutex
 }
 
-// Update is used to notify all observers for a new Message from the WebSocket.
-// This can either be the client or the LFS.X. See the field 'FromLfsx' if it's
-// coming from the LFS.X
+// Update is used to notify all subscribers for a new Message from the WebSocket.
+// This can either be the client or the LFS.X. See the field 'From' where it's
+// coming from
 type Update struct {
 
 	// The message that was send from the client
@@ -76,8 +80,24 @@ type Update struct {
 	// The ID of the message to which a reply was send
 	ResponseTo int
 
-	// Toggles weather the message came from the LFS.X
-	FromLfsx bool
+	// Who did send the message (models.FromLfsx or models.FromClient)
+	From models.Direction
+}
+
+// TypedUpdate is an update with the payload of the subscribed message type
+type TypedUpdate[T any] struct {
+	Update
+
+	Payload *T
+}
+
+// subscription receives the messages of a single type from a single direction
+type subscription struct {
+	key  string
+	from models.Direction
+
+	// Called for every matching message
+	deliver func(up Update)
 }
 
 // NewLfsxPeer creates an empty peer for the given root per with only
@@ -87,7 +107,7 @@ type Update struct {
 // "SetClientConnection()" after the client did connect.
 //
 // This method does block until the LFS.X connection could be established.
-func NewLfsxPeer(root *peer, ctx context.Context, targetIp *net.UDPAddr, pingPong *ClientMgr, onDisconnect func(*lfsxPeer, error, int)) (*lfsxPeer, error) {
+func NewLfsxPeer(root *peer, ctx context.Context, targetSession *backend.Session, pingPong *ClientMgr, onDisconnect func(*lfsxPeer, error, int)) (*lfsxPeer, error) {
 
 	// Branch a new context from the base context that is used for canceling all connections
 	baseCtx, cancelBaseCtx := context.WithCancel(ctx)
@@ -110,7 +130,7 @@ func NewLfsxPeer(root *peer, ctx context.Context, targetIp *net.UDPAddr, pingPon
 		baseContext:   baseCtx,
 		cancelContext: cancelBaseCtx,
 		engine:        engine,
-		targetIp:      targetIp,
+		targetSession: targetSession,
 		pingPong:      pingPong,
 	}
 
@@ -173,7 +193,7 @@ func (p *lfsxPeer) connectToLfsxSocket() error {
 		}
 
 		// Open connection
-		con, _, err := dialer.Dial(fmt.Sprintf("ws://%s:8888/kubernetes", p.targetIp.IP), nil)
+		con, _, err := dialer.Dial(fmt.Sprintf("ws://%s/kubernetes", p.targetSession.LfsxAddress()), nil)
 		if err == nil {
 			p.target = con
 			break
@@ -197,54 +217,80 @@ func (p *lfsxPeer) connectToLfsxSocket() error {
 
 	p.target.SetReadDeadline(time.Now().Add(KeepAliveTimeout))
 	p.pingPong.Add(p.target)
+	p.connected.Store(true)
 
 	logger.Debug("Connected to the LFS.X")
 	return nil
 }
 
-// notifyForUpdates notifies all observer for an update
-func (p *lfsxPeer) notifyForUpdates(fromLfsx bool, data *models.WebSocketData) {
-	p.observerLock.RLock()
-	defer p.observerLock.RUnlock()
+// notifyForUpdates notifies all subscribers of the messages within the given data
+func (p *lfsxPeer) notifyForUpdates(from models.Direction, data *models.WebSocketData) {
+	p.subscriptionLock.RLock()
+	defer p.subscriptionLock.RUnlock()
 
-	for _, obs := range p.observers {
+	for sub := range p.subscriptions {
 
 		// Send an own message for every message in the upper data struct
 		for i := range data.Messages {
-			msg := Update{Message: data.Messages[i], FromLfsx: fromLfsx, ID: data.ID, ResponseTo: data.ResponseTo}
-			go func(c chan Update) {
-				c <- msg
-			}(obs)
+			if data.Messages[i].Type != sub.key || sub.from&from == 0 {
+				continue
+			}
+
+			up := Update{Message: data.Messages[i], From: from, ID: data.ID, ResponseTo: data.ResponseTo}
+			go sub.deliver(up)
 		}
 	}
 }
 
-// RegisterObserver returns a new channel that is filled when an update
-// of the data occur.
-// You can check the models.Update methods to get more exact update details
-func (p *lfsxPeer) RegisterObserver() chan Update {
-	p.observerLock.Lock()
-	defer p.observerLock.Unlock()
+// Subscribe returns a channel that receives all messages of the given type that were
+// sent from the given direction (models.FromLfsx and / or models.FromClient).
+//
+// The subscription ends when the given context is done. The channel is never closed,
+// so the receiver has to watch the context as well
+func Subscribe[T any](ctx context.Context, p *lfsxPeer, msgType models.MessageType[T], from models.Direction) <-chan TypedUpdate[T] {
+	c := make(chan TypedUpdate[T])
+
+	sub := &subscription{key: msgType.Key, from: from, deliver: func(up Update) {
+		payload, _ := msgType.Payload(up.Message)
+		select {
+		case c <- TypedUpdate[T]{Update: up, Payload: payload}:
+		case <-ctx.Done():
+		}
+	}}
+
+	p.subscriptionLock.Lock()
+	if p.subscriptions == nil {
+		p.subscriptions = make(map[*subscription]struct{})
+	}
+	p.subscriptions[sub] = struct{}{}
+	p.subscriptionLock.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		p.subscriptionLock.Lock()
+		delete(p.subscriptions, sub)
+		p.subscriptionLock.Unlock()
+	}()
 
-	c := make(chan Update)
-	p.observers = append(p.observers, c)
 	return c
 }
 
-// RemoveObserver removes the given observer from the internal observers
-// lists and closed the channel
-func (p *lfsxPeer) RemoveObserver(c chan Update) {
-	p.observerLock.Lock()
-	defer p.observerLock.Unlock()
-
-	// Find the observer and remove it
-	for i := range p.observers {
-		if p.observers[i] == c {
-			p.observers = append(p.observers[:i], p.observers[i+1:]...)
-			close(c)
-			break
+// validMessages returns the given data with only the messages that are valid
+// when they were sent from the given direction. Invalid messages are logged
+func (p *lfsxPeer) validMessages(from models.Direction, data models.WebSocketData) (models.WebSocketData, bool) {
+	valid := make([]models.WebSocketMessage, 0, len(data.Messages))
+	for _, msg := range data.Messages {
+		if err := models.ValidateMessage(msg, from); err != nil {
+			logger.Warning("Dropping invalid WebSocket message of user %q: %s", p.root.user.Username, err)
+			continue
 		}
+		valid = append(valid, msg)
 	}
+
+	hasDropped := len(valid) != len(data.Messages)
+	data.Messages = valid
+	return data, hasDropped
 }
 
 // newUpgraderForLfsx creates an upgrader for the WebSocket connection to the LFS.X
@@ -311,8 +357,10 @@ func (p *lfsxPeer) tryToReconnectToLfsx() {
 		logger.Debug("Connection to LFS.X WebSocket was intentially closed. Not trying to reconnect")
 	} else {
 		logger.Debug("Trying to reconnect to the LFS.X in 5 seconds")
+		metrics.LfsxReconnects.Inc()
 
 		// Set connection to nil
+		p.connected.Store(false)
 		p.targetSync.Lock()
 		p.target = nil
 		p.targetSync.Unlock()
@@ -331,6 +379,11 @@ func (p *lfsxPeer) tryToReconnectToLfsx() {
 	}
 }
 
+// IsConnected returns weather the WebSocket to the LFS.X is currently established
+func (p *lfsxPeer) IsConnected() bool {
+	return p.connected.Load()
+}
+
 // SetClientConnection is setting the client connection
 // and making it so ready for the "full" usage
 func (p *lfsxPeer) SetClientConnection(source *websocket.Conn) {
@@ -355,6 +408,7 @@ func (p *lfsxPeer) Close(err error, fromWhich int) {
 		return
 	} else {
 		p.wasIntentiallyClosed.Store(true)
+		p.connected.Store(false)
 	}
 
 	if p.source != nil && fromWhich != 1 {
@@ -395,6 +449,29 @@ func (p *lfsxPeer) SendMessageToLFS(msg models.WebSocketData) {
 	}
 }
 
+// HasClient returns weather a client is connected to the WebSocket
+func (p *lfsxPeer) HasClient() bool {
+	p.sourceSync.Lock()
+	defer p.sourceSync.Unlock()
+
+	return p.source != nil && !p.wasIntentiallyClosed.Load()
+}
+
+// SendMessageToClient sends the given message to the client WebSocket
+func (p *lfsxPeer) SendMessageToClient(msg models.WebSocketData) {
+	p.sourceSync.Lock()
+	defer p.sourceSync.Unlock()
+
+	if p.wasIntentiallyClosed.Load() || p.source == nil {
+		logger.Debug("Not sending WebSocket Message to the client because no client is connected")
+		return
+	}
+
+	if err := p.source.WriteMessage(websocket.TextMessage, msg.ToJson()); err != nil {
+		logger.Warning("Failed to write message to the client for user %q: %s", p.root.user.Username, err)
+	}
+}
+
 // OnSourceMessage handles the proxing of a message that was received from the client WebSocket
 // to the backend: Client => LFS.X
 func (p *lfsxPeer) OnSourceMessage(c *websocket.Conn, messageType websocket.MessageType, data []byte) {
@@ -408,7 +485,14 @@ func (p *lfsxPeer) OnSourceMessage(c *websocket.Conn, messageType websocket.Mess
 		logger.Warning("Failed to convert message from the client: %s", err)
 		return
 	}
-	p.notifyForUpdates(false, &wsData)
+	wsData, hasDropped := p.validMessages(models.FromClient, wsData)
+	if hasDropped {
+		if len(wsData.Messages) == 0 {
+			return
+		}
+		data = wsData.ToJson()
+	}
+	p.notifyForUpdates(models.FromClient, &wsData)
 
 	// The other side is not yet available -> don't proxy message
 	if !p.ready.Load() || p.target == nil {
@@ -433,7 +517,14 @@ func (p *lfsxPeer) OnTargetMessage(c *websocket.Conn, messageType websocket.Mess
 		logger.Warning("Failed to convert message from the LFS.X: %s", err)
 		return
 	}
-	p.notifyForUpdates(true, &wsData)
+	wsData, hasDropped := p.validMessages(models.FromLfsx, wsData)
+	if hasDropped {
+		if len(wsData.Messages) == 0 {
+			return
+		}
+		data = wsData.ToJson()
+	}
+	p.notifyForUpdates(models.FromLfsx, &wsData)
 
 	// The other side is not yet available -> don't proxy message
 	if !p.ready.Load() || p.source == nil {
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/metrics"
 	"github.com/lesismal/nbio/nbhttp/websocket"
 )
 
@@ -51,6 +52,7 @@ func (cm *ClientMgr) Run() {
 				for wsConn := range cm.clients {
 					if err := wsConn.WriteMessage(websocket.PingMessage, nil); err != nil {
 						logger.Debug("Keepalive: closing connection to %q because of send error: %s", wsConn.RemoteAddr().String(), err)
+						metrics.PingFailures.Inc()
 
 						go func(con *websocket.Conn) {
 							if err := con.Close(); err != nil {
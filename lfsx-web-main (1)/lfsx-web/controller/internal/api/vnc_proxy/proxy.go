@@ -16,9 +16,12 @@ import (
 
 	"gitea.hama.de/LFS/go-logger"
 	"gitea.hama.de/LFS/go-webserver/errors"
-	"gitea.hama.de/LFS/lfsx-web/controller/internal/kuber"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/backend"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/guacamole"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/metrics"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
-	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/recording"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/registry"
 	"github.com/lesismal/nbio"
 	"github.com/lesismal/nbio/logging"
 	"github.com/lesismal/nbio/nbhttp/websocket"
@@ -30,14 +33,17 @@ import (
 // In addition to that it also provides a way to communicate with the
 // LFS.X API and the host API of that pod.
 //
-// Based on the currently authenticated user the IP address of the
-// already assigned pod will be fetched and a WebSocket connection
+// Based on the currently authenticated user the address of the
+// already assigned session (pod) will be fetched and a WebSocket connection
 // is served for the user.
-// If no User Pod is available a new Pod will be assigned for the user.
+// If no session is available a new session will be assigned for the user.
 type VncProxy struct {
 
-	// Kubernetes client
-	kuber *kuber.Kuber
+	// Backend providing the LFS.X sessions (pods) of the users
+	backend backend.SessionBackend
+
+	// Registry containing the controller replicas that own the sessions
+	registry registry.Registry
 
 	// Context used for this client
 	baseContext context.Context
@@ -50,6 +56,11 @@ type VncProxy struct {
 	// Manager for the Ping Pong messages
 	pingPongMgr ClientMgr
 
+	// Tunnels of the clients using the guacamole HTTP tunnel
+	httpTunnels *guacamole.TunnelMap
+	// Server implementing the HTTP tunnel protocol
+	httpTunnelServer *guacamole.HTTPTunnelServer
+
 	// Configuration of the app
 	config *models.AppConfig
 
@@ -58,14 +69,29 @@ type VncProxy struct {
 	peer map[string]*peer
 	// Sync to access the peers
 	peerSync sync.RWMutex
+
+	// Notifications of the administrators that are shown to connecting users
+	notifications registry.NotificationStore
+	// IDs of the notifications that were already sent to the connected users
+	delivered     map[string]bool
+	deliveredLock sync.Mutex
 }
 
+// WebSocket subprotocol of the guacamole tunnel
+const guacamoleSubprotocol = "guacamole"
+
+// Returned when the backend can't start a session because another one is running
+var errSessionLimit = errors.NewError("Another session is already running. Only a single session can run at a time", 409)
+
+// Header that is set on requests forwarded to another replica
+const headerForwardedBy = "X-Lfsx-Forwarded-By"
+
 // NewVncService initializes a new service to proxy
 // a "TCP VNC connection" <=> "WebSocket connection".
 //
 // When the given context is closed, all ressources are freeded
 // up created by this method.
-func NewVncProxy(ctx context.Context, kuber *kuber.Kuber, config *models.AppConfig) (*VncProxy, error) {
+func NewVncProxy(ctx context.Context, sessionBackend backend.SessionBackend, sessionRegistry registry.Registry, notifications registry.NotificationStore, config *models.AppConfig) (*VncProxy, error) {
 
 	// Set default logger that nbio should use
 	logging.DefaultLogger = newNbioLogger()
@@ -83,8 +109,12 @@ func NewVncProxy(ctx context.Context, kuber *kuber.Kuber, config *models.AppConf
 	vnc := &VncProxy{
 		engine:            engine,
 		pingPongMgr:       *NewClientMgr(KeepAliveTimeout, baseContext),
+		httpTunnels:       guacamole.NewTunnelMap(),
 		peer:              make(map[string]*peer),
-		kuber:             kuber,
+		backend:           sessionBackend,
+		registry:          sessionRegistry,
+		notifications:     notifications,
+		delivered:         make(map[string]bool),
 		config:            config,
 		baseContext:       baseContext,
 		cancelBaseContext: cancelBaseContext,
@@ -95,7 +125,13 @@ func NewVncProxy(ctx context.Context, kuber *kuber.Kuber, config *models.AppConf
 	if err != nil {
 		return nil, fmt.Errorf("failed to start engine for VNC connections: %s", err)
 	}
+	vnc.httpTunnelServer = guacamole.NewHTTPTunnelServer(vnc.httpTunnels, vnc.connectHTTPTunnel)
+
 	go vnc.pingPongMgr.Run()
+	go vnc.runTrafficSampler()
+	go vnc.runRolloutWatcher()
+	go vnc.runNotificationWatcher()
+	go vnc.httpTunnels.Run(baseContext)
 
 	// Assign the engine methods
 	engine.OnData(vnc.onEngineMessage)
@@ -109,7 +145,21 @@ func NewVncProxy(ctx context.Context, kuber *kuber.Kuber, config *models.AppConf
 func (vnc *VncProxy) Proxy(w http.ResponseWriter, r *http.Request, user *models.User, useGuacamole bool, vncSettings VncConnectionSettings) error {
 
 	// Validate the user request
-	if err := vnc.validateUserRequest(user); err != nil {
+	if err := vnc.validateUserRequest(user, vncSettings.Takeover); err != nil {
+		return err
+	}
+
+	// Take over the session from the previous connection. When the connection is held by another
+	// replica, the whole request is handled by that replica
+	if vncSettings.Takeover {
+		if vnc.ForwardToOwner(user, w, r) {
+			return nil
+		}
+		vnc.takeoverSession(user, r)
+	}
+
+	// Record this replica as the owner of the session before the session is created
+	if err := vnc.acquireSession(user); err != nil {
 		return err
 	}
 
@@ -126,6 +176,7 @@ func (vnc *VncProxy) Proxy(w http.ResponseWriter, r *http.Request, user *models.
 	wsConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logger.Warning("Cannot upgrade to ws: %s", err)
+		vnc.registry.Release(user)
 		return err
 	}
 
@@ -137,26 +188,46 @@ func (vnc *VncProxy) Proxy(w http.ResponseWriter, r *http.Request, user *models.
 		peer.Close(err, 1)
 	})
 
-	// Create TCP connection to the VNC backend
-	podAddr, wasPodNewlyCreated, err := vnc.getVncAddress(user, useGuacamole)
-	if err != nil {
-		logger.Warning("Cannot get IP of pod: %s", err)
+	if err := vnc.connectPeer(peer, r, wsConn, useGuacamole, vncSettings); err != nil {
 		return err
 	}
-	var vncCon *nbio.Conn = nil
-	var guacamoleCon *net.Conn = nil
 
-	// Get the remote pod ip (udp = addres + port - that's not the protocol :)
-	ip, err := net.ResolveUDPAddr("udp", podAddr.String())
+	// Print info
+	logger.Info("Opened connection for user %q (%s): %s", user.Username, user.Database, wsConn.RemoteAddr().String())
+	return nil
+}
+
+// connectPeer connects the given peer to the session of its user and adds it to the
+// list of the connected peers.
+//
+// The ownership of the session has to be acquired before. It's released when the peer
+// can't be added and afterwards when the peer disconnects.
+//
+// The WebSocket connection of the client is nil for clients using the HTTP tunnel
+func (vnc *VncProxy) connectPeer(peer *peer, r *http.Request, wsConn *websocket.Conn, useGuacamole bool, vncSettings VncConnectionSettings) (err error) {
+	user := peer.user
+
+	releaseOnError := true
+	defer func() {
+		if err != nil && releaseOnError {
+			vnc.registry.Release(user)
+		}
+	}()
+
+	// Get the session to connect to
+	session, wasPodNewlyCreated, err := vnc.getSession(user, useGuacamole)
 	if err != nil {
-		logger.Warning("Could not determine the pods IP address from the connection: %s", err)
+		logger.Warning("Cannot get session of user: %s", err)
 		return err
 	}
-	logger.Debug("Using pod address: %s", ip.IP)
+	var vncCon *nbio.Conn = nil
+	var guacamoleCon *net.Conn = nil
+	logger.Debug("Using session address: %s", session.IP)
+	peer.session = session
 
 	// Connect to the VNC backend
 	if !useGuacamole {
-		vncCon, err = vnc.connectToVnc(podAddr)
+		vncCon, err = vnc.connectToVnc(session.VncAddress())
 		if err != nil {
 			logger.Warning("Cannot connect to VNC backend %s", err)
 			return err
@@ -165,23 +236,24 @@ func (vnc *VncProxy) Proxy(w http.ResponseWriter, r *http.Request, user *models.
 	}
 
 	// Get the reverse proxy for the API endpoints
-	lfsxAPI, hostAPI, err := vnc.getHTTPProxy(ip)
+	lfsxAPI, hostAPI, err := vnc.getHTTPProxy(session)
 	if err != nil {
 		logger.Warning("Cannot create reverse proxy for endpoint: %s", err)
 	}
 
 	// Apply settings
-	if err := vnc.applyVncSettings(vncSettings, podAddr, wasPodNewlyCreated); err != nil {
+	if err := vnc.applyVncSettings(vncSettings, session, wasPodNewlyCreated); err != nil {
 		logger.Warning("Failed to apply scaling factor: %s", err)
 	}
 
 	// Get the WebSocket connection to the LFS.X
-	hostPeer, err := NewLfsxPeer(peer, vnc.baseContext, ip, &vnc.pingPongMgr, nil)
+	hostPeer, err := NewLfsxPeer(peer, vnc.baseContext, session, &vnc.pingPongMgr, nil)
 	if err != nil {
 		logger.Warning("Cannot connect to the LFS.X WebSocket. LFS.X specific functions won't be avaialable: %s", err)
 	} else {
 		peer.lfsxPeer = hostPeer
-		updateChan := hostPeer.RegisterObserver()
+		startupCtx, cancelStartup := context.WithTimeout(vnc.baseContext, 20*time.Second)
+		startup := Subscribe(startupCtx, hostPeer, models.LfsStartupType, models.FromLfsx)
 
 		// Send the login request
 		go func() {
@@ -192,19 +264,21 @@ func (vnc *VncProxy) Proxy(w http.ResponseWriter, r *http.Request, user *models.
 
 		// Wait until the LFS.X does boot up
 		select {
-		case <-time.After(20 * time.Second):
+		case <-startupCtx.Done():
 			logger.Debug("LFS.X did not boot up within 20 seconds. Continuing anyway")
-		case up := <-updateChan:
-			if up.Message.Type != "LfsStartup" {
-				logger.Debug("Received a WebSocket message from the LFS.X from type %q but expected %q", up.Message.Type, "LfsStartup")
-				// It doesn't matter because the LFS.X doesn't send any messages before it's bootstrapped
-			}
+		case <-startup:
+		}
+		cancelStartup()
+
+		// Show the notifications that are still active inside the LFS.X
+		if vnc.config.NotifyLfsx {
+			peer.sendNotifications(false, true, vnc.activeNotifications()...)
 		}
 	}
 
 	// Create TCP connection to gucd
 	if useGuacamole {
-		guacamoleCon, err = vnc.connectToGuacamole(podAddr)
+		guacamoleCon, err = vnc.connectToGuacamole(session.GuacamoleAddress())
 		if err != nil {
 			logger.Warning("Cannot connect to guacd %s", err)
 			return err
@@ -214,22 +288,34 @@ func (vnc *VncProxy) Proxy(w http.ResponseWriter, r *http.Request, user *models.
 	// Set connections
 	peer.SetConnections(wsConn, vncCon, guacamoleCon, lfsxAPI, hostAPI)
 
-	// Add to list
+	// Add to list. From now on the session is released by the disconnect of the
+	// added peer or it's still owned by the existing one
+	releaseOnError = false
 	vnc.peerSync.Lock()
-	defer vnc.peerSync.Unlock()
 	if _, doesExist := vnc.peer[user.Identifier()]; !doesExist {
 		// Set the peer
 		vnc.peer[user.Identifier()] = peer
+		vnc.peerSync.Unlock()
+		metrics.ActivePeers.WithLabelValues(peer.protocol(), strings.ToLower(user.Database.String())).Inc()
 	} else {
 		// Peer does already exists -> return error message
+		vnc.peerSync.Unlock()
 		peer.Close(fmt.Errorf("USER_ALREADY_EXISTS"), 0)
 		return errors.NewError("USER_ALREADY_EXISTS", 409)
 	}
 
 	if useGuacamole {
+		// Record the session if required by a policy
+		recordingName := ""
+		if vnc.config.ShouldRecord(user) {
+			recordingName = recording.NewRecordingName(user)
+			logger.Info("Recording the session of user %q into %q", user.Username, recordingName)
+		}
+
 		// Create a new Guacamole stram
-		if err := peer.proxyGuacamole(r.URL.Query().Get("quality")); err != nil {
+		if err := peer.proxyGuacamole(vncSettings.Quality, vnc.config.Recording.SessionPath, recordingName); err != nil {
 			logger.Error("Failed to create proxy to guacd: %s", err)
+			return err
 		}
 	} else {
 		// Because we've lost the initial "Hello" Message from the Server (the connection was not setup already) we need to send it again.
@@ -239,8 +325,9 @@ func (vnc *VncProxy) Proxy(w http.ResponseWriter, r *http.Request, user *models.
 		}()
 	}
 
-	// Print info
-	logger.Info("Opened connection for user %q (%s): %s", user.Username, user.Database, wsConn.RemoteAddr().String())
+	// Exchange the clipboard and the exported files with the session
+	peer.startWatching(vnc.baseContext)
+
 	return nil
 }
 
@@ -255,11 +342,32 @@ func (vnc *VncProxy) ProxyLfsxWebsocket(user *models.User, w http.ResponseWriter
 		return errors.NewError("No VNC connection established for your user", 424)
 	}
 
-	return u.lfsxPeer.ProxyHostWebsocket(w, r)
+	if err := u.lfsxPeer.ProxyHostWebsocket(w, r); err != nil {
+		return err
+	}
+
+	// The client missed the notifications that were sent before it connected
+	u.sendNotifications(true, false, vnc.activeNotifications()...)
+	if imageVersion := vnc.config.GetLfsImageVersion(); u.isOutdated(imageVersion) {
+		u.notifyOutdated(imageVersion)
+	}
+	return nil
 }
 
 // IsUserConnected returns weather the given user is connected to the API
+// of this or of any other replica
 func (vnc *VncProxy) IsUserConnected(user *models.User) bool {
+	if vnc.isUserConnectedLocally(user) {
+		return true
+	}
+
+	_, isOwned := vnc.registry.Owner(user)
+	return isOwned
+}
+
+// isUserConnectedLocally returns weather the given user is connected to
+// the API of this replica
+func (vnc *VncProxy) isUserConnectedLocally(user *models.User) bool {
 	vnc.peerSync.RLock()
 	_, doesExist := vnc.peer[user.Identifier()]
 	vnc.peerSync.RUnlock()
@@ -267,26 +375,144 @@ func (vnc *VncProxy) IsUserConnected(user *models.User) bool {
 	return doesExist
 }
 
+// ForwardToOwner forwards the given request to the replica that owns the session
+// of the user.
+// If the session is owned by this replica (or not owned at all) false is returned
+// and the request has to be handled locally
+func (vnc *VncProxy) ForwardToOwner(user *models.User, w http.ResponseWriter, r *http.Request) bool {
+	if vnc.isUserConnectedLocally(user) {
+		return false
+	}
+
+	owner, isOwned := vnc.registry.Owner(user)
+	if !isOwned || owner.Name == vnc.config.Replica.Name || owner.Address == "" {
+		return false
+	}
+
+	// Never forward a request twice to prevent loops between replicas with an outdated cache
+	if r.Header.Get(headerForwardedBy) != "" {
+		return false
+	}
+
+	logger.Trc("Forwarding request %q of user %q to replica %q", r.URL.Path, user.DbUser, owner.Name)
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: owner.Address})
+	r.Header.Set(headerForwardedBy, vnc.config.Replica.Name)
+	proxy.ServeHTTP(w, r)
+
+	return true
+}
+
+// Shadow joins the guacd connection of the given target user read-only. The target
+// user is notified over the LFS.X WebSocket that the supporter is watching
+func (vnc *VncProxy) Shadow(w http.ResponseWriter, r *http.Request, supporter *models.User, target *models.User) error {
+	if !vnc.config.IsSupporter(supporter) {
+		return errors.NewError("You are not allowed to watch the sessions of other users", 403)
+	}
+
+	// Get the connection of the user. It may be held by another replica
+	vnc.peerSync.RLock()
+	targetPeer, doesExist := vnc.peer[target.Identifier()]
+	vnc.peerSync.RUnlock()
+	if !doesExist {
+		if vnc.ForwardToOwner(target, w, r) {
+			return nil
+		}
+		return errors.NewError("The user is not connected", 404)
+	}
+	if !targetPeer.IsReady() || !targetPeer.guacamole.Used || targetPeer.guacamole.Stream == nil {
+		return errors.NewError("The session of the user is not using guacamole", 409)
+	}
+
+	shadow := NewPeer(supporter, func(p *peer, err error, fromWhich int) {
+		vnc.pingPongMgr.Delete(p.source)
+		logger.Info("Supporter %q stopped watching the session of %q", supporter.Username, target.DbUser)
+		targetPeer.notifyClient(models.NewShadowSession(supporter.Username, false))
+	})
+
+	// Upgrade to a WebSocket connection
+	upgrader := vnc.newUpgrader(shadow)
+	upgrader.OnClose(func(c *websocket.Conn, err error) {
+		shadow.Close(err, 1)
+	})
+	wsConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Warning("Cannot upgrade to ws: %s", err)
+		return err
+	}
+	wsConn.SetReadDeadline(time.Now().Add(KeepAliveTimeout))
+	vnc.pingPongMgr.Add(wsConn)
+	wsConn.OnClose(func(c *websocket.Conn, err error) {
+		shadow.Close(err, 1)
+	})
+
+	// Join the connection of the user
+	guacamoleCon, err := vnc.connectToGuacamole(targetPeer.session.GuacamoleAddress())
+	if err != nil {
+		logger.Warning("Cannot connect to guacd %s", err)
+		wsConn.Close()
+		return err
+	}
+	shadow.SetConnections(wsConn, nil, guacamoleCon, nil, nil)
+	if err := shadow.joinGuacamole(targetPeer.guacamole.Stream.ConnectionID); err != nil {
+		logger.Warning("Failed to join the guacd connection of user %q: %s", target.DbUser, err)
+		shadow.Close(err, 0)
+		return err
+	}
+
+	logger.Info("Supporter %q is watching the session of %q", supporter.Username, target.DbUser)
+	targetPeer.notifyClient(models.NewShadowSession(supporter.Username, true))
+
+	return nil
+}
+
 // validateUserRequest validates if the request from the given user is
 // valid.
 // This does include for example that only one connection to the proxy does
-// exist
-func (vnc *VncProxy) validateUserRequest(user *models.User) error {
+// exist. An existing connection is accepted when the user wants to take over the session
+func (vnc *VncProxy) validateUserRequest(user *models.User, takeover bool) error {
 
 	// Check if a connection for the user does already exist
-	if vnc.IsUserConnected(user) {
+	if !takeover && vnc.IsUserConnected(user) {
 		return errors.NewError("USER_ALREADY_EXISTS", 409)
 	}
 
 	return nil
 }
 
+// acquireSession records this replica as the owner of the session of the given user.
+// Another replica may accept a connection of the user at the same time.
+// The ownership has to be released when the connection can't be established
+func (vnc *VncProxy) acquireSession(user *models.User) error {
+	if err := vnc.registry.Acquire(user); err == registry.ErrSessionOwned {
+		return errors.NewError("USER_ALREADY_EXISTS", 409)
+	} else if err != nil {
+		logger.Warning("Failed to record the ownership of the session: %s", err)
+	}
+
+	return nil
+}
+
+// takeoverSession closes the existing connection of the user on this replica.
+// The closed client receives the address and the user agent of the new connection
+func (vnc *VncProxy) takeoverSession(user *models.User, r *http.Request) {
+	vnc.peerSync.RLock()
+	old, doesExist := vnc.peer[user.Identifier()]
+	vnc.peerSync.RUnlock()
+
+	if !doesExist {
+		return
+	}
+
+	logger.Info("User %q takes over the session from %s", user.Username, r.RemoteAddr)
+	old.Close(takeoverError{remoteAddr: r.RemoteAddr, userAgent: r.UserAgent()}, 0)
+}
+
 // Probe validates the user requests and creates a new pod
 // if no one does already exist.
 // This method will block until the pod was created
 func (vnc *VncProxy) Probe(user *models.User, settings VncConnectionSettings) error {
 	// Validate the user request
-	if err := vnc.validateUserRequest(user); err != nil {
+	if err := vnc.validateUserRequest(user, settings.Takeover); err != nil {
 		return err
 	}
 
@@ -295,19 +521,25 @@ func (vnc *VncProxy) Probe(user *models.User, settings VncConnectionSettings) er
 		return nil
 	}
 
+	// A login starts a fresh session instead of the outdated one
+	if vnc.config.Rollout.Policy == models.RolloutPolicyReplace && !vnc.IsUserConnected(user) {
+		if err := vnc.replaceOutdatedSession(user); err != nil {
+			logger.Warning("Failed to replace the outdated session of user %q: %s", user.DbUser, err)
+			return errors.NewError("Failed to replace the outdated session", 500)
+		}
+	}
+
 	// Create pod
-	pod, wasCreated, err := vnc.kuber.GetPodByUser(user)
-	if err != nil {
+	session, wasCreated, err := vnc.backend.GetSession(user)
+	if err == backend.ErrSessionLimit {
+		return errSessionLimit
+	} else if err != nil {
 		logger.Warning("Failed to create pod: %s", err)
 		return errors.NewError("Failed to create pod", 500)
 	}
 
 	// Apply settings
-	n, err := net.ResolveTCPAddr("tcp", pod.Status.PodIP+":1")
-	if err != nil {
-		return err
-	}
-	if err := vnc.applyVncSettings(settings, n, wasCreated); err != nil {
+	if err := vnc.applyVncSettings(settings, session, wasCreated); err != nil {
 		logger.Warning("Failed to apply scaling factor: %s", err)
 	}
 
@@ -319,19 +551,32 @@ func (vnc *VncProxy) onPeerDisconnect(peer *peer, err error, fromWho int) {
 	vnc.pingPongMgr.Delete(peer.source)
 	logger.Info("Closed connection for user %q", peer.user.Username)
 
-	// Remove peer from map
+	// Remove peer from map. A rejected duplicate peer must not remove the active one
 	vnc.peerSync.Lock()
-	delete(vnc.peer, peer.user.Identifier())
+	isActive := vnc.peer[peer.user.Identifier()] == peer
+	if isActive {
+		delete(vnc.peer, peer.user.Identifier())
+	}
 	vnc.peerSync.Unlock()
+
+	// The tunnel of a HTTP tunnel client can't be used anymore
+	if peer.guacamole.Tunnel != nil {
+		vnc.httpTunnels.Remove(peer.guacamole.Tunnel.GetUUID())
+	}
+
+	if isActive {
+		metrics.ActivePeers.WithLabelValues(peer.protocol(), strings.ToLower(peer.user.Database.String())).Dec()
+		vnc.registry.Release(peer.user)
+	}
 }
 
-// getVncAddress gets the address of the pod to connect to.
-// If no pod does already exists, a new Pod will be created.
+// getSession gets the session (pod) to connect to.
+// If no session does already exists, a new session will be created.
 //
-// This method does block until the pod was created.
+// This method does block until the session was created.
 //
-// The second parameter states weather a new pod or an already existing one was assigned
-func (vnc *VncProxy) getVncAddress(user *models.User, useGuacamole bool) (netAddr net.Addr, newPodCreated bool, err error) {
+// The second parameter states weather a new session or an already existing one was assigned
+func (vnc *VncProxy) getSession(user *models.User, useGuacamole bool) (session *backend.Session, newPodCreated bool, err error) {
 	// Get a static IP address for vnc connection in development mode
 	addr := ""
 	if useGuacamole {
@@ -341,34 +586,39 @@ func (vnc *VncProxy) getVncAddress(user *models.User, useGuacamole bool) (netAdd
 	}
 
 	if addr == "" {
-		// Get the address of the pod
-		pod, newCreated, err := vnc.kuber.GetPodByUser(user)
-		if err != nil {
-			return nil, newCreated, fmt.Errorf("failed to create pod: %s", err)
+		// Get the session of the user
+		session, newPodCreated, err = vnc.backend.GetSession(user)
+		if err == backend.ErrSessionLimit {
+			return nil, false, errSessionLimit
+		} else if err != nil {
+			return nil, newPodCreated, fmt.Errorf("failed to create pod: %s", err)
 		}
-		newPodCreated = newCreated
 
-		// Add port number to connection
-		if useGuacamole {
-			addr = pod.Status.PodIP + ":4822"
-		} else {
-			addr = pod.Status.PodIP + ":5910"
-		}
+		return session, newPodCreated, nil
+	}
 
+	logger.Info("Using predefined address instead of pod address: %q", addr)
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, true, err
+	}
+
+	// Only the port of the requested protocol is overwritten
+	session = backend.NewSession("development", tcpAddr.IP.String(), "")
+	if useGuacamole {
+		session.GuacamolePort = tcpAddr.Port
 	} else {
-		logger.Info("Using predefined address instead of pod address: %q", addr)
-		newPodCreated = true
+		session.VncPort = tcpAddr.Port
 	}
 
-	netAddr, err = net.ResolveTCPAddr("tcp", addr)
-	return
+	return session, true, nil
 }
 
 // connectToVnc creates a connection to the VNC backend pod
 // assigned for the user.
-func (vnc *VncProxy) connectToVnc(addr net.Addr) (*nbio.Conn, error) {
+func (vnc *VncProxy) connectToVnc(addr string) (*nbio.Conn, error) {
 
-	c, err := nbio.DialTimeout(addr.Network(), addr.String(), 3*time.Second)
+	c, err := nbio.DialTimeout("tcp", addr, 3*time.Second)
 	if err != nil {
 		return nil, fmt.Errorf("%q: %s", addr, err)
 	}
@@ -395,8 +645,8 @@ func (vnc *VncProxy) connectToVnc(addr net.Addr) (*nbio.Conn, error) {
 // Even when syncing the incoming messages and using a single channel reader
 // the messages doesn't arriver correctly and cannot be parsed therefore correclty
 // for the client
-func (vnc *VncProxy) connectToGuacamole(addr net.Addr) (*net.Conn, error) {
-	c, err := net.Dial(addr.Network(), addr.String())
+func (vnc *VncProxy) connectToGuacamole(addr string) (*net.Conn, error) {
+	c, err := net.Dial("tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("%q: %s", addr, err)
 	}
@@ -410,6 +660,10 @@ func (vnc *VncProxy) newUpgrader(peer *peer) *websocket.Upgrader {
 
 	u.KeepaliveTime = KeepAliveTimeout
 
+	// Subprotocol requested by the WebSocket tunnel of guacamole-common-js.
+	// Browsers reject the connection when the server doesn't confirm it
+	u.Subprotocols = []string{guacamoleSubprotocol}
+
 	// Handle pong messages
 	u.OnMessage(func(c *websocket.Conn, mt websocket.MessageType, b []byte) {
 		c.SetDeadline(time.Now().Add(KeepAliveTimeout))
@@ -452,14 +706,14 @@ func (vnc *VncProxy) onEngineClose(c *nbio.Conn, err error) {
 
 // getHTTPProxy builds up a HTTP reverse proxy to the LFSX API and the
 // host API
-func (vnc *VncProxy) getHTTPProxy(ip *net.UDPAddr) (lfsx *httputil.ReverseProxy, host *httputil.ReverseProxy, err error) {
-	remoteLfsURL, err := url.Parse(fmt.Sprintf("http://%s:8888", ip.IP))
+func (vnc *VncProxy) getHTTPProxy(session *backend.Session) (lfsx *httputil.ReverseProxy, host *httputil.ReverseProxy, err error) {
+	remoteLfsURL, err := url.Parse(fmt.Sprintf("http://%s", session.LfsxAddress()))
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to parse the url for the LFS.X endpoint 'http://%s:8888': %s", ip.IP, err)
+		return nil, nil, fmt.Errorf("failed to parse the url for the LFS.X endpoint 'http://%s': %s", session.LfsxAddress(), err)
 	}
-	remoteHostURL, err := url.Parse(fmt.Sprintf("http://%s:%d", ip.IP, utils.GetEnvInt("APP_LFS_API_PORT", 4021)))
+	remoteHostURL, err := url.Parse(fmt.Sprintf("http://%s", session.HostAddress()))
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to parse the url for the host endpoint 'http://%s:%d': %s", ip.IP, utils.GetEnvInt("APP_LFS_API_PORT", 4021), err)
+		return nil, nil, fmt.Errorf("failed to parse the url for the host endpoint 'http://%s': %s", session.HostAddress(), err)
 	}
 
 	return httputil.NewSingleHostReverseProxy(remoteLfsURL), httputil.NewSingleHostReverseProxy(remoteHostURL), nil
@@ -499,15 +753,8 @@ func (vnc *VncProxy) ProxyHostRequest(user *models.User, response http.ResponseW
 
 // applyVncSettings applies the given VNC specific settings for the pod.
 // It may be possible that the LFS.X may be restarted within this function
-func (vnc *VncProxy) applyVncSettings(settings VncConnectionSettings, podAdr net.Addr, wasNewlyCreated bool) error {
-
-	// Get the remote pod ip (udp = addres + port - that's not the protocol :)
-	ip, err := net.ResolveUDPAddr("udp", podAdr.String())
-	if err != nil {
-		logger.Warning("Could not determine the pods IP address from the connection: %s", err)
-		return err
-	}
-	baseURL := fmt.Sprintf("http://%s:%d/api", ip.IP.String(), utils.GetEnvInt("APP_LFS_API_PORT", 4021))
+func (vnc *VncProxy) applyVncSettings(settings VncConnectionSettings, session *backend.Session, wasNewlyCreated bool) error {
+	baseURL := fmt.Sprintf("http://%s/api", session.HostAddress())
 
 	// Apply the scaling factor provided by the user by calling the (hard) scaling endpoint
 	// of the LFS container.
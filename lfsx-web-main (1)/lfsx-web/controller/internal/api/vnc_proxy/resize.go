@@ -0,0 +1,135 @@
+package vnc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/guacamole"
+)
+
+// Time to wait for further size instructions before the last size is applied.
+// Resizing the browser window sends a size instruction for every step
+const resizeDebounce = 500 * time.Millisecond
+
+// Limits of the resolution a client can request
+const (
+	minResolution = 200
+	maxResolution = 8192
+)
+
+// resizer applies the size of the browser window to the display of the session
+type resizer struct {
+	lock  sync.Mutex
+	timer *time.Timer
+
+	// The last requested size
+	width  int
+	height int
+
+	// The size that was applied last
+	appliedWidth  int
+	appliedHeight int
+}
+
+// onSizeInstruction handles a size instruction of the client.
+//
+// guacd can't resize the display of a VNC server. So the resolution of the
+// sway output is changed instead
+func (p *peer) onSizeInstruction(size *guacamole.Instruction) {
+	if len(size.Args) < 2 {
+		logger.Debug("Received an invalid size instruction from the client of user %q: %q", p.user.Username, size.Args)
+		return
+	}
+	width, errW := strconv.Atoi(size.Args[0])
+	height, errH := strconv.Atoi(size.Args[1])
+	if errW != nil || errH != nil {
+		logger.Debug("Received an invalid size instruction from the client of user %q: %q", p.user.Username, size.Args)
+		return
+	}
+
+	p.requestResize(width, height)
+}
+
+// requestResize applies the given size after no other size was requested
+// within the debounce time
+func (p *peer) requestResize(width int, height int) {
+	// Peers without an own session (shadow) must not resize the session of the user
+	if p.session == nil {
+		return
+	}
+
+	if width < minResolution || height < minResolution || width > maxResolution || height > maxResolution {
+		logger.Debug("Ignoring resolution %dx%d requested by user %q", width, height, p.user.Username)
+		return
+	}
+
+	p.resize.lock.Lock()
+	defer p.resize.lock.Unlock()
+
+	p.resize.width = width
+	p.resize.height = height
+	if p.resize.timer == nil {
+		p.resize.timer = time.AfterFunc(resizeDebounce, p.applyResize)
+	} else {
+		p.resize.timer.Reset(resizeDebounce)
+	}
+}
+
+// applyResize changes the resolution of the session to the last requested size
+func (p *peer) applyResize() {
+	if p.closed.Load() {
+		return
+	}
+
+	p.resize.lock.Lock()
+	width, height := p.resize.width, p.resize.height
+	if width == p.resize.appliedWidth && height == p.resize.appliedHeight {
+		p.resize.lock.Unlock()
+		return
+	}
+	p.resize.appliedWidth, p.resize.appliedHeight = width, height
+	p.resize.lock.Unlock()
+
+	logger.Debug("Changing the resolution of the session of user %q to %dx%d", p.user.Username, width, height)
+	if err := p.changeResolution(width, height); err != nil {
+		logger.Warning("Failed to change the resolution of the session of user %q: %s", p.user.Username, err)
+
+		// Try again with the next size instruction
+		p.resize.lock.Lock()
+		p.resize.appliedWidth, p.resize.appliedHeight = 0, 0
+		p.resize.lock.Unlock()
+	}
+}
+
+// changeResolution calls the host API of the session to change the
+// resolution of the sway output
+func (p *peer) changeResolution(width int, height int) error {
+	body, err := json.Marshal(struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	}{Width: width, Height: height})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s", err)
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(fmt.Sprintf("http://%s/api/vnc/resolution", p.session.HostAddress()), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("request to LFS.X host: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		bodyR, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%d: %s", resp.StatusCode, bodyR)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package vnc
+
+import (
+	"fmt"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+)
+
+// Interval in which the sessions are checked for an outdated image version
+const rolloutCheckInterval = time.Minute
+
+// Maximum time to wait until a replaced session is stopped
+const rolloutReplaceTimeout = 30 * time.Second
+
+// runRolloutWatcher tells the users of sessions with an outdated image version
+// to restart the LFS.X until the base context is canceled.
+//
+// This method does block
+func (vnc *VncProxy) runRolloutWatcher() {
+	ticker := time.NewTicker(rolloutCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			vnc.peerSync.RLock()
+			peers := make([]*peer, 0, len(vnc.peer))
+			for _, p := range vnc.peer {
+				peers = append(peers, p)
+			}
+			vnc.peerSync.RUnlock()
+
+			imageVersion := vnc.config.GetLfsImageVersion()
+			for _, p := range peers {
+				if p.isOutdated(imageVersion) && !p.outdatedNotified.Load() {
+					p.notifyOutdated(imageVersion)
+				}
+			}
+		case <-vnc.baseContext.Done():
+			return
+		}
+	}
+}
+
+// isOutdated returns weather the session of the peer runs another than the given image version
+func (p *peer) isOutdated(imageVersion string) bool {
+	return p.session != nil && p.session.ImageVersion != "" && p.session.ImageVersion != imageVersion
+}
+
+// notifyOutdated tells the client that the session runs an outdated image version
+func (p *peer) notifyOutdated(imageVersion string) {
+	if p.lfsxPeer == nil || !p.lfsxPeer.HasClient() {
+		return
+	}
+
+	logger.Debug("Telling user %q that the session runs the outdated image version %q", p.user.Username, p.session.ImageVersion)
+	p.outdatedNotified.Store(true)
+	p.notifyClient(models.NewSessionOutdated(p.session.ImageVersion, imageVersion))
+}
+
+// replaceOutdatedSession deletes the session of the given user if it runs an
+// outdated image version. It blocks until the session was stopped so that the
+// next lookup assigns a fresh session.
+// An error is returned if the session wasn't stopped in time
+func (vnc *VncProxy) replaceOutdatedSession(user *models.User) error {
+	session, err := vnc.backend.FindSession(user)
+	if err != nil || session == nil {
+		return err
+	}
+
+	imageVersion := vnc.config.GetLfsImageVersion()
+	if session.ImageVersion == "" || session.ImageVersion == imageVersion {
+		return nil
+	}
+
+	logger.Info("Replacing the session %q of user %q with the outdated image version %q", session.Name, user.DbUser, session.ImageVersion)
+	if err := vnc.backend.DeleteSession(user); err != nil {
+		return err
+	}
+
+	// The backend may still report the stopped session for a short time
+	deadline := time.Now().Add(rolloutReplaceTimeout)
+	for time.Now().Before(deadline) {
+		current, err := vnc.backend.FindSession(user)
+		if err != nil {
+			return err
+		}
+		if current == nil || current.Name != session.Name {
+			return nil
+		}
+
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	return fmt.Errorf("session %q was not stopped within %s", session.Name, rolloutReplaceTimeout)
+}
@@ -0,0 +1,187 @@
+package vnc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/go-webserver/errors"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+)
+
+// Protocols a client can use to connect to a session
+const (
+	ProtocolGuacamole = "guacamole"
+	ProtocolVnc       = "vnc"
+)
+
+// Timeout for requesting the sessions of another replica
+const replicaSessionsTimeout = 5 * time.Second
+
+// SessionInfo describes a session that is connected to a replica
+type SessionInfo struct {
+	User     string `json:"user"`
+	Username string `json:"username"`
+	Db       string `json:"db"`
+
+	// "guacamole" or "vnc" (noVNC)
+	Protocol string `json:"protocol"`
+
+	// The pod (session) the client is connected to
+	Pod          string `json:"pod"`
+	IP           string `json:"ip"`
+	ImageVersion string `json:"imageVersion"`
+
+	ConnectedAt time.Time `json:"connectedAt"`
+
+	// Weather the session runs an older image version than the current one
+	Outdated bool `json:"outdated"`
+
+	// Weather the WebSocket to the LFS.X is established
+	LfsxConnected bool `json:"lfsxConnected"`
+
+	// Name of the controller replica holding the connection
+	Replica string `json:"replica"`
+
+	// Traffic between the client and the session
+	Traffic models.SessionStats `json:"traffic"`
+}
+
+// Sessions returns the sessions of all controller replicas sorted by their
+// connection time. The sessions of the other replicas holding a session lease are
+// requested with the credentials of the given request. Replicas that can't be
+// reached are skipped.
+// Requests forwarded by another replica only return the sessions of this replica
+func (vnc *VncProxy) Sessions(r *http.Request) []SessionInfo {
+	rtc := vnc.localSessions()
+	if r.Header.Get(headerForwardedBy) != "" {
+		return rtc
+	}
+
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for _, replica := range vnc.registry.Replicas() {
+		if replica.Address == "" {
+			logger.Warning("Can't list the sessions of replica %q because it has no address", replica.Name)
+			continue
+		}
+
+		wg.Add(1)
+		go func(replica models.Replica) {
+			defer wg.Done()
+
+			sessions, err := vnc.replicaSessions(replica, r)
+			if err != nil {
+				logger.Warning("Failed to get the sessions of replica %q: %s", replica.Name, err)
+				return
+			}
+
+			lock.Lock()
+			rtc = append(rtc, sessions...)
+			lock.Unlock()
+		}(replica)
+	}
+	wg.Wait()
+
+	sort.Slice(rtc, func(a, b int) bool {
+		return rtc[a].ConnectedAt.Before(rtc[b].ConnectedAt)
+	})
+	return rtc
+}
+
+// replicaSessions requests the sessions that are connected to the given replica
+func (vnc *VncProxy) replicaSessions(replica models.Replica, r *http.Request) ([]SessionInfo, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, "http://"+replica.Address+r.URL.RequestURI(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %s", err)
+	}
+	for _, header := range []string{"Authorization", "Cookie"} {
+		if val := r.Header.Get(header); val != "" {
+			req.Header.Set(header, val)
+		}
+	}
+	req.Header.Set(headerForwardedBy, vnc.config.Replica.Name)
+
+	client := http.Client{Timeout: replicaSessionsTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received status code %d", resp.StatusCode)
+	}
+
+	var sessions []SessionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		return nil, fmt.Errorf("failed to decode the sessions: %s", err)
+	}
+	return sessions, nil
+}
+
+// localSessions returns all sessions that are connected to this replica
+func (vnc *VncProxy) localSessions() []SessionInfo {
+	vnc.peerSync.RLock()
+	defer vnc.peerSync.RUnlock()
+
+	imageVersion := vnc.config.GetLfsImageVersion()
+	rtc := make([]SessionInfo, 0, len(vnc.peer))
+	for _, p := range vnc.peer {
+		info := SessionInfo{
+			User:          p.user.DbUser,
+			Username:      p.user.Username,
+			Db:            p.user.DatabaseStr,
+			Protocol:      p.protocol(),
+			ConnectedAt:   p.connectedAt,
+			LfsxConnected: p.lfsxPeer != nil && p.lfsxPeer.IsConnected(),
+			Replica:       vnc.config.Replica.Name,
+			Traffic:       p.traffic.Stats(),
+		}
+		if p.session != nil {
+			info.Pod = p.session.Name
+			info.IP = p.session.IP.String()
+			info.ImageVersion = p.session.ImageVersion
+			info.Outdated = p.isOutdated(imageVersion)
+		}
+
+		rtc = append(rtc, info)
+	}
+
+	return rtc
+}
+
+// CloseSession closes the connection of the given user on this replica. The user
+// does see the given reason.
+// When "deletePod" is set, the session (pod) of the user is deleted as well
+func (vnc *VncProxy) CloseSession(admin *models.User, target *models.User, reason string, deletePod bool) error {
+	vnc.peerSync.RLock()
+	p, doesExist := vnc.peer[target.Identifier()]
+	vnc.peerSync.RUnlock()
+
+	// The pod can also be deleted without an open connection
+	if !doesExist && !deletePod {
+		return errors.NewError("The user is not connected", 404)
+	}
+
+	if doesExist {
+		logger.Info("Administrator %q closes the session of user %q: %s", admin.Username, target.DbUser, reason)
+		p.Close(adminCloseError{reason: reason}, 0)
+	}
+
+	if deletePod {
+		logger.Info("Administrator %q deletes the session of user %q", admin.Username, target.DbUser)
+		if err := vnc.backend.DeleteSession(target); err != nil {
+			logger.Warning("Failed to delete the session of user %q: %s", target.DbUser, err)
+			return errors.NewError("Failed to delete the session", 500)
+		}
+	}
+
+	return nil
+}
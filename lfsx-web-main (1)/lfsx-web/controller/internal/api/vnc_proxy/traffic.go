@@ -0,0 +1,151 @@
+package vnc
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+)
+
+// Timings of the traffic accounting. The rates are calculated over
+// a sliding window of the last samples
+const (
+	trafficSampleInterval = 1 * time.Second
+	trafficWindow         = 10
+	trafficNotifyInterval = 5 * time.Second
+)
+
+// Start of a guacamole "sync" instruction that ends a frame
+var syncInstruction = []byte("4.sync,")
+
+// trafficCounter counts the traffic of a single peer.
+//
+// The counters are incremented atomically in the proxy hot path. Only
+// the sampling for the sliding window requires a lock
+type trafficCounter struct {
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	messagesIn  atomic.Uint64
+	messagesOut atomic.Uint64
+	frames      atomic.Uint64
+
+	// Ring buffer with the snapshots of the counters
+	samples     [trafficWindow + 1]trafficSample
+	next        int
+	sampleCount int
+	samplesLock sync.Mutex
+}
+
+// trafficSample is a snapshot of the counters at a given time
+type trafficSample struct {
+	at       time.Time
+	bytesIn  uint64
+	bytesOut uint64
+	frames   uint64
+}
+
+// countIn counts a message from the client to the session
+func (t *trafficCounter) countIn(size int) {
+	t.bytesIn.Add(uint64(size))
+	t.messagesIn.Add(1)
+}
+
+// countOut counts a message from the session to the client
+func (t *trafficCounter) countOut(size int) {
+	t.bytesOut.Add(uint64(size))
+	t.messagesOut.Add(1)
+}
+
+// countFrames counts the frames that are ended within the given guacamole instructions
+func (t *trafficCounter) countFrames(instructions []byte) {
+	if n := bytes.Count(instructions, syncInstruction); n > 0 {
+		t.frames.Add(uint64(n))
+	}
+}
+
+// sample takes a snapshot of the counters for the sliding window
+func (t *trafficCounter) sample(now time.Time) {
+	t.samplesLock.Lock()
+	defer t.samplesLock.Unlock()
+
+	t.samples[t.next] = trafficSample{
+		at:       now,
+		bytesIn:  t.bytesIn.Load(),
+		bytesOut: t.bytesOut.Load(),
+		frames:   t.frames.Load(),
+	}
+	t.next = (t.next + 1) % len(t.samples)
+	if t.sampleCount < len(t.samples) {
+		t.sampleCount++
+	}
+}
+
+// Stats returns the totals and the rates within the sliding window
+func (t *trafficCounter) Stats() models.SessionStats {
+	stats := models.SessionStats{
+		BytesIn:     t.bytesIn.Load(),
+		BytesOut:    t.bytesOut.Load(),
+		MessagesIn:  t.messagesIn.Load(),
+		MessagesOut: t.messagesOut.Load(),
+		Frames:      t.frames.Load(),
+	}
+
+	t.samplesLock.Lock()
+	defer t.samplesLock.Unlock()
+
+	if t.sampleCount < 2 {
+		return stats
+	}
+
+	// Compare the newest with the oldest sample
+	newest := t.samples[(t.next-1+len(t.samples))%len(t.samples)]
+	oldest := t.samples[(t.next-t.sampleCount+len(t.samples))%len(t.samples)]
+	seconds := newest.at.Sub(oldest.at).Seconds()
+	if seconds <= 0 {
+		return stats
+	}
+
+	stats.BytesInPerSecond = float64(newest.bytesIn-oldest.bytesIn) / seconds
+	stats.BytesOutPerSecond = float64(newest.bytesOut-oldest.bytesOut) / seconds
+	stats.FramesPerSecond = float64(newest.frames-oldest.frames) / seconds
+
+	return stats
+}
+
+// runTrafficSampler samples the traffic of all peers periodically and sends
+// the stats to the clients.
+//
+// This method does block until the base context is canceled
+func (vnc *VncProxy) runTrafficSampler() {
+	ticker := time.NewTicker(trafficSampleInterval)
+	defer ticker.Stop()
+	lastNotify := time.Now()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			notify := now.Sub(lastNotify) >= trafficNotifyInterval
+			if notify {
+				lastNotify = now
+			}
+
+			vnc.peerSync.RLock()
+			peers := make([]*peer, 0, len(vnc.peer))
+			for _, p := range vnc.peer {
+				peers = append(peers, p)
+			}
+			vnc.peerSync.RUnlock()
+
+			for _, p := range peers {
+				p.traffic.sample(now)
+				if notify && p.lfsxPeer != nil && p.lfsxPeer.HasClient() {
+					p.notifyClient(models.NewSessionStats(p.traffic.Stats()))
+				}
+			}
+		case <-vnc.baseContext.Done():
+			return
+		}
+	}
+}
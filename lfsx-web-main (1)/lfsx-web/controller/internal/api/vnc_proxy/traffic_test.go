@@ -0,0 +1,108 @@
+package vnc
+
+import (
+	"testing"
+	"time"
+
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+)
+
+func TestTrafficCounterStats(t *testing.T) {
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		count  func(c *trafficCounter)
+		expect models.SessionStats
+	}{
+		{
+			name:   "no traffic",
+			count:  func(c *trafficCounter) {},
+			expect: models.SessionStats{},
+		},
+		{
+			name: "totals without samples",
+			count: func(c *trafficCounter) {
+				c.countIn(10)
+				c.countOut(20)
+				c.countOut(5)
+				c.countFrames([]byte("4.sync,1.0;3.img,1.1;4.sync,1.2;"))
+			},
+			expect: models.SessionStats{BytesIn: 10, BytesOut: 25, MessagesIn: 1, MessagesOut: 2, Frames: 2},
+		},
+		{
+			name: "single sample",
+			count: func(c *trafficCounter) {
+				c.countIn(10)
+				c.sample(start)
+			},
+			expect: models.SessionStats{BytesIn: 10, MessagesIn: 1},
+		},
+		{
+			name: "instructions without frames",
+			count: func(c *trafficCounter) {
+				c.countFrames([]byte("3.img,1.1;4.blob,1.1;"))
+			},
+			expect: models.SessionStats{},
+		},
+		{
+			name: "rates between two samples",
+			count: func(c *trafficCounter) {
+				c.sample(start)
+				c.countIn(100)
+				c.countOut(300)
+				c.countFrames([]byte("4.sync,1.0;4.sync,1.1;"))
+				c.sample(start.Add(2 * time.Second))
+			},
+			expect: models.SessionStats{
+				BytesIn: 100, BytesOut: 300, MessagesIn: 1, MessagesOut: 1, Frames: 2,
+				BytesInPerSecond: 50, BytesOutPerSecond: 150, FramesPerSecond: 1,
+			},
+		},
+		{
+			name: "samples at the same time",
+			count: func(c *trafficCounter) {
+				c.sample(start)
+				c.countIn(100)
+				c.sample(start)
+			},
+			expect: models.SessionStats{BytesIn: 100, MessagesIn: 1},
+		},
+		{
+			name: "traffic after the last sample",
+			count: func(c *trafficCounter) {
+				c.sample(start)
+				c.countOut(100)
+				c.sample(start.Add(time.Second))
+				c.countOut(1000)
+			},
+			expect: models.SessionStats{BytesOut: 1100, MessagesOut: 2, BytesOutPerSecond: 100},
+		},
+		{
+			name: "sliding window",
+			count: func(c *trafficCounter) {
+				// Only the last samples are part of the window. The old traffic is ignored
+				for i := 0; i < trafficWindow+5; i++ {
+					if i < 5 {
+						c.countOut(1000)
+					} else {
+						c.countOut(100)
+					}
+					c.sample(start.Add(time.Duration(i) * time.Second))
+				}
+			},
+			expect: models.SessionStats{BytesOut: 6000, MessagesOut: trafficWindow + 5, BytesOutPerSecond: 100},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &trafficCounter{}
+			test.count(c)
+
+			if stats := c.Stats(); stats != test.expect {
+				t.Errorf("expected %+v, got %+v", test.expect, stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package vnc
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/guacamole"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+)
+
+// fileUploads receives the files guacamole clients send with file streams and
+// passes them to the upload the LFS.X requested.
+//
+// File streams are only accepted while the LFS.X requested an upload and
+// only for the file types accepted by the request
+type fileUploads struct {
+	lock sync.Mutex
+
+	// The ID of the "FileUploadRequest" of the LFS.X. Zero if no upload was requested
+	requestID int
+
+	// The accepted file types of the requested upload. Empty if all types are accepted
+	accept string
+
+	// The file streams of the client by their index
+	streams map[string]*fileUpload
+}
+
+// fileUpload is a single file stream of the client
+type fileUpload struct {
+	name string
+
+	// The data of the stream is written into the upload request to the LFS.X
+	writer *io.PipeWriter
+	done   chan error
+}
+
+// watchUploadRequests keeps track of the uploads requested by the LFS.X until
+// the given context is done
+func (p *peer) watchUploadRequests(ctx context.Context) {
+	requests := Subscribe(ctx, p.lfsxPeer, models.FileUploadRequestType, models.FromLfsx)
+	finished := Subscribe(ctx, p.lfsxPeer, models.FileUploadFinishedType, models.FromClient)
+
+	for {
+		select {
+		case up := <-requests:
+			p.uploads.lock.Lock()
+			p.uploads.requestID = up.ID
+			p.uploads.accept = up.Payload.Accept
+			p.uploads.lock.Unlock()
+		case <-finished:
+			p.uploads.lock.Lock()
+			p.uploads.requestID = 0
+			p.uploads.accept = ""
+			p.uploads.lock.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// onStream handles a file instruction of the guacamole client that starts
+// a new file stream
+func (u *fileUploads) onStream(p *peer, ins *guacamole.Instruction) {
+	if len(ins.Args) < 3 {
+		return
+	}
+	index, mimetype, name := ins.Args[0], ins.Args[1], ins.Args[2]
+
+	// Peers without an own session (shadow) must not upload files
+	if p.session == nil {
+		p.sendAck(index, guacamole.ClientForbidden)
+		return
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if u.requestID == 0 {
+		logger.Info("Rejected the upload of %q by user %q because the LFS.X didn't request an upload", name, p.user.Username)
+		p.sendAck(index, guacamole.ClientForbidden)
+		return
+	}
+	if !acceptsFile(u.accept, name, mimetype) {
+		logger.Info("Rejected the upload of %q (%s) by user %q because only %q is accepted", name, mimetype, p.user.Username, u.accept)
+		p.sendAck(index, guacamole.ClientBadType)
+		return
+	}
+
+	reader, writer := io.Pipe()
+	upload := &fileUpload{name: name, writer: writer, done: make(chan error, 1)}
+	requestID := u.requestID
+	go func() {
+		err := p.storeUpload(requestID, name, reader)
+		reader.CloseWithError(err)
+		upload.done <- err
+	}()
+
+	if u.streams == nil {
+		u.streams = make(map[string]*fileUpload)
+	}
+	u.streams[index] = upload
+
+	logger.Debug("User %q started to upload %q", p.user.Username, name)
+	p.sendAck(index, guacamole.Success)
+}
+
+// onStreamData handles a blob or end instruction of the guacamole client. It returns
+// false when the instruction doesn't belong to a file stream
+func (u *fileUploads) onStreamData(p *peer, ins *guacamole.Instruction) bool {
+	if len(ins.Args) < 1 || ins.Opcode == "ack" {
+		return false
+	}
+	index := ins.Args[0]
+
+	u.lock.Lock()
+	upload, ok := u.streams[index]
+	if ok && ins.Opcode == "end" {
+		delete(u.streams, index)
+	}
+	u.lock.Unlock()
+	if !ok {
+		return false
+	}
+
+	if ins.Opcode == "end" {
+		upload.writer.Close()
+		if err := <-upload.done; err != nil {
+			logger.Warning("Failed to upload %q of user %q: %s", upload.name, p.user.Username, err)
+		} else {
+			logger.Info("User %q uploaded %q", p.user.Username, upload.name)
+		}
+		return true
+	}
+
+	// The data is written before the blob is acknowledged. So the client
+	// doesn't send the next blob before the data was processed
+	status := guacamole.Success
+	if len(ins.Args) < 2 {
+		status = guacamole.ClientBadRequest
+	} else if data, err := base64.StdEncoding.DecodeString(ins.Args[1]); err != nil {
+		status = guacamole.ClientBadRequest
+	} else if _, err := upload.writer.Write(data); err != nil {
+		logger.Warning("Failed to upload %q of user %q: %s", upload.name, p.user.Username, err)
+		status = guacamole.ServerError
+	}
+
+	// The client closes the stream on an error
+	if status != guacamole.Success {
+		u.lock.Lock()
+		delete(u.streams, index)
+		u.lock.Unlock()
+		upload.writer.CloseWithError(fmt.Errorf("upload aborted"))
+	}
+
+	p.sendAck(index, status)
+	return true
+}
+
+// abort aborts all running uploads
+func (u *fileUploads) abort() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	for index, upload := range u.streams {
+		upload.writer.CloseWithError(fmt.Errorf("peer closed"))
+		delete(u.streams, index)
+	}
+}
+
+// storeUpload sends the content of the given reader as a file of the upload with the
+// given request ID to the LFS.X. It's the same endpoint the upload dialog of the client uses
+func (p *peer) storeUpload(requestID int, name string, content io.Reader) error {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/file/%d/upload", p.session.LfsxAddress(), requestID), content)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Filename", name)
+
+	// No timeout: the duration depends on the client sending the file
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to LFS.X: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		bodyR, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%d: %s", resp.StatusCode, bodyR)
+	}
+
+	return nil
+}
+
+// sendAck acknowledges the stream of the guacamole client with the given index
+func (p *peer) sendAck(index string, status guacamole.Status) {
+	p.sendInstructions(guacamole.NewInstruction("ack", index, status.String(), strconv.Itoa(status.GetGuacamoleStatusCode())))
+}
+
+// acceptsFile returns weather the file with the given name and mime type matches
+// the given accepted types. The types have the format of the "accept" attribute
+// of a file input. All files are accepted if the requested upload has no types
+func acceptsFile(accept string, name string, mimetype string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+
+	// The browser may not know the mime type of the file
+	if mimetype == "" || mimetype == "application/octet-stream" {
+		mimetype = mime.TypeByExtension(filepath.Ext(name))
+	}
+	mimetype, _, _ = mime.ParseMediaType(mimetype)
+
+	for _, t := range strings.Split(accept, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+
+		switch {
+		case t == "":
+			continue
+		case strings.HasPrefix(t, "."):
+			if strings.HasSuffix(strings.ToLower(name), t) {
+				return true
+			}
+		case strings.HasSuffix(t, "/*"):
+			if strings.HasPrefix(mimetype, strings.TrimSuffix(t, "*")) {
+				return true
+			}
+		case t == mimetype:
+			return true
+		}
+	}
+
+	return false
+}
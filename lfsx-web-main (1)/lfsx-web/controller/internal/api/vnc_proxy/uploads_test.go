@@ -0,0 +1,42 @@
+package vnc
+
+import "testing"
+
+func TestAcceptsFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		file     string
+		mimetype string
+		expect   bool
+	}{
+		{"no types", "", "report.exe", "application/octet-stream", true},
+		{"only whitespace", "  ", "report.exe", "", true},
+		{"extension", ".pdf", "report.pdf", "application/pdf", true},
+		{"extension case insensitive", ".PDF", "Report.Pdf", "", true},
+		{"other extension", ".pdf", "report.docx", "", false},
+		{"extension list", ".doc, .docx", "report.docx", "", true},
+		{"extension as part of the name", ".pdf", "report.pdf.exe", "", false},
+		{"mime type", "application/pdf", "report", "application/pdf", true},
+		{"mime type with parameters", "application/pdf", "report", "application/pdf; charset=binary", true},
+		{"mime type by extension", "application/pdf", "report.pdf", "", true},
+		{"octet stream by extension", "application/pdf", "report.pdf", "application/octet-stream", true},
+		{"unknown mime type", "application/pdf", "report", "", false},
+		{"other mime type", "application/pdf", "image.png", "image/png", false},
+		{"wildcard", "image/*", "image.png", "image/png", true},
+		{"wildcard by extension", "image/*", "image.jpg", "", true},
+		{"wildcard case insensitive", "IMAGE/*", "image", "Image/PNG", true},
+		{"wildcard of other type", "image/*", "report.pdf", "application/pdf", false},
+		{"empty entries", ",, .txt ,", "notes.txt", "", true},
+		{"mixed list", ".doc,image/*,application/pdf", "report.pdf", "", true},
+		{"no matching entry of list", ".doc,image/*", "report.pdf", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rtc := acceptsFile(test.accept, test.file, test.mimetype); rtc != test.expect {
+				t.Errorf("acceptsFile(%q, %q, %q): expected %t, got %t", test.accept, test.file, test.mimetype, test.expect, rtc)
+			}
+		})
+	}
+}
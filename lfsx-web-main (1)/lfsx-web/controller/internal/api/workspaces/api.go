@@ -0,0 +1,92 @@
+package workspaces
+
+import (
+	"net/http"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/go-webserver/errors"
+	"gitea.hama.de/LFS/go-webserver/response"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/kuber"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type Service interface {
+	Workspace(user *models.User) (*kuber.WorkspaceInfo, error)
+	ResetWorkspace(user *models.User) error
+}
+
+type ressource struct {
+	service Service
+}
+
+// RegisterHandlers register the admin endpoints to show and reset
+// the persistent workspaces of the users
+func RegisterHandlers(r chi.Router, service Service) {
+	res := ressource{service: service}
+
+	r.Get("/admin/workspaces/{db}/{user}", res.Get)
+	r.Delete("/admin/workspaces/{db}/{user}", res.Reset)
+}
+
+// Get returns the workspace of the user
+func (res ressource) Get(w http.ResponseWriter, r *http.Request) {
+	target, err := targetUser(r)
+	if err != nil {
+		errors.Write(w, err)
+		return
+	}
+
+	workspace, err := res.service.Workspace(target)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response.WriteJson(workspace, 200, w)
+}
+
+// Reset stops the session of the user and deletes the workspace.
+// The next login starts with an empty workspace
+func (res ressource) Reset(w http.ResponseWriter, r *http.Request) {
+	admin := r.Context().Value(models.KeyUser).(*models.User)
+	target, err := targetUser(r)
+	if err != nil {
+		errors.Write(w, err)
+		return
+	}
+
+	logger.Info("Administrator %q resets the workspace of user %q", admin.Username, target.DbUser)
+	if err := res.service.ResetWorkspace(target); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response.WriteText("OK", 200, w)
+}
+
+// targetUser returns the user given by the URL parameters. Unknown
+// databases are rejected
+func targetUser(r *http.Request) (*models.User, error) {
+	db, ok := models.ParseDatabase(chi.URLParam(r, "db"))
+	if !ok {
+		return nil, errors.BadRequest("Unknown database")
+	}
+
+	return &models.User{
+		DbUser:      chi.URLParam(r, "user"),
+		DatabaseStr: chi.URLParam(r, "db"),
+		Database:    db,
+	}, nil
+}
+
+// writeError writes the given error of the service as response
+func writeError(w http.ResponseWriter, err error) {
+	if err == kuber.ErrNoWorkspace {
+		errors.Write(w, errors.NewError("The user has no workspace", 404))
+		return
+	}
+
+	logger.Warning("Failed to access the workspace: %s", err)
+	errors.Write(w, errors.NewError("Failed to access the workspace", 500))
+}
@@ -0,0 +1,104 @@
+// backend abstracts where the LFS.X sessions of the users
+// are running (kubernetes pods, local processes, ...)
+package backend
+
+import (
+	"errors"
+	"net"
+	"strconv"
+
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
+)
+
+// Default ports of the services that are running inside an LFS.X session
+const (
+	DefaultVncPort       = 5910
+	DefaultGuacamolePort = 4822
+	DefaultLfsxPort      = 8888
+)
+
+// ErrSessionLimit is returned when no further session can be started
+var ErrSessionLimit = errors.New("no further session can be started")
+
+// SessionBackend is responsible for providing a running LFS.X
+// session for a user
+type SessionBackend interface {
+
+	// GetSession returns the session that is assigned for the given user.
+	// If no session was found, a new session will be created / assigned.
+	// In such a case 'true' will be returned as the second parameter.
+	//
+	// This method blocks until the session is ready to use
+	GetSession(user *models.User) (*Session, bool, error)
+
+	// FindSession returns the running session of the given user without
+	// creating a new one. If the user has no session nil is returned
+	FindSession(user *models.User) (*Session, error)
+
+	// DeleteSession stops the session of the given user. The next call
+	// of GetSession does assign a new session.
+	// If the user has no session nothing is done
+	DeleteSession(user *models.User) error
+}
+
+// Session describes a single running LFS.X instance with all
+// the addresses that are needed to talk with it
+type Session struct {
+
+	// Unique name of the session (like the pod name)
+	Name string
+
+	// IP address on which all services of the session are reachable
+	IP net.IP
+
+	// Port of the VNC server
+	VncPort int
+	// Port of guacd
+	GuacamolePort int
+	// Port of the LFS.X API and WebSocket
+	LfsxPort int
+	// Port of the host API (lfsx-web-lfs)
+	HostPort int
+
+	// Version of the image the session was started with
+	ImageVersion string
+}
+
+// NewSession creates a new session reachable under the given
+// ip with the default ports
+func NewSession(name string, ip string, imageVersion string) *Session {
+	return &Session{
+		Name:          name,
+		IP:            net.ParseIP(ip),
+		VncPort:       DefaultVncPort,
+		GuacamolePort: DefaultGuacamolePort,
+		LfsxPort:      DefaultLfsxPort,
+		HostPort:      utils.GetEnvInt("APP_LFS_API_PORT", 4021),
+		ImageVersion:  imageVersion,
+	}
+}
+
+// VncAddress returns the address of the VNC server
+func (s *Session) VncAddress() string {
+	return s.address(s.VncPort)
+}
+
+// GuacamoleAddress returns the address of guacd
+func (s *Session) GuacamoleAddress() string {
+	return s.address(s.GuacamolePort)
+}
+
+// LfsxAddress returns the address of the LFS.X API
+func (s *Session) LfsxAddress() string {
+	return s.address(s.LfsxPort)
+}
+
+// HostAddress returns the address of the host API
+func (s *Session) HostAddress() string {
+	return s.address(s.HostPort)
+}
+
+func (s *Session) address(port int) string {
+	return net.JoinHostPort(s.IP.String(), strconv.Itoa(port))
+}
@@ -0,0 +1,267 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+)
+
+// ProcessBackend runs every LFS.X session as a child process
+// of the controller on the local machine.
+//
+// For every session the host agent (lfsx-web-lfs) is started with
+// free ports for all of its services. The agent starts sway, wayvnc and guacd
+// on these ports and stops them together with the LFS.X. This allows
+// developers or test users to get a session on a single Linux box
+// without the need of kubernetes.
+//
+// The port of the LFS.X API can't be configured. Because every session would
+// use the same LFS.X, only a single session can run at a time
+type ProcessBackend struct {
+
+	// App configuration
+	config *models.AppConfig
+
+	// All sessions that are currently started indexed by the user identifier
+	sessions map[string]*processSession
+	// Sync to access the sessions
+	sessionsSync sync.Mutex
+}
+
+// processSession is a single session started by the ProcessBackend
+type processSession struct {
+	session *Session
+
+	// The started host agent
+	cmd *exec.Cmd
+
+	// Closed when the session is ready to use or failed to start
+	ready chan struct{}
+	// Error that occurred during the startup
+	err error
+}
+
+// NewProcessBackend creates a new backend that starts the sessions
+// as local child processes
+func NewProcessBackend(config *models.AppConfig) *ProcessBackend {
+	return &ProcessBackend{
+		config:   config,
+		sessions: make(map[string]*processSession),
+	}
+}
+
+// GetSession returns the session of the given user. If no process is
+// running for the user, a new one will be started
+func (b *ProcessBackend) GetSession(user *models.User) (*Session, bool, error) {
+	b.sessionsSync.Lock()
+
+	// A session does already exist
+	if s, doesExist := b.sessions[user.Identifier()]; doesExist {
+		b.sessionsSync.Unlock()
+
+		<-s.ready
+		return s.session, false, s.err
+	}
+
+	// A second LFS.X would listen on the same port. The requests and the login of
+	// the user would then be send to the LFS.X of another user
+	if len(b.sessions) > 0 {
+		b.sessionsSync.Unlock()
+
+		logger.Info("Rejected the session of user %q because another session is already running", user.DbUser)
+		return nil, false, ErrSessionLimit
+	}
+
+	// Start a new session
+	s := &processSession{ready: make(chan struct{})}
+	b.sessions[user.Identifier()] = s
+	b.sessionsSync.Unlock()
+
+	s.err = b.startSession(user, s)
+	close(s.ready)
+
+	if s.err != nil {
+		b.sessionsSync.Lock()
+		delete(b.sessions, user.Identifier())
+		b.sessionsSync.Unlock()
+	}
+
+	return s.session, true, s.err
+}
+
+// FindSession returns the session of the given user if its process is running
+func (b *ProcessBackend) FindSession(user *models.User) (*Session, error) {
+	b.sessionsSync.Lock()
+	s, doesExist := b.sessions[user.Identifier()]
+	b.sessionsSync.Unlock()
+
+	if !doesExist {
+		return nil, nil
+	}
+
+	<-s.ready
+	if s.err != nil {
+		return nil, nil
+	}
+	return s.session, nil
+}
+
+// DeleteSession stops the process of the given user
+func (b *ProcessBackend) DeleteSession(user *models.User) error {
+	b.sessionsSync.Lock()
+	s, doesExist := b.sessions[user.Identifier()]
+	b.sessionsSync.Unlock()
+
+	if !doesExist {
+		return nil
+	}
+
+	// The session is still starting up
+	<-s.ready
+	if s.cmd == nil || s.cmd.Process == nil {
+		return nil
+	}
+
+	// The session is removed after the process exited
+	logger.Info("Stopping session process of user %q", user.DbUser)
+	if err := syscall.Kill(-s.cmd.Process.Pid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("failed to stop session process: %s", err)
+	}
+
+	return nil
+}
+
+// startSession starts the host agent for the given user on free ports.
+// This method blocks until the host agent is ready
+func (b *ProcessBackend) startSession(user *models.User, s *processSession) error {
+	logger.Debug("Starting session process for user %q", user.DbUser)
+
+	// Get free ports for all services. The LFS.X always listens on the default port
+	ports, err := getFreePorts(3)
+	if err != nil {
+		return fmt.Errorf("failed to get free ports: %s", err)
+	}
+	s.session = &Session{
+		Name:          "process-" + user.Identifier(),
+		IP:            net.ParseIP("127.0.0.1"),
+		HostPort:      ports[0],
+		VncPort:       ports[1],
+		GuacamolePort: ports[2],
+		LfsxPort:      DefaultLfsxPort,
+		ImageVersion:  b.config.GetLfsImageVersion(),
+	}
+
+	// Every user gets an own data directory
+	dataDir := filepath.Join(b.config.ProcessBackend.DataDir, user.Identifier())
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create data directory %q: %s", dataDir, err)
+	}
+
+	// The ports are passed via environment variables to the host agent. It starts the
+	// desktop (sway, wayvnc and guacd) on them
+	lfsConfigDir := filepath.Join(dataDir, "config-dev")
+	if b.config.Production {
+		lfsConfigDir = filepath.Join(dataDir, "config-prod")
+	}
+	cmd := exec.Command(b.config.ProcessBackend.Command)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("APP_LFS_ADDRESS=%s", s.session.HostAddress()),
+		fmt.Sprintf("APP_LFS_VNC_PORT=%d", s.session.VncPort),
+		fmt.Sprintf("APP_LFS_GUACD_PORT=%d", s.session.GuacamolePort),
+		"APP_LFS_START_DESKTOP=true",
+		fmt.Sprintf("APP_LFS_PROC_DATA=%s", dataDir),
+		fmt.Sprintf("APP_LFS_CONFIG=%s", lfsConfigDir),
+		fmt.Sprintf("APP_LFS_SERVICE_ENDPOINT=%s", b.config.LfsServiceEndpoint),
+	)
+	// Set process group id for child processes so we can kill them all from the parent
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %q: %s", b.config.ProcessBackend.Command, err)
+	}
+	s.cmd = cmd
+
+	// Remove the session after the host agent exited
+	exited := make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		logger.Info("Session process of user %q exited: %v", user.DbUser, err)
+		close(exited)
+
+		b.sessionsSync.Lock()
+		if b.sessions[user.Identifier()] == s {
+			delete(b.sessions, user.Identifier())
+		}
+		b.sessionsSync.Unlock()
+	}()
+
+	// Wait until the host agent is ready. Use the same timeout as for the pods
+	if err := waitForReadiness(s.session, exited, 10*time.Second); err != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		return err
+	}
+
+	logger.Info("Started session process for user %q on %s", user.DbUser, s.session.HostAddress())
+	return nil
+}
+
+// waitForReadiness polls the readiness endpoint of the host agent until it
+// returns 200, the process exited or the timeout was reached
+func waitForReadiness(session *Session, exited chan struct{}, timeout time.Duration) error {
+	client := http.Client{Timeout: 1 * time.Second}
+	deadline := time.After(timeout)
+
+	for {
+		if resp, err := client.Get(fmt.Sprintf("http://%s/api/readyz", session.HostAddress())); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == 200 {
+				return nil
+			}
+		}
+
+		select {
+		case <-time.After(200 * time.Millisecond):
+			continue
+		case <-exited:
+			return fmt.Errorf("session process exited before it became ready")
+		case <-deadline:
+			return fmt.Errorf("timeout while waiting for session readiness")
+		}
+	}
+}
+
+// getFreePorts returns the given number of currently unused TCP ports
+func getFreePorts(count int) ([]int, error) {
+	ports := make([]int, 0, count)
+
+	// Keep all listeners open until every port was determined. Otherwise the same
+	// port could be returned twice
+	listeners := make([]net.Listener, 0, count)
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+
+	for i := 0; i < count; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+
+	return ports, nil
+}
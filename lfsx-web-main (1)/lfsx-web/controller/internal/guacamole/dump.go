@@ -0,0 +1,96 @@
+package guacamole
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// DumpReader reads a sequence of instructions from a protocol dump
+// like a session recording written by guacd
+type DumpReader struct {
+	reader *bufio.Reader
+	buffer []byte
+}
+
+// NewDumpReader creates a reader for the instructions within r
+func NewDumpReader(r io.Reader) *DumpReader {
+	return &DumpReader{
+		reader: bufio.NewReaderSize(r, MaxGuacMessage),
+		buffer: make([]byte, 0, MaxGuacMessage),
+	}
+}
+
+// Read returns the next instruction of the dump. At the end of the
+// dump io.EOF is returned. A dump that ends in the middle of an
+// instruction (e.g. a recording of a crashed guacd) returns
+// io.ErrUnexpectedEOF
+func (r *DumpReader) Read() (*Instruction, error) {
+	r.buffer = r.buffer[:0]
+
+	for {
+		// Length of the element
+		lengthStart := len(r.buffer)
+		for {
+			b, err := r.reader.ReadByte()
+			if err != nil {
+				return nil, r.eof(err)
+			}
+			r.buffer = append(r.buffer, b)
+
+			if b == '.' {
+				break
+			}
+			if b < '0' || b > '9' {
+				// Whitespace between two instructions is allowed
+				if lengthStart == 0 && len(r.buffer) == 1 && (b == '\n' || b == '\r' || b == ' ') {
+					r.buffer = r.buffer[:0]
+					continue
+				}
+				return nil, errors.New("guac.Read: wrong pattern instruction")
+			}
+		}
+
+		length, err := strconv.Atoi(string(r.buffer[lengthStart : len(r.buffer)-1]))
+		if err != nil {
+			return nil, errors.New("guac.Read: wrong pattern instruction")
+		}
+
+		// The length is given in characters and not in bytes
+		for i := 0; i < length; i++ {
+			c, size, err := r.reader.ReadRune()
+			if err != nil {
+				return nil, r.eof(err)
+			}
+			if c == utf8.RuneError && size == 1 {
+				return nil, errors.New("guac.Read: invalid utf-8 within element")
+			}
+			r.buffer = utf8.AppendRune(r.buffer, c)
+		}
+
+		// Terminator after the element
+		terminator, err := r.reader.ReadByte()
+		if err != nil {
+			return nil, r.eof(err)
+		}
+		r.buffer = append(r.buffer, terminator)
+
+		if terminator == ';' {
+			return Parse(r.buffer)
+		} else if terminator != ',' {
+			return nil, errors.New("guac.Read: invalid terminator (corrupted instruction?)")
+		}
+	}
+}
+
+// eof converts an end of the reader in the middle of an instruction
+// into io.ErrUnexpectedEOF
+func (r *DumpReader) eof(err error) error {
+	if err == io.EOF && len(r.buffer) > 0 {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
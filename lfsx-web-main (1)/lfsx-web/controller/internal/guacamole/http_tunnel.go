@@ -0,0 +1,183 @@
+package guacamole
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gitea.hama.de/LFS/go-logger"
+)
+
+// Headers used by the HTTP tunnel of guacamole-common-js
+const (
+	TunnelTokenHeader  = "Guacamole-Tunnel-Token"
+	StatusCodeHeader   = "Guacamole-Status-Code"
+	ErrorMessageHeader = "Guacamole-Error-Message"
+)
+
+// Marks the end of a HTTP response. The client starts a new request afterwards
+var endOfResponseIns = []byte("0.;")
+
+// HTTPTunnelConnector opens the tunnel for the given "connect" request.
+//
+// The body of the request contains the connection parameters given by
+// the client
+type HTTPTunnelConnector func(r *http.Request) (Tunnel, error)
+
+// HTTPTunnelServer implements the HTTP tunnel protocol of guacamole-common-js
+// ("Guacamole.HTTPTunnel") that is used when no WebSocket connection can be established.
+//
+// The client opens a tunnel with "?connect" and receives the UUID of the tunnel.
+// Afterwards it reads the instructions of guacd with long polling requests
+// ("?read:<uuid>:<n>") and sends its own instructions with "?write:<uuid>"
+type HTTPTunnelServer struct {
+	tunnels *TunnelMap
+	connect HTTPTunnelConnector
+}
+
+// NewHTTPTunnelServer creates a new server that opens the tunnels with the given
+// connector and stores them within the given map
+func NewHTTPTunnelServer(tunnels *TunnelMap, connect HTTPTunnelConnector) *HTTPTunnelServer {
+	return &HTTPTunnelServer{
+		tunnels: tunnels,
+		connect: connect,
+	}
+}
+
+// ServeHTTP handles a single request of the tunnel protocol
+func (s *HTTPTunnelServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.RawQuery
+
+	switch {
+	case query == "connect":
+		s.doConnect(w, r)
+	case strings.HasPrefix(query, "read:"):
+		// The request number after the UUID does only prevent caching
+		uuid, _, _ := strings.Cut(strings.TrimPrefix(query, "read:"), ":")
+		if tunnel, ok := s.getTunnel(w, r, uuid); ok {
+			s.doRead(w, tunnel)
+		}
+	case strings.HasPrefix(query, "write:"):
+		if tunnel, ok := s.getTunnel(w, r, strings.TrimPrefix(query, "write:")); ok {
+			s.doWrite(w, r, tunnel)
+		}
+	default:
+		writeError(w, ErrClient.NewError("Invalid tunnel operation"))
+	}
+}
+
+// doConnect opens and registers a new tunnel. The UUID is returned to the client
+func (s *HTTPTunnelServer) doConnect(w http.ResponseWriter, r *http.Request) {
+	tunnel, err := s.connect(r)
+	if err != nil {
+		logger.Debug("Failed to open HTTP tunnel: %s", err)
+		writeError(w, err)
+		return
+	}
+
+	token, err := s.tunnels.Put(tunnel)
+	if err != nil {
+		logger.Warning("Failed to generate the token of the HTTP tunnel: %s", err)
+		tunnel.Close()
+		writeError(w, ErrServer.NewError("Failed to register the tunnel"))
+		return
+	}
+	logger.Debug("Opened HTTP tunnel %q", tunnel.GetUUID())
+
+	w.Header().Set(TunnelTokenHeader, token)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(tunnel.GetUUID()))
+}
+
+// getTunnel returns the tunnel with the given UUID. If the tunnel doesn't exist (anymore)
+// an error is written to the client
+func (s *HTTPTunnelServer) getTunnel(w http.ResponseWriter, r *http.Request, uuid string) (Tunnel, bool) {
+	tunnel, ok := s.tunnels.Get(uuid, r.Header.Get(TunnelTokenHeader))
+	if !ok {
+		writeError(w, ErrResourceNotFound.NewError("No such tunnel"))
+	}
+
+	return tunnel, ok
+}
+
+// doRead streams the instructions of guacd to the client until another read
+// request of the client is waiting
+func (s *HTTPTunnelServer) doRead(w http.ResponseWriter, tunnel Tunnel) {
+	reader := tunnel.AcquireReader()
+	defer tunnel.ReleaseReader()
+
+	// Webkit browsers buffer the first KB of a response with any other content type
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+
+	for {
+		ins, err := reader.ReadSome()
+		if err != nil {
+			logger.Debug("Closing HTTP tunnel %q after reading from guacd failed: %s", tunnel.GetUUID(), err)
+			s.tunnels.Remove(tunnel.GetUUID())
+			tunnel.Close()
+
+			// Tell the client that no more instructions will follow
+			w.Write(endOfResponseIns)
+			flush()
+			return
+		}
+
+		if _, err := w.Write(ins); err != nil {
+			logger.Debug("Failed to write to the client of HTTP tunnel %q: %s", tunnel.GetUUID(), err)
+			return
+		}
+		if !reader.Available() {
+			flush()
+		}
+
+		// The next request of the client takes over
+		if tunnel.HasQueuedReaderThreads() {
+			break
+		}
+	}
+
+	w.Write(endOfResponseIns)
+	flush()
+}
+
+// doWrite passes the instructions within the request body to guacd
+func (s *HTTPTunnelServer) doWrite(w http.ResponseWriter, r *http.Request, tunnel Tunnel) {
+	writer := tunnel.AcquireWriter()
+	defer tunnel.ReleaseWriter()
+
+	if _, err := io.Copy(writer, r.Body); err != nil {
+		logger.Debug("Closing HTTP tunnel %q after writing to guacd failed: %s", tunnel.GetUUID(), err)
+		s.tunnels.Remove(tunnel.GetUUID())
+		tunnel.Close()
+		writeError(w, ErrConnectionClosed.NewError("Failed to write to guacd"))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeError writes the given error in the format of the HTTP tunnel. Errors that are not
+// guacamole errors are send as a server error
+func writeError(w http.ResponseWriter, err error) {
+	status := ServerError
+	if guacErr, ok := err.(*ErrGuac); ok {
+		status = guacErr.Status
+	}
+
+	w.Header().Set(StatusCodeHeader, strconv.Itoa(status.GetGuacamoleStatusCode()))
+	w.Header().Set(ErrorMessageHeader, err.Error())
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status.GetHTTPStatusCode())
+	w.Write([]byte(err.Error()))
+}
@@ -1,9 +1,11 @@
 package guacamole
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"strconv"
+	"unicode/utf8"
 )
 
 // Instruction represents a Guacamole instruction
@@ -101,6 +103,49 @@ func Parse(buf []byte) (*Instruction, error) {
 	return NewInstruction(elements[0], elements[1:]...), nil
 }
 
+// NextInstruction splits the first complete instruction from the given data without
+// parsing it. When the data doesn't contain a complete instruction, nil and the
+// unchanged data are returned
+func NextInstruction(data []byte) (instruction []byte, rest []byte, err error) {
+	i := 0
+	for i < len(data) {
+		// Length of the element
+		lengthEnd := bytes.IndexByte(data[i:], '.')
+		if lengthEnd == -1 {
+			return nil, data, nil
+		}
+		length, e := strconv.Atoi(string(data[i : i+lengthEnd]))
+		if e != nil {
+			return nil, data, errors.New("guac.NextInstruction: wrong pattern instruction")
+		}
+		i += lengthEnd + 1
+
+		// The length is given in characters and not in bytes
+		for c := 0; c < length; c++ {
+			if !utf8.FullRune(data[i:]) {
+				return nil, data, nil
+			}
+			_, size := utf8.DecodeRune(data[i:])
+			i += size
+		}
+
+		// Terminator after the element
+		if i >= len(data) {
+			return nil, data, nil
+		}
+		terminator := data[i]
+		i++
+
+		if terminator == ';' {
+			return data[:i], data[i:], nil
+		} else if terminator != ',' {
+			return nil, data, errors.New("guac.NextInstruction: invalid terminator (corrupted instruction?)")
+		}
+	}
+
+	return nil, data, nil
+}
+
 // ReadOne takes an instruction from the stream and parses it into an Instruction
 func ReadOne(stream *Stream) (instruction *Instruction, err error) {
 	var instructionBuffer []byte
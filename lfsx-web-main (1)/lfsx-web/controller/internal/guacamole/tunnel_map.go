@@ -0,0 +1,114 @@
+package guacamole
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+)
+
+// TunnelTimeout is the time after which a tunnel is closed when
+// the client didn't send any request for it
+const TunnelTimeout = 15 * time.Second
+
+// registeredTunnel is a tunnel within the TunnelMap
+type registeredTunnel struct {
+	Tunnel
+
+	// Secret the client has to send with every request of the tunnel.
+	// The UUID alone is part of the URL and could be leaked
+	token string
+
+	lastAccess time.Time
+}
+
+// TunnelMap contains all tunnels used by HTTP tunnel clients indexed by
+// the UUID of the tunnel.
+//
+// Tunnels that weren't accessed within the TunnelTimeout are closed
+type TunnelMap struct {
+	tunnels map[string]*registeredTunnel
+	lock    sync.Mutex
+}
+
+// NewTunnelMap creates an empty tunnel map
+func NewTunnelMap() *TunnelMap {
+	return &TunnelMap{
+		tunnels: make(map[string]*registeredTunnel),
+	}
+}
+
+// Put registers the given tunnel and returns the token the client has
+// to send with all further requests of the tunnel
+func (m *TunnelMap) Put(tunnel Tunnel) (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	rt := &registeredTunnel{Tunnel: tunnel, token: hex.EncodeToString(token), lastAccess: time.Now()}
+	m.tunnels[tunnel.GetUUID()] = rt
+	return rt.token, nil
+}
+
+// Get returns the tunnel with the given UUID if the token does match.
+// Accessing a tunnel resets its timeout
+func (m *TunnelMap) Get(uuid string, token string) (Tunnel, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	rt, doesExist := m.tunnels[uuid]
+	if !doesExist || token == "" || rt.token != token {
+		return nil, false
+	}
+
+	rt.lastAccess = time.Now()
+	return rt.Tunnel, true
+}
+
+// Remove removes the tunnel with the given UUID without closing it
+func (m *TunnelMap) Remove(uuid string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.tunnels, uuid)
+}
+
+// Run closes the tunnels that weren't accessed within the TunnelTimeout.
+//
+// This method does block until the given context is canceled
+func (m *TunnelMap) Run(ctx context.Context) {
+	ticker := time.NewTicker(TunnelTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			// Don't close the tunnels while holding the lock. Closing may remove the tunnel
+			m.lock.Lock()
+			expired := make([]Tunnel, 0)
+			for uuid, rt := range m.tunnels {
+				if now.Sub(rt.lastAccess) > TunnelTimeout {
+					expired = append(expired, rt.Tunnel)
+					delete(m.tunnels, uuid)
+				}
+			}
+			m.lock.Unlock()
+
+			for _, tunnel := range expired {
+				logger.Debug("Closing HTTP tunnel %q because it wasn't used within %s", tunnel.GetUUID(), TunnelTimeout)
+				if err := tunnel.Close(); err != nil {
+					logger.Debug("Failed to close HTTP tunnel: %s", err)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package kuber
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/metrics"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
+	batchv1 "k8s.io/api/batch/v1"
+	modelsv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Reasons why the garbage collector removes a pod or job
+const (
+	GCReasonOutdatedPlaceholder = "outdatedPlaceholder"
+	GCReasonStuck               = "stuck"
+	GCReasonFailed              = "failed"
+	GCReasonCompleted           = "completed"
+	GCReasonOrphaned            = "orphaned"
+)
+
+// GCCandidate is a pod or job that is removed by the garbage collector
+type GCCandidate struct {
+
+	// "Job" or "Pod"
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+
+	// The pod that caused the removal of the job
+	Pod string `json:"pod,omitempty"`
+
+	User string `json:"user,omitempty"`
+	Db   string `json:"db,omitempty"`
+
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+
+	// Only set for placeholders. The deletion fails if the placeholder was claimed in the meantime
+	resourceVersion string
+	uid             types.UID
+}
+
+// GCReport is the result of a single run of the garbage collector
+type GCReport struct {
+	DryRun bool      `json:"dryRun"`
+	RunAt  time.Time `json:"runAt"`
+
+	Candidates []GCCandidate `json:"candidates"`
+
+	// Number of candidates that were deleted
+	Deleted int      `json:"deleted"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// GarbageCollector removes LFS pods and jobs that are no longer needed:
+// placeholders of outdated image versions or removed profiles, pods that are stuck in pending,
+// failed or completed pods and sessions without a connected client.
+//
+// Every deletion is recorded as a kubernetes event of the deleted object
+type GarbageCollector struct {
+	kuber  *Kuber
+	config models.GCConfig
+
+	// Time since when a claimed pod is seen without a replica owning its session
+	orphanedSince map[string]time.Time
+
+	// Only a single run at a time
+	lock sync.Mutex
+}
+
+// newGarbageCollector creates a new garbage collector for the LFS pods and jobs
+func newGarbageCollector(kuber *Kuber, config models.GCConfig) *GarbageCollector {
+	return &GarbageCollector{
+		kuber:         kuber,
+		config:        config,
+		orphanedSince: make(map[string]time.Time),
+	}
+}
+
+// Run collects the garbage periodically until the given context is canceled.
+//
+// This method does block
+func (gc *GarbageCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(gc.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			report := gc.Collect(gc.config.DryRun)
+			if report.DryRun && len(report.Candidates) > 0 {
+				logger.Info("Garbage collector would remove %d pods and jobs (dry run)", len(report.Candidates))
+			}
+		case <-ctx.Done():
+			logger.Info("Stopped the garbage collector")
+			return
+		}
+	}
+}
+
+// Collect searches for pods and jobs to remove. When "dryRun" is set, nothing is
+// removed and only the report is returned
+func (gc *GarbageCollector) Collect(dryRun bool) GCReport {
+	gc.lock.Lock()
+	defer gc.lock.Unlock()
+
+	report := GCReport{DryRun: dryRun, RunAt: time.Now()}
+	report.Candidates = gc.candidates(report.RunAt)
+
+	if dryRun {
+		return report
+	}
+
+	for _, c := range report.Candidates {
+		if err := gc.delete(c); err != nil {
+			logger.Warning("Garbage collector failed to delete %s %q: %s", c.Kind, c.Name, err)
+			report.Errors = append(report.Errors, fmt.Sprintf("%s %q: %s", c.Kind, c.Name, err))
+			continue
+		}
+
+		logger.Info("Garbage collector deleted %s %q of user %q: %s", c.Kind, c.Name, c.User, c.Message)
+		metrics.GarbageCollected.WithLabelValues(c.Reason).Inc()
+		report.Deleted++
+	}
+
+	return report
+}
+
+// candidates returns all pods and jobs that should be removed at the given time
+func (gc *GarbageCollector) candidates(now time.Time) []GCCandidate {
+	rtc := make([]GCCandidate, 0)
+	seen := make(map[string]bool)
+	add := func(c GCCandidate) {
+		if key := c.Kind + "/" + c.Name; !seen[key] {
+			seen[key] = true
+			rtc = append(rtc, c)
+		}
+	}
+
+	// Placeholders of an old image version or a removed profile can't be claimed anymore
+	imageVersion := gc.kuber.appConfig.GetLfsImageVersion()
+	for _, job := range gc.kuber.cache.listJobs(indexPlaceholder, "true") {
+		if job.DeletionTimestamp != nil {
+			continue
+		}
+
+		message := ""
+		if job.Labels["imageVersion"] != imageVersion {
+			message = fmt.Sprintf("Placeholder of the outdated image version %q", job.Labels["imageVersion"])
+		} else if _, ok := gc.kuber.appConfig.Profiles.Profile(profileOf(job.Labels)); !ok {
+			message = fmt.Sprintf("Placeholder of the removed profile %q", profileOf(job.Labels))
+		}
+
+		if message != "" {
+			add(GCCandidate{
+				Kind:            "Job",
+				Name:            job.Name,
+				Reason:          GCReasonOutdatedPlaceholder,
+				Message:         message,
+				resourceVersion: job.ResourceVersion,
+				uid:             job.UID,
+			})
+		}
+	}
+
+	orphaned := make(map[string]time.Time)
+	for _, pod := range gc.kuber.cache.allPods() {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		reason, message := "", ""
+		switch {
+		case pod.Status.Phase == modelsv1.PodFailed:
+			reason, message = GCReasonFailed, fmt.Sprintf("Pod failed: %s", pod.Status.Reason)
+		case pod.Status.Phase == modelsv1.PodSucceeded:
+			reason, message = GCReasonCompleted, "Pod completed"
+		case pod.Status.Phase == modelsv1.PodPending && now.Sub(pod.CreationTimestamp.Time) > gc.config.StuckAfter:
+			reason, message = GCReasonStuck, fmt.Sprintf("Pod is pending for %s: %s", now.Sub(pod.CreationTimestamp.Time).Round(time.Second), waitingReason(pod))
+		case pod.Labels["placeholder"] == "false" && pod.Status.Phase == modelsv1.PodRunning && !gc.isOwned(pod):
+			since, ok := gc.orphanedSince[pod.Name]
+			if !ok {
+				since = now
+			}
+			orphaned[pod.Name] = since
+
+			if now.Sub(since) > gc.config.OrphanedAfter {
+				reason, message = GCReasonOrphaned, fmt.Sprintf("No client connected to the session for %s", now.Sub(since).Round(time.Second))
+			}
+		}
+		if reason == "" {
+			continue
+		}
+
+		c := GCCandidate{Kind: "Pod", Name: pod.Name, uid: pod.UID, User: pod.Labels["user"], Db: pod.Labels["db"], Reason: reason, Message: message}
+
+		// Removing only the pod would make the job start a new one
+		if owner := ownerOf(pod, "Job"); owner != nil {
+			c.Kind, c.Name, c.Pod, c.uid = "Job", owner.Name, pod.Name, owner.UID
+		}
+		add(c)
+	}
+	gc.orphanedSince = orphaned
+
+	sort.Slice(rtc, func(a, b int) bool {
+		if rtc[a].Reason != rtc[b].Reason {
+			return rtc[a].Reason < rtc[b].Reason
+		}
+		return rtc[a].Name < rtc[b].Name
+	})
+	return rtc
+}
+
+// isOwned returns weather a replica holds a valid lease for the session of the given pod
+func (gc *GarbageCollector) isOwned(pod *modelsv1.Pod) bool {
+	for _, lease := range gc.kuber.cache.listLeases(indexUser, userIndexKey(pod.Labels["db"], pod.Labels["user"])) {
+		if leaseHolder(lease) != "" && !isLeaseExpired(lease) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// delete removes the given candidate and records an event for it
+func (gc *GarbageCollector) delete(c GCCandidate) error {
+	propagation := metav1.DeletePropagationBackground
+	options := metav1.DeleteOptions{PropagationPolicy: &propagation}
+	if c.resourceVersion != "" {
+		options.Preconditions = &metav1.Preconditions{ResourceVersion: &c.resourceVersion}
+	}
+
+	var err error
+	if c.Kind == "Job" {
+		err = gc.kuber.Client.BatchV1().Jobs(gc.kuber.Namespace).Delete(context.Background(), c.Name, options)
+	} else {
+		err = gc.kuber.Client.CoreV1().Pods(gc.kuber.Namespace).Delete(context.Background(), c.Name, options)
+	}
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	gc.recordEvent(c)
+	return nil
+}
+
+// recordEvent creates a kubernetes event for the deleted candidate
+func (gc *GarbageCollector) recordEvent(c GCCandidate) {
+	apiVersion := "v1"
+	if c.Kind == "Job" {
+		apiVersion = batchv1.SchemeGroupVersion.String()
+	}
+	now := metav1.NewTime(time.Now())
+	component := utils.GetEnvString("BASE_APP_NAME", "lfsx-web") + "-controller"
+
+	_, err := gc.kuber.Client.CoreV1().Events(gc.kuber.Namespace).Create(context.Background(), &modelsv1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: c.Name + ".",
+			Namespace:    gc.kuber.Namespace,
+		},
+		InvolvedObject: modelsv1.ObjectReference{
+			APIVersion: apiVersion,
+			Kind:       c.Kind,
+			Namespace:  gc.kuber.Namespace,
+			Name:       c.Name,
+			UID:        c.uid,
+		},
+		Reason:              "GarbageCollected",
+		Message:             fmt.Sprintf("Deleted by the garbage collector (%s): %s", c.Reason, c.Message),
+		Type:                modelsv1.EventTypeNormal,
+		Source:              modelsv1.EventSource{Component: component},
+		ReportingController: component,
+		ReportingInstance:   gc.kuber.appConfig.Replica.Name,
+		Action:              "Delete",
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		logger.Debug("Failed to record the deletion of %s %q as event: %s", c.Kind, c.Name, err)
+	}
+}
+
+// ownerOf returns the owner reference of the given kind. Nil is returned if the pod has no such owner
+func ownerOf(pod *modelsv1.Pod, kind string) *metav1.OwnerReference {
+	for i, owner := range pod.OwnerReferences {
+		if owner.Kind == kind {
+			return &pod.OwnerReferences[i]
+		}
+	}
+
+	return nil
+}
+
+// waitingReason returns the reason why the containers of the pod are
+// waiting (e.g. "ImagePullBackOff")
+func waitingReason(pod *modelsv1.Pod) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+			return status.State.Waiting.Reason
+		}
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Status == modelsv1.ConditionFalse && condition.Reason != "" {
+			return condition.Reason
+		}
+	}
+
+	return "unknown reason"
+}
@@ -0,0 +1,280 @@
+package kuber
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	modelsv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Names of the indexes that are available for the LFS pods and jobs
+const (
+	// Indexed by "db/user"
+	indexUser = "user"
+	// Indexed by the value of the "placeholder" label
+	indexPlaceholder = "placeholder"
+	// Indexed by the value of the "imageVersion" label
+	indexImageVersion = "imageVersion"
+)
+
+// lfsCache is a shared informer cache for all LFS pods, jobs and session leases.
+// All lookups are served from memory so that a login does not result
+// into List calls against the API server
+type lfsCache struct {
+	factory informers.SharedInformerFactory
+
+	pods   cache.SharedIndexInformer
+	jobs   cache.SharedIndexInformer
+	leases cache.SharedIndexInformer
+
+	// Channels that are notified when a pod matching the condition was changed
+	podWaiters     map[chan *modelsv1.Pod]func(*modelsv1.Pod) bool
+	podWaitersLock sync.Mutex
+}
+
+// newLfsCache creates and starts the informers for the LFS pods, jobs and leases
+// within the given namespace.
+// This method blocks until the initial synchronization of the cache was finished
+func newLfsCache(client kubernetes.Interface, namespace string) (*lfsCache, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 10*time.Minute,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = "appGeneric=lfs"
+		}),
+	)
+
+	c := &lfsCache{
+		factory:    factory,
+		pods:       factory.Core().V1().Pods().Informer(),
+		jobs:       factory.Batch().V1().Jobs().Informer(),
+		leases:     factory.Coordination().V1().Leases().Informer(),
+		podWaiters: make(map[chan *modelsv1.Pod]func(*modelsv1.Pod) bool),
+	}
+
+	// Register the indexes
+	indexers := cache.Indexers{
+		indexUser:         indexByUser,
+		indexPlaceholder:  indexByLabel("placeholder"),
+		indexImageVersion: indexByLabel("imageVersion"),
+	}
+	if err := c.pods.AddIndexers(indexers); err != nil {
+		return nil, fmt.Errorf("failed to add pod indexers: %s", err)
+	}
+	if err := c.jobs.AddIndexers(indexers); err != nil {
+		return nil, fmt.Errorf("failed to add job indexers: %s", err)
+	}
+	if err := c.leases.AddIndexers(cache.Indexers{indexUser: indexByUser}); err != nil {
+		return nil, fmt.Errorf("failed to add lease indexers: %s", err)
+	}
+
+	// Notify the waiters on pod changes
+	c.pods.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.onPodChange,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.onPodChange(newObj)
+		},
+	})
+
+	// Start the informers. They are never stopped because the cache lives as long as the app
+	stop := make(chan struct{})
+	factory.Start(stop)
+
+	logger.Debug("Waiting for the initial sync of the pod, job and lease cache")
+	if !cache.WaitForCacheSync(stop, c.pods.HasSynced, c.jobs.HasSynced, c.leases.HasSynced) {
+		return nil, fmt.Errorf("failed to sync the pod, job and lease cache")
+	}
+
+	return c, nil
+}
+
+// indexByUser indexes an object by its "db" and "user" label
+func indexByUser(obj interface{}) ([]string, error) {
+	meta, err := metaOf(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{userIndexKey(meta.Labels["db"], meta.Labels["user"])}, nil
+}
+
+// indexByLabel returns an index function that indexes an object by the value
+// of the given label. Objects without the label are not indexed
+func indexByLabel(label string) cache.IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		meta, err := metaOf(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		if val, ok := meta.Labels[label]; ok {
+			return []string{val}, nil
+		}
+		return []string{}, nil
+	}
+}
+
+// userIndexKey returns the key for the user index
+func userIndexKey(db string, user string) string {
+	return db + "/" + user
+}
+
+// metaOf returns the object metadata of a cached pod, job or lease
+func metaOf(obj interface{}) (*metav1.ObjectMeta, error) {
+	switch o := obj.(type) {
+	case *modelsv1.Pod:
+		return &o.ObjectMeta, nil
+	case *batchv1.Job:
+		return &o.ObjectMeta, nil
+	case *coordinationv1.Lease:
+		return &o.ObjectMeta, nil
+	default:
+		return nil, fmt.Errorf("unsupported object type %T", obj)
+	}
+}
+
+// listPods returns all cached pods for the given index value.
+// The returned pods are shared with the cache and must not be modified
+func (c *lfsCache) listPods(index string, value string) []*modelsv1.Pod {
+	objs, err := c.pods.GetIndexer().ByIndex(index, value)
+	if err != nil {
+		logger.Warning("Failed to query pod cache by %q: %s", index, err)
+		return nil
+	}
+
+	rtc := make([]*modelsv1.Pod, 0, len(objs))
+	for _, obj := range objs {
+		rtc = append(rtc, obj.(*modelsv1.Pod))
+	}
+	return rtc
+}
+
+// listJobs returns all cached jobs for the given index value.
+// The returned jobs are shared with the cache and must not be modified
+func (c *lfsCache) listJobs(index string, value string) []*batchv1.Job {
+	objs, err := c.jobs.GetIndexer().ByIndex(index, value)
+	if err != nil {
+		logger.Warning("Failed to query job cache by %q: %s", index, err)
+		return nil
+	}
+
+	rtc := make([]*batchv1.Job, 0, len(objs))
+	for _, obj := range objs {
+		rtc = append(rtc, obj.(*batchv1.Job))
+	}
+	return rtc
+}
+
+// listLeases returns all cached leases for the given index value.
+// The returned leases are shared with the cache and must not be modified
+func (c *lfsCache) listLeases(index string, value string) []*coordinationv1.Lease {
+	objs, err := c.leases.GetIndexer().ByIndex(index, value)
+	if err != nil {
+		logger.Warning("Failed to query lease cache by %q: %s", index, err)
+		return nil
+	}
+
+	rtc := make([]*coordinationv1.Lease, 0, len(objs))
+	for _, obj := range objs {
+		rtc = append(rtc, obj.(*coordinationv1.Lease))
+	}
+	return rtc
+}
+
+// allPods returns all cached pods.
+// The returned pods are shared with the cache and must not be modified
+func (c *lfsCache) allPods() []*modelsv1.Pod {
+	objs := c.pods.GetStore().List()
+
+	rtc := make([]*modelsv1.Pod, 0, len(objs))
+	for _, obj := range objs {
+		rtc = append(rtc, obj.(*modelsv1.Pod))
+	}
+	return rtc
+}
+
+// allLeases returns all cached leases.
+// The returned leases are shared with the cache and must not be modified
+func (c *lfsCache) allLeases() []*coordinationv1.Lease {
+	objs := c.leases.GetStore().List()
+
+	rtc := make([]*coordinationv1.Lease, 0, len(objs))
+	for _, obj := range objs {
+		rtc = append(rtc, obj.(*coordinationv1.Lease))
+	}
+	return rtc
+}
+
+// waitForPod blocks until a pod matching the given condition is available in
+// the cache or the timeout was reached
+func (c *lfsCache) waitForPod(match func(*modelsv1.Pod) bool, timeout time.Duration) (*modelsv1.Pod, error) {
+	notify := make(chan *modelsv1.Pod, 1)
+
+	// Register before looking into the cache so that no update gets lost
+	c.podWaitersLock.Lock()
+	c.podWaiters[notify] = match
+	c.podWaitersLock.Unlock()
+	defer func() {
+		c.podWaitersLock.Lock()
+		delete(c.podWaiters, notify)
+		c.podWaitersLock.Unlock()
+	}()
+
+	// The pod may already be in the cache
+	for _, obj := range c.pods.GetStore().List() {
+		if p := obj.(*modelsv1.Pod); match(p) {
+			return p, nil
+		}
+	}
+
+	select {
+	case p := <-notify:
+		return p, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timeout while waiting for pod")
+	}
+}
+
+// onPodChange notifies all waiters whose condition matches the changed pod
+func (c *lfsCache) onPodChange(obj interface{}) {
+	p, ok := obj.(*modelsv1.Pod)
+	if !ok {
+		return
+	}
+
+	c.podWaitersLock.Lock()
+	defer c.podWaitersLock.Unlock()
+
+	for notify, match := range c.podWaiters {
+		if match(p) {
+			select {
+			case notify <- p:
+			default:
+			}
+		}
+	}
+}
+
+// isPodReady returns weather the pod is running and all of its
+// containers are ready
+func isPodReady(p *modelsv1.Pod) bool {
+	if p.Status.Phase != modelsv1.PodRunning || p.DeletionTimestamp != nil {
+		return false
+	}
+
+	// For the readiness an array of states is given -> loop until ready state was found with message "True"
+	for _, a := range p.Status.Conditions {
+		if a.Type == modelsv1.ContainersReady && a.Status == modelsv1.ConditionTrue {
+			return true
+		}
+	}
+
+	return false
+}
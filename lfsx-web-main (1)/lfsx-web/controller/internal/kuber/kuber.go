@@ -11,9 +11,10 @@ import (
 
 	"gitea.hama.de/LFS/go-logger"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
-	"gitea.hama.de/LFS/lfsx-web/controller/templates"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	schemar "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	schema "k8s.io/client-go/kubernetes/scheme"
 	cr "sigs.k8s.io/controller-runtime"
@@ -32,6 +33,21 @@ type Kuber struct {
 	// Kubernetes client used for the API requests
 	Client *kubernetes.Clientset
 
+	// Informer cache of all LFS pods and jobs
+	cache *lfsCache
+
+	// Pool of warm placeholder jobs
+	Pool *Pool
+
+	// Registry of the controller replicas owning the sessions
+	Registry *SessionRegistry
+
+	// Removes stale, orphaned and stuck pods and jobs
+	GC *GarbageCollector
+
+	// Templates of the created ressources
+	templates *templateStore
+
 	// App configuration
 	appConfig *models.AppConfig
 }
@@ -40,6 +56,11 @@ type Kuber struct {
 // with the cluster
 func NewKuber(appConfig *models.AppConfig) (*Kuber, error) {
 
+	// Invalid quantities would only be noticed when a pod is created
+	if err := validateProfiles(appConfig.Profiles); err != nil {
+		return nil, err
+	}
+
 	// Get configuration for speaking with the API
 	config, err := cr.GetConfig()
 	if err != nil {
@@ -58,11 +79,54 @@ func NewKuber(appConfig *models.AppConfig) (*Kuber, error) {
 		return nil, err
 	}
 
-	return &Kuber{
+	// Start the shared cache for the pods and jobs
+	cache, err := newLfsCache(clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Kuber{
 		Namespace: namespace,
 		Client:    clientset,
+		cache:     cache,
 		appConfig: appConfig,
-	}, nil
+		templates: newTemplateStore(appConfig.TemplateDir),
+	}
+
+	// A broken template is reported by the readiness check instead of failing on the first login
+	if err := k.ReloadTemplates(); err != nil {
+		logger.Warning("The controller won't become ready until the templates are fixed")
+	}
+	k.Pool = newPool(k, appConfig.Pool)
+	k.Registry = newSessionRegistry(k, appConfig.Replica)
+	k.GC = newGarbageCollector(k, appConfig.GC)
+
+	return k, nil
+}
+
+// validateProfiles checks that the names of all profiles are valid label values and
+// that their resources are valid quantities
+func validateProfiles(config models.ProfileConfig) error {
+	for _, p := range config.Profiles {
+		// The name is used as label of the pods
+		if errs := validation.IsValidLabelValue(p.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid name of resource profile %q: %s", p.Name, strings.Join(errs, ", "))
+		}
+
+		quantities := map[string]string{
+			"cpuRequest":    p.CPURequest,
+			"memoryRequest": p.MemoryRequest,
+			"cpuLimit":      p.CPULimit,
+			"memoryLimit":   p.MemoryLimit,
+		}
+		for name, val := range quantities {
+			if _, err := resource.ParseQuantity(val); err != nil {
+				return fmt.Errorf("invalid %s %q of resource profile %q: %s", name, val, p.Name, err)
+			}
+		}
+	}
+
+	return nil
 }
 
 // getNamespace returns the currently set namespace
@@ -89,19 +153,23 @@ func getNamespace() (string, error) {
 }
 
 // getRessourceFromFile reads an k8 ressource from the relative path of the "template"
-// folder and returns an generic object that you can cast base on "GroupVersionKind" to
-// a concrete ressource
+// folder (or the overriding template directory) and returns an generic object that you
+// can cast base on "GroupVersionKind" to a concrete ressource
 func (k *Kuber) getRessourceFromFile(path string, templateData any) (runtime.Object, *schemar.GroupVersionKind, error) {
-
-	// Parse template
-	template, err := template.ParseFS(templates.TemplateFiles, path)
+	template, err := k.templates.get(path)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to parse template for pod creation: %s", err)
+		return nil, nil, err
 	}
 
+	return renderRessource(template, templateData)
+}
+
+// renderRessource executes the given template and decodes the result
+func renderRessource(template *template.Template, templateData any) (runtime.Object, *schemar.GroupVersionKind, error) {
+
 	// Read template into buffer
 	var tmplBuf bytes.Buffer
-	if err = template.Execute(&tmplBuf, templateData); err != nil {
+	if err := template.Execute(&tmplBuf, templateData); err != nil {
 		return nil, nil, fmt.Errorf("failed to execute template: %s", err)
 	}
 
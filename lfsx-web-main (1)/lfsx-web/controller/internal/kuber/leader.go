@@ -0,0 +1,81 @@
+package kuber
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Timings of the leader election. Another replica takes over the
+// leadership when the lease wasn't renewed within the duration
+const (
+	leaderLeaseDuration = 15 * time.Second
+	leaderRenewDeadline = 10 * time.Second
+	leaderRetryPeriod   = 2 * time.Second
+)
+
+// RunLeaderTasks runs the placeholder pool and the garbage collector only while this
+// replica holds the leader lease, so that only a single replica creates and deletes
+// placeholders and pods. The tasks are stopped when the leadership is lost and the
+// election is repeated until the given context is canceled.
+//
+// This method does block
+func (k *Kuber) RunLeaderTasks(ctx context.Context) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      utils.GetEnvString("BASE_APP_NAME", "lfsx-web") + "-leader",
+			Namespace: k.Namespace,
+		},
+		Client:     k.Client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: k.appConfig.Replica.Name},
+	}
+
+	// The tasks of a lost leadership may still be stopping when the next one starts
+	var running sync.Mutex
+
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaderLeaseDuration,
+			RenewDeadline:   leaderRenewDeadline,
+			RetryPeriod:     leaderRetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            lock.LeaseMeta.Name,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) {
+					running.Lock()
+					defer running.Unlock()
+					logger.Info("Replica %q is the leader. Starting the placeholder pool and the garbage collector", k.appConfig.Replica.Name)
+
+					var tasks sync.WaitGroup
+					tasks.Add(2)
+					go func() {
+						defer tasks.Done()
+						k.Pool.Run(leaderCtx)
+					}()
+					go func() {
+						defer tasks.Done()
+						k.GC.Run(leaderCtx)
+					}()
+					tasks.Wait()
+				},
+				OnStoppedLeading: func() {
+					logger.Info("Replica %q is no longer the leader", k.appConfig.Replica.Name)
+				},
+				OnNewLeader: func(identity string) {
+					if identity != k.appConfig.Replica.Name {
+						logger.Debug("Replica %q is the leader", identity)
+					}
+				},
+			},
+		})
+	}
+
+	logger.Info("Stopped the leader election")
+}
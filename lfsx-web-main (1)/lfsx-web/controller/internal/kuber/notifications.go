@@ -0,0 +1,108 @@
+package kuber
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/registry"
+	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
+	modelsv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Key of the config map containing the notifications as JSON list
+const notificationsKey = "notifications.json"
+
+// Make sure that the notifications can be shared by all replicas
+var _ registry.NotificationStore = (*Kuber)(nil)
+
+// notificationsName returns the name of the config map containing the notifications
+func notificationsName() string {
+	return utils.GetEnvString("BASE_APP_NAME", "lfsx-web") + "-notifications"
+}
+
+// AddNotification implements registry.NotificationStore by storing the given
+// notification in a config map that is read by all replicas
+func (k *Kuber) AddNotification(notification models.Notification) error {
+	configMaps := k.Client.CoreV1().ConfigMaps(k.Namespace)
+	name := notificationsName()
+
+	// Retry on conflicts with other replicas that are adding a notification at the same time
+	for i := 0; i < 3; i++ {
+		configMap, err := configMaps.Get(context.Background(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			configMap = &modelsv1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: k.Namespace,
+					Labels: map[string]string{
+						"app": utils.GetEnvString("BASE_APP_NAME", "lfsx-web") + "-notifications",
+					},
+				},
+			}
+		} else if err != nil {
+			return fmt.Errorf("failed to get the notifications: %s", err)
+		}
+
+		notifications, err := parseNotifications(configMap)
+		if err != nil {
+			return err
+		}
+		notifications = append(models.ActiveNotifications(notifications, time.Now()), notification)
+
+		data, err := json.Marshal(notifications)
+		if err != nil {
+			return fmt.Errorf("failed to marshal the notifications: %s", err)
+		}
+		configMap.Data = map[string]string{notificationsKey: string(data)}
+
+		if configMap.ResourceVersion == "" {
+			_, err = configMaps.Create(context.Background(), configMap, metav1.CreateOptions{})
+		} else {
+			_, err = configMaps.Update(context.Background(), configMap, metav1.UpdateOptions{})
+		}
+
+		if err == nil {
+			return nil
+		} else if !apierrors.IsConflict(err) && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to store the notification: %s", err)
+		}
+	}
+
+	return fmt.Errorf("failed to store the notification because of concurrent modifications")
+}
+
+// Notifications implements registry.NotificationStore by returning the
+// active notifications of the config map
+func (k *Kuber) Notifications() ([]models.Notification, error) {
+	configMap, err := k.Client.CoreV1().ConfigMaps(k.Namespace).Get(context.Background(), notificationsName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get the notifications: %s", err)
+	}
+
+	notifications, err := parseNotifications(configMap)
+	if err != nil {
+		return nil, err
+	}
+	return models.ActiveNotifications(notifications, time.Now()), nil
+}
+
+// parseNotifications returns the notifications stored in the given config map
+func parseNotifications(configMap *modelsv1.ConfigMap) ([]models.Notification, error) {
+	data, ok := configMap.Data[notificationsKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var notifications []models.Notification
+	if err := json.Unmarshal([]byte(data), &notifications); err != nil {
+		return nil, fmt.Errorf("failed to parse the notifications of config map %q: %s", configMap.Name, err)
+	}
+	return notifications, nil
+}
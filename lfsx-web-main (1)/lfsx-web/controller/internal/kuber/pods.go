@@ -9,10 +9,13 @@ import (
 	"time"
 
 	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/backend"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/metrics"
 	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
 	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
 	batchv1 "k8s.io/api/batch/v1"
 	modelsv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
@@ -28,6 +31,57 @@ type podCreateTemplateData struct {
 	Namespace          string
 	IsPlaceholder      bool
 	ImageVersion       string
+	RecordingClaim     string
+	RecordingPath      string
+	WorkspaceClaim     string
+	Profile            models.ResourceProfile
+}
+
+// GetSession implements backend.SessionBackend by returning the pod
+// that is assigned for the given user
+func (k *Kuber) GetSession(user *models.User) (*backend.Session, bool, error) {
+	pod, wasCreated, err := k.GetPodByUser(user)
+	if err != nil {
+		return nil, wasCreated, err
+	}
+
+	return backend.NewSession(pod.Name, pod.Status.PodIP, pod.Labels["imageVersion"]), wasCreated, nil
+}
+
+// FindSession implements backend.SessionBackend by returning the pod
+// of the given user without creating a new one
+func (k *Kuber) FindSession(user *models.User) (*backend.Session, error) {
+	pod, err := k.findPodForUser(user)
+	if err != nil || pod == nil {
+		return nil, err
+	}
+
+	return backend.NewSession(pod.Name, pod.Status.PodIP, pod.Labels["imageVersion"]), nil
+}
+
+// DeleteSession implements backend.SessionBackend by deleting the jobs
+// (and so the pods) of the given user
+func (k *Kuber) DeleteSession(user *models.User) error {
+	appName := utils.GetEnvString("BASE_APP_NAME", "lfsx-web") + "-lfs"
+	propagation := metav1.DeletePropagationBackground
+
+	// Jobs created for the user have no placeholder label, claimed placeholders are labeled with "false"
+	jobs := k.cache.listJobs(indexUser, userIndexKey(strings.ToLower(user.Database.String()), strings.ToLower(user.DbUser)))
+	for _, job := range jobs {
+		if job.Labels["app"] != appName || job.Labels["placeholder"] == "true" {
+			continue
+		}
+
+		logger.Info("Deleting job %q of user %q", job.Name, user.DbUser)
+		err := k.Client.BatchV1().Jobs(k.Namespace).Delete(context.Background(), job.Name, metav1.DeleteOptions{
+			PropagationPolicy: &propagation,
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete job %q: %s", job.Name, err)
+		}
+	}
+
+	return nil
 }
 
 // found returns an pod that is assigned for the given user.
@@ -43,7 +97,7 @@ func (k *Kuber) GetPodByUser(user *models.User) (*modelsv1.Pod, bool, error) {
 	}
 
 	// Create a new pod for the user
-	pod, err := k.createJobForUserAbstract(user)
+	pod, err := k.createJobForUserAbstract(user, k.appConfig.ResourceProfile(user))
 	if err != nil {
 		return pod, true, err
 	}
@@ -54,62 +108,43 @@ func (k *Kuber) GetPodByUser(user *models.User) (*modelsv1.Pod, bool, error) {
 // findPodForUser returns an already started pod for the given user.
 // If no pod was found nil will be returned
 func (k *Kuber) findPodForUser(user *models.User) (*modelsv1.Pod, error) {
-
-	// Filter condition for the container
-	sel := metav1.LabelSelector{
-		MatchLabels: map[string]string{
-			"app":         utils.GetEnvString("BASE_APP_NAME", "lfsx-web") + "-lfs",
-			"db":          strings.ToLower(user.Database.String()),
-			"user":        strings.ToLower(user.DbUser),
-			"placeholder": "false",
-			// We don't filter after the imageVersion. The user would not be abled to go to his
-			// old pod after an update of the controller / LFS.X.
-			// If we want to force to user to reconnect, add a helm hook!
-			//"imageVersion": k.appConfig.GetLfsImageVersion(),
-		},
-	}
-
-	// Make the request
-	pods, err := k.Client.CoreV1().Pods(k.Namespace).List(context.Background(), metav1.ListOptions{LabelSelector: metav1.FormatLabelSelector(&sel)})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get available pods: %s", err)
-	}
-
-	// A pod does already exist
-	if len(pods.Items) > 0 {
-		logger.Debug("Found pod for user%q: %s", user.DbUser, pods.Items[0].Status.PodIP)
-		return &pods.Items[0], nil
+	appName := utils.GetEnvString("BASE_APP_NAME", "lfsx-web") + "-lfs"
+
+	// Lookup the pods of the user from the cache.
+	// We don't filter after the imageVersion. The user would not be abled to go to his
+	// old pod after an update of the controller / LFS.X.
+	// Outdated pods are replaced on login depending on the rollout policy.
+	// Pods that are already terminating are skipped
+	pods := k.cache.listPods(indexUser, userIndexKey(strings.ToLower(user.Database.String()), strings.ToLower(user.DbUser)))
+	for _, p := range pods {
+		if p.Labels["app"] == appName && p.Labels["placeholder"] == "false" && p.DeletionTimestamp == nil {
+			logger.Debug("Found pod for user%q: %s", user.DbUser, p.Status.PodIP)
+			return p.DeepCopy(), nil
+		}
 	}
 
 	return nil, nil
 }
 
 // createJobForUserAbstract creates a new job for the given user or changes an existing
-// placeholder job so that it can be used for this user.
+// placeholder job of the given profile so that it can be used for this user.
 // This function does hide the implemntation detail
-func (k *Kuber) createJobForUserAbstract(user *models.User) (*modelsv1.Pod, error) {
-
-	// Try to get a placeholder job that is not used already
-	sel := metav1.LabelSelector{
-		MatchLabels: map[string]string{
-			"appGeneric":   "lfs",
-			"placeholder":  "true",
-			"imageVersion": k.appConfig.GetLfsImageVersion(),
-		},
-	}
+func (k *Kuber) createJobForUserAbstract(user *models.User, profile models.ResourceProfile) (*modelsv1.Pod, error) {
+	start := time.Now()
 
-	for {
-		// Execute the request
-		jobs, err := k.Client.BatchV1().Jobs(k.Namespace).List(context.Background(), metav1.ListOptions{
-			LabelSelector: metav1.FormatLabelSelector(&sel),
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to find placeholders: %s", err)
+	// Try to get a placeholder job that is not used already. The cache may still contain
+	// placeholders that were claimed by another request. So retry a few times
+	for attempt := 0; attempt < 5 && k.appConfig.Pool.Enabled; attempt++ {
+		jobs := k.GetPlaceholders(profile.Name)
+
+		// No pods were found
+		if len(jobs) == 0 {
+			break
 		}
 
 		// Sort the array so that the oldest created jobs will be used first
-		sort.Slice(jobs.Items, func(a, b int) bool {
-			return jobs.Items[a].CreationTimestamp.Time.Before(jobs.Items[b].CreationTimestamp.Time)
+		sort.Slice(jobs, func(a, b int) bool {
+			return jobs[a].CreationTimestamp.Time.Before(jobs[b].CreationTimestamp.Time)
 		})
 
 		// Get patch string as "MergePatchType"
@@ -126,7 +161,7 @@ func (k *Kuber) createJobForUserAbstract(user *models.User) (*modelsv1.Pod, erro
 		}
 
 		// Try to update a job with the specified ressource version. If that does succeed the job wasn't used before
-		for _, job := range jobs.Items {
+		for _, job := range jobs {
 			ressourceVersion := job.ResourceVersion
 			patch.Metadata.ResourceVersion = ressourceVersion
 
@@ -146,23 +181,22 @@ func (k *Kuber) createJobForUserAbstract(user *models.User) (*modelsv1.Pod, erro
 			if err == nil {
 				// Get random identifier from username label
 				identifier := job.Labels["user"]
-				sel.MatchLabels["user"] = identifier
 				logger.Debug("Found and updated placeholder job %q for user %q", identifier, user.Username)
 
 				// Get the pod name that was created for the job
-				pods, err := k.Client.CoreV1().Pods(k.Namespace).List(context.Background(), metav1.ListOptions{
-					LabelSelector: metav1.FormatLabelSelector(&sel),
-				})
-				if err != nil {
-					return nil, fmt.Errorf("failed to find pods: %s", err)
+				pods := make([]*modelsv1.Pod, 0, 1)
+				for _, p := range k.cache.listPods(indexUser, userIndexKey("placeholder", identifier)) {
+					if p.Labels["placeholder"] == "true" {
+						pods = append(pods, p)
+					}
 				}
 
-				if len(pods.Items) != 1 {
+				if len(pods) != 1 {
 					return nil, fmt.Errorf("found no pod for job identifier")
 				}
 
 				// Build patch data
-				patch.Metadata.ResourceVersion = pods.Items[0].ResourceVersion
+				patch.Metadata.ResourceVersion = pods[0].ResourceVersion
 				patchJson, err := json.Marshal(patch)
 				if err != nil {
 					return nil, fmt.Errorf("failed druing marshal of patch json: %s", err)
@@ -170,24 +204,13 @@ func (k *Kuber) createJobForUserAbstract(user *models.User) (*modelsv1.Pod, erro
 
 				// Execute request
 				pod, err := k.Client.CoreV1().Pods(k.Namespace).Patch(
-					context.Background(), pods.Items[0].Name, types.MergePatchType, patchJson, metav1.PatchOptions{},
+					context.Background(), pods[0].Name, types.MergePatchType, patchJson, metav1.PatchOptions{},
 				)
 
-				// Create new pods again
+				// Refill the pool
 				if err == nil {
-					go func(numberOfPlaceholders int) {
-						if _, err := k.CreatePlaceholderJob(); err != nil {
-							logger.Warning("Failed to create placeholder job: %s", err)
-						}
-
-						// Create another placeholder if no more than two placeholder pods were available
-						if numberOfPlaceholders < 2 {
-							logger.Debug("Starting another placeholder because current count is %d", numberOfPlaceholders)
-							if _, err := k.CreatePlaceholderJob(); err != nil {
-								logger.Warning("Failed to create placeholder job: %s", err)
-							}
-						}
-					}(len(jobs.Items))
+					metrics.ClaimDuration.WithLabelValues(metrics.ClaimPlaceholder).Observe(time.Since(start).Seconds())
+					k.Pool.Trigger()
 				}
 
 				return pod, err
@@ -196,48 +219,58 @@ func (k *Kuber) createJobForUserAbstract(user *models.User) (*modelsv1.Pod, erro
 			}
 		}
 
-		// No pods were found
-		if len(jobs.Items) == 0 {
-			break
-		}
+		// All placeholders were claimed in the meantime. Give the cache some time to catch up
+		time.Sleep(100 * time.Millisecond)
 	}
 
-	// As a last option create an own pod specific for the user
-	go func() {
-		for i := 0; i < 2; i++ {
-			if _, err := k.CreatePlaceholderJob(); err != nil {
-				logger.Warning("Failed to create placeholder job: %s", err)
-			}
-		}
-	}()
+	// As a last option create an own pod specific for the user. The pool was obviously too small
+	if k.appConfig.Pool.Enabled {
+		k.Pool.Trigger()
+	}
 
-	return k.createJodForUser(user)
+	pod, err := k.createJodForUser(user, profile)
+	if err == nil {
+		metrics.ClaimDuration.WithLabelValues(metrics.ClaimNewJob).Observe(time.Since(start).Seconds())
+	}
+	return pod, err
 }
 
-// GetPlaceholders returns a list of placeholder jobs that can be assigned
-// to a specifc user
-func (k *Kuber) GetPlaceholders() (*batchv1.JobList, error) {
+// GetPlaceholders returns a list of placeholder jobs of the given profile that
+// can be assigned to a specifc user.
+// The returned jobs are served from the cache and must not be modified
+func (k *Kuber) GetPlaceholders(profile string) []*batchv1.Job {
+	imageVersion := k.appConfig.GetLfsImageVersion()
 
 	// Only select plceholders for the current version
-	sel := metav1.LabelSelector{
-		MatchLabels: map[string]string{
-			"appGeneric":   "lfs",
-			"placeholder":  "true",
-			"imageVersion": k.appConfig.GetLfsImageVersion(),
-		},
+	jobs := k.cache.listJobs(indexPlaceholder, "true")
+	rtc := make([]*batchv1.Job, 0, len(jobs))
+	for _, j := range jobs {
+		if j.Labels["imageVersion"] == imageVersion && profileOf(j.Labels) == profile {
+			rtc = append(rtc, j)
+		}
 	}
 
-	return k.Client.BatchV1().Jobs(k.Namespace).List(context.Background(), metav1.ListOptions{
-		LabelSelector: metav1.FormatLabelSelector(&sel),
-	})
+	return rtc
 }
 
 // createJodForUser creates a new Job that runs a Pod with the LFS for the given
-// user and starts it up.
+// user and profile and starts it up.
 // This method blocks until the container is up and running
-func (k *Kuber) createJodForUser(user *models.User) (*modelsv1.Pod, error) {
+func (k *Kuber) createJodForUser(user *models.User, profile models.ResourceProfile) (*modelsv1.Pod, error) {
 	logger.Debug("Creating job for user %q", user.DbUser)
 
+	// The persistent volume may still need to be provisioned
+	readyTimeout := 10 * time.Second
+	workspaceClaim := ""
+	if k.appConfig.Workspace.Enabled {
+		claim, err := k.ensureWorkspace(user)
+		if err != nil {
+			return nil, err
+		}
+		workspaceClaim = claim
+		readyTimeout = 60 * time.Second
+	}
+
 	// Parse template data
 	lfsConfigDir := "/opt/lfs-user/config-dev"
 	if k.appConfig.Production {
@@ -253,6 +286,10 @@ func (k *Kuber) createJodForUser(user *models.User) (*modelsv1.Pod, error) {
 			ImageVersion:       k.appConfig.GetLfsImageVersion(),
 			BaseName:           utils.GetEnvString("BASE_APP_NAME", "lfsx-web"),
 			Namespace:          k.Namespace,
+			RecordingClaim:     k.appConfig.Recording.Claim,
+			RecordingPath:      k.appConfig.Recording.SessionPath,
+			WorkspaceClaim:     workspaceClaim,
+			Profile:            profile,
 		},
 	)
 	if err != nil {
@@ -266,49 +303,23 @@ func (k *Kuber) createJodForUser(user *models.User) (*modelsv1.Pod, error) {
 	}
 
 	// Wait until pod is up and running
-	watchOptions := metav1.ListOptions{
-		LabelSelector: metav1.FormatLabelSelector(&metav1.LabelSelector{
-			MatchLabels: map[string]string{
-				"db":   strings.ToLower(user.Database.String()),
-				"user": strings.ToLower(user.DbUser),
-			},
-		}),
-	}
-
-	podwatch, err := k.Client.CoreV1().Pods(k.Namespace).Watch(context.TODO(), watchOptions)
+	logger.Trc("Waiting for pod to become ready")
+	db, username := strings.ToLower(user.Database.String()), strings.ToLower(user.DbUser)
+	p, err := k.cache.waitForPod(func(p *modelsv1.Pod) bool {
+		return p.Labels["db"] == db && p.Labels["user"] == username && isPodReady(p)
+	}, readyTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("failed to wait for created pod: %s", err)
+		return nil, fmt.Errorf("timeout while waiting for pod readiness")
 	}
 
-	// Use a timeout of 8 seconds for pod readiness
-	logger.Trc("Waiting for pod to become ready")
-	timeout := time.After(10 * time.Second)
-	for {
-		select {
-		case pr := <-podwatch.ResultChan():
-			p := pr.Object.(*modelsv1.Pod)
-
-			// Check if the pod is running and is ready. For the readiness an array of
-			// states is given -> loop until ready state was found with message "True"
-			if p.Status.Phase == modelsv1.PodRunning {
-				for _, a := range p.Status.Conditions {
-					if a.Type == modelsv1.ContainersReady && a.Status == "True" {
-						logger.Trc("Pod is redy now")
-						return p, nil
-					}
-				}
-			} else {
-				logger.Trc("Pod was changed but it is not ready yet. Current phase: %s", p.Status.Phase)
-			}
-		case <-timeout:
-			return nil, fmt.Errorf("timeout while waiting for pod readiness")
-		}
-	}
+	logger.Trc("Pod is redy now")
+	return p.DeepCopy(), nil
 }
 
-// CreatePlaceholderJob creates a new Job that runs a Pod with the LFS without logging in.
+// CreatePlaceholderJob creates a new Job of the given profile that runs a Pod with the
+// LFS without logging in.
 // This method blocks until the container got created
-func (k *Kuber) CreatePlaceholderJob() (*batchv1.Job, error) {
+func (k *Kuber) CreatePlaceholderJob(profile models.ResourceProfile) (*batchv1.Job, error) {
 	// Generate a random string to identify the pod with the job
 	identifier, _ := utils.GenerateRandomString(24)
 	identifier = "p" + identifier + "p"
@@ -330,6 +341,9 @@ func (k *Kuber) CreatePlaceholderJob() (*batchv1.Job, error) {
 			ImageVersion:       k.appConfig.GetLfsImageVersion(),
 			BaseName:           utils.GetEnvString("BASE_APP_NAME", "lfsx-web"),
 			Namespace:          k.Namespace,
+			RecordingClaim:     k.appConfig.Recording.Claim,
+			RecordingPath:      k.appConfig.Recording.SessionPath,
+			Profile:            profile,
 			IsPlaceholder:      true,
 		},
 	)
@@ -350,36 +364,12 @@ func (k *Kuber) CreatePlaceholderJob() (*batchv1.Job, error) {
 	return job, err
 }
 
-// DeleteCompletedLfsPods removes all pods that are in a "completed" state
-// and are having the
-func (k *Kuber) DeleteCompletedLfsPods() error {
-
-	// Filter condition for the container
-	sel := metav1.LabelSelector{
-		MatchLabels: map[string]string{
-			"appGeneric": "lfs",
-		},
+// profileOf returns the profile of a pod or job from its labels. Pods and jobs
+// created before the profiles were introduced belong to the default profile
+func profileOf(labels map[string]string) string {
+	if profile := labels["profile"]; profile != "" {
+		return profile
 	}
 
-	// Execute the request
-	pods, err := k.Client.CoreV1().Pods(k.Namespace).List(context.Background(), metav1.ListOptions{
-		LabelSelector: metav1.FormatLabelSelector(&sel),
-		FieldSelector: "status.phase=completed",
-	})
-	if err != nil {
-		return err
-	}
-
-	// Loop through every pod and delete it
-	for _, pod := range pods.Items {
-		// Check if the pod was already scheduled to delete
-		if pod.DeletionTimestamp != nil {
-			logger.Debug("Trying to delete pod %q", pod.Name)
-			if err := k.Client.CoreV1().Pods(k.Namespace).Delete(context.Background(), pod.Name, *metav1.NewDeleteOptions(5)); err != nil {
-				logger.Debug("Failed to delete pod %q: %s", pod.Name, err)
-			}
-		}
-	}
-
-	return nil
+	return models.DefaultProfile
 }
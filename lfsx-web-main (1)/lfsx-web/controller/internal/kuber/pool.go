@@ -0,0 +1,362 @@
+package kuber
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/metrics"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Limits of the backoff after a failed creation of a placeholder
+const (
+	poolMinBackoff = 5 * time.Second
+	poolMaxBackoff = 5 * time.Minute
+)
+
+// Pool keeps a configurable number of placeholder jobs of every resource profile
+// for the current image version warm so that a user can claim one on login.
+//
+// The pool is reconciled periodically and every time a placeholder
+// was claimed
+type Pool struct {
+	kuber  *Kuber
+	config models.PoolConfig
+
+	// Triggers an immediate reconciliation
+	trigger chan struct{}
+
+	// Placeholder jobs that were created but are not in the cache yet
+	inFlight map[string]inFlightPlaceholder
+
+	// Backoff after a failed job creation
+	backoff      time.Duration
+	backoffUntil time.Time
+
+	// The last observed state of the pool
+	state     PoolState
+	stateLock sync.RWMutex
+}
+
+// PoolState is a snapshot of the current pool state
+type PoolState struct {
+
+	// Image version of the placeholders in the pool
+	ImageVersion string `json:"imageVersion"`
+
+	// Number of idle placeholders that should be available over all profiles
+	Desired int `json:"desired"`
+	// Number of placeholders that are ready to be claimed
+	Warm int `json:"warm"`
+	// Number of placeholders that are starting up
+	Pending int `json:"pending"`
+	// Number of pods that are assigned to a user
+	Claimed int `json:"claimed"`
+	// Number of placeholders of an old image version that are drained
+	Outdated int `json:"outdated"`
+
+	// The last error that occurred while creating a placeholder
+	LastError string `json:"lastError,omitempty"`
+	// No placeholders are created until this time because of a previous error
+	BackoffUntil *time.Time `json:"backoffUntil,omitempty"`
+
+	// Time of the last reconciliation
+	LastReconcile time.Time `json:"lastReconcile"`
+
+	// State of the placeholders of every resource profile
+	Profiles map[string]PoolProfileState `json:"profiles"`
+}
+
+// PoolProfileState is a snapshot of the placeholders of a single resource profile
+type PoolProfileState struct {
+	Desired int `json:"desired"`
+	Warm    int `json:"warm"`
+	Pending int `json:"pending"`
+}
+
+// inFlightPlaceholder is a placeholder job that was created but is not in the cache yet
+type inFlightPlaceholder struct {
+	profile string
+	created time.Time
+}
+
+// newPool creates a new pool for the placeholders
+func newPool(kuber *Kuber, config models.PoolConfig) *Pool {
+	return &Pool{
+		kuber:    kuber,
+		config:   config,
+		trigger:  make(chan struct{}, 1),
+		inFlight: make(map[string]inFlightPlaceholder),
+	}
+}
+
+// Run reconciles the pool periodically until the given context is canceled.
+//
+// This method does block
+func (p *Pool) Run(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	p.reconcile()
+	for {
+		select {
+		case <-ticker.C:
+			p.reconcile()
+		case <-p.trigger:
+			p.reconcile()
+		case <-ctx.Done():
+			logger.Info("Stopped reconciling the placeholder pool")
+			return
+		}
+	}
+}
+
+// Trigger requests an immediate reconciliation of the pool
+// without blocking
+func (p *Pool) Trigger() {
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// State returns the last observed state of the pool
+func (p *Pool) State() PoolState {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+
+	return p.state
+}
+
+// reconcile creates or deletes placeholders so that the desired number
+// of idle placeholders is available for every resource profile
+func (p *Pool) reconcile() {
+	now := time.Now()
+
+	state := PoolState{Profiles: make(map[string]PoolProfileState)}
+	var lastError error
+	for _, profile := range p.kuber.appConfig.Profiles.Profiles {
+		profileState, err := p.reconcileProfile(profile, now)
+		if err != nil {
+			lastError = err
+		}
+
+		state.Profiles[profile.Name] = profileState
+		state.Desired += profileState.Desired
+		state.Warm += profileState.Warm
+		state.Pending += profileState.Pending
+	}
+
+	// Placeholders of an old image version or a removed profile can't be claimed anymore
+	outdated := p.drainOutdated()
+
+	// Update the state
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+
+	state.ImageVersion = p.kuber.appConfig.GetLfsImageVersion()
+	state.Claimed = len(p.kuber.cache.listPods(indexPlaceholder, "false"))
+	state.Outdated = outdated
+	state.LastReconcile = now
+	state.LastError = p.state.LastError
+	if lastError != nil {
+		state.LastError = lastError.Error()
+	}
+	if now.Before(p.backoffUntil) {
+		backoffUntil := p.backoffUntil
+		state.BackoffUntil = &backoffUntil
+	}
+	p.state = state
+
+	logger.Trc("Reconciled placeholder pool: %+v", p.state)
+	p.updateMetrics()
+}
+
+// reconcileProfile creates or deletes placeholders of the given profile and returns
+// the resulting state of the profile
+func (p *Pool) reconcileProfile(profile models.ResourceProfile, now time.Time) (PoolProfileState, error) {
+	desired, maxIdle := p.idleLimits(profile, now)
+	warm, pending := p.getPlaceholders(profile.Name)
+
+	// Jobs that were created but are not yet in the cache are counted as pending
+	inFlight := 0
+	for name, job := range p.inFlight {
+		if job.profile != profile.Name {
+			continue
+		}
+
+		if now.Sub(job.created) > time.Minute || p.isCached(name, warm, pending) {
+			delete(p.inFlight, name)
+		} else {
+			inFlight++
+		}
+	}
+	idle := len(warm) + len(pending) + inFlight
+
+	// Create missing placeholders
+	var lastError error
+	if missing := desired - idle; missing > 0 && now.After(p.backoffUntil) {
+		logger.Debug("Creating %d placeholders of profile %q for the pool", missing, profile.Name)
+
+		for i := 0; i < missing; i++ {
+			job, err := p.kuber.CreatePlaceholderJob(profile)
+			if err != nil {
+				// Quota rejections and other errors would otherwise be retried on every reconciliation
+				p.backoff *= 2
+				if p.backoff < poolMinBackoff {
+					p.backoff = poolMinBackoff
+				} else if p.backoff > poolMaxBackoff {
+					p.backoff = poolMaxBackoff
+				}
+				p.backoffUntil = now.Add(p.backoff)
+				lastError = err
+
+				logger.Warning("Failed to create placeholder job of profile %q. Retrying in %s: %s", profile.Name, p.backoff, err)
+				break
+			}
+
+			p.backoff = 0
+			p.inFlight[job.Name] = inFlightPlaceholder{profile: profile.Name, created: now}
+			inFlight++
+		}
+	}
+
+	// Remove surplus placeholders. Pending ones are removed first and then the newest
+	limit := desired
+	if maxIdle > limit {
+		limit = maxIdle
+	}
+	if surplus := idle - limit; surplus > 0 {
+		logger.Debug("Removing %d surplus placeholders of profile %q from the pool", surplus, profile.Name)
+
+		for _, job := range append(pending, warm...) {
+			if surplus <= 0 {
+				break
+			}
+
+			p.deletePlaceholder(job)
+			surplus--
+		}
+	}
+
+	return PoolProfileState{
+		Desired: desired,
+		Warm:    len(warm),
+		Pending: len(pending) + inFlight,
+	}, lastError
+}
+
+// idleLimits returns the desired and the maximum number of idle placeholders of the
+// given profile. The default profile is sized by the pool configuration.
+//
+// No placeholders are kept when the pool is disabled
+func (p *Pool) idleLimits(profile models.ResourceProfile, now time.Time) (desired int, maxIdle int) {
+	if !p.config.Enabled {
+		return 0, 0
+	} else if profile.Name == models.DefaultProfile {
+		return p.config.DesiredIdle(now), p.config.MaxIdle
+	}
+
+	return profile.MinIdle, profile.MaxIdle
+}
+
+// updateMetrics exports the number of placeholders of every image version.
+// Placeholders of old versions are still counted until they are removed
+func (p *Pool) updateMetrics() {
+	sizes := make(map[string]int)
+	for _, job := range p.kuber.cache.listJobs(indexPlaceholder, "true") {
+		sizes[job.Labels["imageVersion"]]++
+	}
+
+	metrics.PoolSize.Reset()
+	for version, size := range sizes {
+		metrics.PoolSize.WithLabelValues(version).Set(float64(size))
+	}
+}
+
+// getPlaceholders returns the placeholder jobs of the current image version and the
+// given profile splitted into ready (warm) and starting (pending) ones.
+// Both lists are sorted from the newest to the oldest job
+func (p *Pool) getPlaceholders(profile string) (warm []*batchv1.Job, pending []*batchv1.Job) {
+	jobs := p.kuber.GetPlaceholders(profile)
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].CreationTimestamp.Time.After(jobs[b].CreationTimestamp.Time)
+	})
+
+	for _, job := range jobs {
+		ready := false
+		for _, pod := range p.kuber.cache.listPods(indexUser, userIndexKey("placeholder", job.Labels["user"])) {
+			if pod.Labels["placeholder"] == "true" && isPodReady(pod) {
+				ready = true
+				break
+			}
+		}
+
+		if ready {
+			warm = append(warm, job)
+		} else {
+			pending = append(pending, job)
+		}
+	}
+
+	return
+}
+
+// drainOutdated deletes all placeholders of an old image version or of a profile
+// that is no longer configured and returns the number of them. Placeholders that
+// are already deleted are counted until they are removed from the cache.
+//
+// The garbage collector reports them as well. They are drained here so that they are
+// removed right away and even if the garbage collector only runs dry
+func (p *Pool) drainOutdated() int {
+	imageVersion := p.kuber.appConfig.GetLfsImageVersion()
+
+	outdated := 0
+	for _, job := range p.kuber.cache.listJobs(indexPlaceholder, "true") {
+		_, knownProfile := p.kuber.appConfig.Profiles.Profile(profileOf(job.Labels))
+		if job.Labels["imageVersion"] == imageVersion && knownProfile {
+			continue
+		}
+
+		outdated++
+		if job.DeletionTimestamp == nil {
+			logger.Debug("Draining placeholder job %q of the old image version %q or the removed profile %q", job.Name, job.Labels["imageVersion"], profileOf(job.Labels))
+			p.deletePlaceholder(job)
+		}
+	}
+
+	return outdated
+}
+
+// isCached returns weather a job with the given name is contained in one of the lists
+func (p *Pool) isCached(name string, lists ...[]*batchv1.Job) bool {
+	for _, l := range lists {
+		for _, job := range l {
+			if job.Name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// deletePlaceholder deletes the given placeholder job. The deletion fails if the
+// job was claimed in the meantime because the ressource version changed
+func (p *Pool) deletePlaceholder(job *batchv1.Job) {
+	propagation := metav1.DeletePropagationBackground
+	resourceVersion := job.ResourceVersion
+
+	err := p.kuber.Client.BatchV1().Jobs(p.kuber.Namespace).Delete(context.Background(), job.Name, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+		Preconditions:     &metav1.Preconditions{ResourceVersion: &resourceVersion},
+	})
+	if err != nil {
+		logger.Debug("Failed to delete placeholder job %q: %s", job.Name, err)
+	}
+}
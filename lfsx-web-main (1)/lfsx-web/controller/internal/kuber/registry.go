@@ -0,0 +1,261 @@
+package kuber
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/registry"
+	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Timings of the session leases. A lease that wasn't renewed within
+// the duration is treated as released (the replica died)
+const (
+	sessionLeaseDuration      = 30 * time.Second
+	sessionLeaseRenewInterval = 10 * time.Second
+)
+
+// Annotation of a session lease containing the address of the owning replica
+const annotationReplicaAddress = "lfsx-web/replica-address"
+
+// SessionRegistry records the ownership of the user sessions as
+// kubernetes leases. Every lease names the controller replica that holds
+// the WebSocket connection of the user
+type SessionRegistry struct {
+	kuber   *Kuber
+	replica models.Replica
+
+	// Names of the leases that are owned by this replica
+	owned     map[string]bool
+	ownedLock sync.Mutex
+}
+
+// Make sure that the registry can be used by the VNC proxy
+var _ registry.Registry = (*SessionRegistry)(nil)
+
+// newSessionRegistry creates a new lease based registry for the given replica
+func newSessionRegistry(kuber *Kuber, replica models.Replica) *SessionRegistry {
+	return &SessionRegistry{
+		kuber:   kuber,
+		replica: replica,
+		owned:   make(map[string]bool),
+	}
+}
+
+// Acquire records this replica as the owner of the session of the given user.
+// A lease of another replica that wasn't renewed in time is taken over
+func (r *SessionRegistry) Acquire(user *models.User) error {
+	leases := r.kuber.Client.CoordinationV1().Leases(r.kuber.Namespace)
+	name := sessionLeaseName(user)
+
+	// Retry on conflicts with other replicas that are modifying the lease at the same time
+	for i := 0; i < 3; i++ {
+		lease, err := leases.Get(context.Background(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = leases.Create(context.Background(), r.newSessionLease(user), metav1.CreateOptions{})
+		} else if err == nil {
+			if leaseHolder(lease) != r.replica.Name && !isLeaseExpired(lease) {
+				return registry.ErrSessionOwned
+			}
+
+			r.holdLease(lease)
+			_, err = leases.Update(context.Background(), lease, metav1.UpdateOptions{})
+		}
+
+		if err == nil {
+			r.ownedLock.Lock()
+			r.owned[name] = true
+			r.ownedLock.Unlock()
+			return nil
+		} else if !apierrors.IsConflict(err) && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to acquire lease %q: %s", name, err)
+		}
+	}
+
+	return fmt.Errorf("failed to acquire lease %q: too many conflicts", name)
+}
+
+// Release deletes the lease of the given user if it's held by this replica
+func (r *SessionRegistry) Release(user *models.User) {
+	name := sessionLeaseName(user)
+
+	r.ownedLock.Lock()
+	delete(r.owned, name)
+	r.ownedLock.Unlock()
+
+	leases := r.kuber.Client.CoordinationV1().Leases(r.kuber.Namespace)
+	lease, err := leases.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		logger.Debug("Failed to get lease %q for releasing: %s", name, err)
+		return
+	}
+
+	// The lease was already taken over by another replica
+	if leaseHolder(lease) != r.replica.Name {
+		return
+	}
+
+	if err := leases.Delete(context.Background(), name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+	}); err != nil {
+		logger.Debug("Failed to delete lease %q: %s", name, err)
+	}
+}
+
+// Owner returns the replica that holds a valid lease for the session of the
+// given user. The lookup is served from the cache
+func (r *SessionRegistry) Owner(user *models.User) (*models.Replica, bool) {
+	key := userIndexKey(strings.ToLower(user.Database.String()), strings.ToLower(user.DbUser))
+
+	for _, lease := range r.kuber.cache.listLeases(indexUser, key) {
+		if leaseHolder(lease) == "" || isLeaseExpired(lease) {
+			continue
+		}
+
+		return &models.Replica{
+			Name:    leaseHolder(lease),
+			Address: lease.Annotations[annotationReplicaAddress],
+		}, true
+	}
+
+	return nil, false
+}
+
+// Replicas returns all other replicas that hold a valid lease of a session.
+// The lookup is served from the cache
+func (r *SessionRegistry) Replicas() []models.Replica {
+	seen := make(map[string]bool)
+	rtc := make([]models.Replica, 0)
+
+	for _, lease := range r.kuber.cache.allLeases() {
+		holder := leaseHolder(lease)
+		if holder == "" || holder == r.replica.Name || seen[holder] || isLeaseExpired(lease) {
+			continue
+		}
+		seen[holder] = true
+
+		rtc = append(rtc, models.Replica{
+			Name:    holder,
+			Address: lease.Annotations[annotationReplicaAddress],
+		})
+	}
+
+	return rtc
+}
+
+// Run renews all leases held by this replica periodically until the
+// given context is canceled.
+//
+// This method does block
+func (r *SessionRegistry) Run(ctx context.Context) {
+	ticker := time.NewTicker(sessionLeaseRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.renew()
+		case <-ctx.Done():
+			logger.Info("Stopped renewing the session leases")
+			return
+		}
+	}
+}
+
+// renew updates the renew time of all leases held by this replica
+func (r *SessionRegistry) renew() {
+	r.ownedLock.Lock()
+	names := make([]string, 0, len(r.owned))
+	for name := range r.owned {
+		names = append(names, name)
+	}
+	r.ownedLock.Unlock()
+
+	leases := r.kuber.Client.CoordinationV1().Leases(r.kuber.Namespace)
+	for _, name := range names {
+		lease, err := leases.Get(context.Background(), name, metav1.GetOptions{})
+		if err == nil && leaseHolder(lease) != r.replica.Name {
+			logger.Warning("Lease %q was taken over by replica %q", name, leaseHolder(lease))
+
+			r.ownedLock.Lock()
+			delete(r.owned, name)
+			r.ownedLock.Unlock()
+			continue
+		}
+		if err == nil {
+			lease.Spec.RenewTime = &metav1.MicroTime{Time: time.Now()}
+			_, err = leases.Update(context.Background(), lease, metav1.UpdateOptions{})
+		}
+
+		// The next try will be made within the next interval
+		if err != nil {
+			logger.Warning("Failed to renew lease %q: %s", name, err)
+		}
+	}
+}
+
+// newSessionLease creates a new lease for the given user that is held by this replica
+func (r *SessionRegistry) newSessionLease(user *models.User) *coordinationv1.Lease {
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      sessionLeaseName(user),
+			Namespace: r.kuber.Namespace,
+			Labels: map[string]string{
+				"app":        utils.GetEnvString("BASE_APP_NAME", "lfsx-web") + "-session",
+				"user":       strings.ToLower(user.DbUser),
+				"db":         strings.ToLower(user.Database.String()),
+				"appGeneric": "lfs",
+			},
+		},
+	}
+	r.holdLease(lease)
+
+	return lease
+}
+
+// holdLease sets this replica as the holder of the given lease
+func (r *SessionRegistry) holdLease(lease *coordinationv1.Lease) {
+	now := metav1.NewMicroTime(time.Now())
+	duration := int32(sessionLeaseDuration.Seconds())
+
+	lease.Spec.HolderIdentity = &r.replica.Name
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+
+	if lease.Annotations == nil {
+		lease.Annotations = make(map[string]string)
+	}
+	lease.Annotations[annotationReplicaAddress] = r.replica.Address
+}
+
+// sessionLeaseName returns the name of the lease for the session of the given user
+func sessionLeaseName(user *models.User) string {
+	return fmt.Sprintf("%s-session-%s-%s", utils.GetEnvString("BASE_APP_NAME", "lfsx-web"), strings.ToLower(user.DbUser), strings.ToLower(user.Database.String()))
+}
+
+// isLeaseExpired returns weather the lease wasn't renewed within its duration
+func isLeaseExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+
+	return time.Since(lease.Spec.RenewTime.Time) > time.Duration(*lease.Spec.LeaseDurationSeconds)*time.Second
+}
+
+// leaseHolder returns the name of the replica holding the lease
+func leaseHolder(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+
+	return *lease.Spec.HolderIdentity
+}
@@ -0,0 +1,216 @@
+package kuber
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+	"gitea.hama.de/LFS/lfsx-web/controller/templates"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// Name of the template of the LFS jobs
+const jobTemplateName = "deployment-lfs.yaml"
+
+// Interval in which the template directory is checked for changes
+const templateReloadInterval = 30 * time.Second
+
+// templateStore holds the templates of the kubernetes ressources. A template in the
+// configured directory overrides the embedded one and is reloaded when it changes.
+//
+// Every template is test-rendered before it's used. A changed template that is
+// invalid is rejected and the previous version is kept
+type templateStore struct {
+	dir string
+
+	templates map[string]*loadedTemplate
+
+	// Errors of templates for which no valid version was ever loaded
+	errors map[string]error
+
+	lock sync.RWMutex
+}
+
+// loadedTemplate is a parsed template with the file it was read from
+type loadedTemplate struct {
+	template *template.Template
+
+	// Path of the file or "embedded"
+	source  string
+	modTime time.Time
+}
+
+// newTemplateStore creates a new store that overrides the embedded templates
+// with the ones of the given directory
+func newTemplateStore(dir string) *templateStore {
+	return &templateStore{
+		dir:       dir,
+		templates: make(map[string]*loadedTemplate),
+		errors:    make(map[string]error),
+	}
+}
+
+// get returns the current version of the template with the given name
+func (s *templateStore) get(name string) (*template.Template, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if t, ok := s.templates[name]; ok {
+		return t.template, nil
+	} else if err, ok := s.errors[name]; ok {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("the template %q is not loaded", name)
+}
+
+// parse reads the template with the given name from the template directory. The
+// embedded template is used if the directory doesn't contain it
+func (s *templateStore) parse(name string) (*loadedTemplate, error) {
+	if s.dir != "" {
+		path := filepath.Join(s.dir, name)
+		info, err := os.Stat(path)
+		if err == nil {
+			tmpl, err := template.ParseFiles(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse template %q: %s", path, err)
+			}
+
+			return &loadedTemplate{template: tmpl, source: path, modTime: info.ModTime()}, nil
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read template %q: %s", path, err)
+		}
+	}
+
+	tmpl, err := template.ParseFS(templates.TemplateFiles, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the embedded template %q: %s", name, err)
+	}
+
+	return &loadedTemplate{template: tmpl, source: "embedded"}, nil
+}
+
+// ReloadTemplates loads the templates that changed since the last call and
+// test-renders them. An error is returned if one of the templates is invalid
+func (k *Kuber) ReloadTemplates() error {
+	loaded, err := k.templates.parse(jobTemplateName)
+	if err == nil {
+		// Unchanged templates don't have to be rendered again
+		k.templates.lock.RLock()
+		current, ok := k.templates.templates[jobTemplateName]
+		k.templates.lock.RUnlock()
+		if ok && current.source == loaded.source && current.modTime.Equal(loaded.modTime) {
+			return nil
+		}
+
+		err = k.testRenderJob(loaded.template)
+	}
+
+	k.templates.lock.Lock()
+	defer k.templates.lock.Unlock()
+
+	if err != nil {
+		if _, ok := k.templates.templates[jobTemplateName]; ok {
+			logger.Warning("Rejected the changed template %q. Keeping the previous version: %s", jobTemplateName, err)
+		} else {
+			logger.Warning("No valid template %q available: %s", jobTemplateName, err)
+			k.templates.errors[jobTemplateName] = err
+		}
+		return err
+	}
+
+	logger.Info("Loaded the template %q from %s", jobTemplateName, loaded.source)
+	k.templates.templates[jobTemplateName] = loaded
+	delete(k.templates.errors, jobTemplateName)
+	return nil
+}
+
+// TemplateError returns an error if no valid version of a template is available.
+// Sessions can't be created until a valid template is provided
+func (k *Kuber) TemplateError() error {
+	k.templates.lock.RLock()
+	defer k.templates.lock.RUnlock()
+
+	if err, ok := k.templates.errors[jobTemplateName]; ok {
+		return fmt.Errorf("invalid template %q: %s", jobTemplateName, err)
+	}
+
+	return nil
+}
+
+// WatchTemplates reloads the templates of the template directory when they
+// change until the given context is canceled.
+//
+// This method does block
+func (k *Kuber) WatchTemplates(ctx context.Context) {
+	if k.templates.dir == "" {
+		return
+	}
+
+	ticker := time.NewTicker(templateReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Errors are already logged
+			_ = k.ReloadTemplates()
+		case <-ctx.Done():
+			logger.Info("Stopped watching the templates")
+			return
+		}
+	}
+}
+
+// testRenderJob renders the job template for a placeholder and for a user of every
+// profile with all options enabled and checks that the result is a valid job
+func (k *Kuber) testRenderJob(tmpl *template.Template) error {
+	defaultProfile, _ := k.appConfig.Profiles.Profile(models.DefaultProfile)
+	samples := []podCreateTemplateData{
+		{
+			Username:      "ptemplatetestp",
+			Db:            "placeholder",
+			IsPlaceholder: true,
+			Profile:       defaultProfile,
+		},
+	}
+	for _, profile := range k.appConfig.Profiles.Profiles {
+		samples = append(samples, podCreateTemplateData{
+			Username:       "templatetest",
+			Db:             "lfs",
+			RecordingClaim: "recordings",
+			RecordingPath:  "/mnt/recordings",
+			WorkspaceClaim: "workspace",
+			Profile:        profile,
+		})
+	}
+
+	for _, data := range samples {
+		data.LfsServiceEndpoint = k.appConfig.LfsServiceEndpoint
+		data.LfsConfigDir = "/opt/lfs-user/config-prod"
+		data.Image = "lfsx-web-lfs:templatetest"
+		data.ImageVersion = "templatetest"
+		data.BaseName = "lfsx-web"
+		data.Namespace = k.Namespace
+
+		obj, _, err := renderRessource(tmpl, data)
+		if err != nil {
+			return fmt.Errorf("failed to render with profile %q: %s", data.Profile.Name, err)
+		}
+		job, ok := obj.(*batchv1.Job)
+		if !ok {
+			return fmt.Errorf("the template describes a %T instead of a job", obj)
+		}
+		if len(job.Spec.Template.Spec.Containers) == 0 {
+			return fmt.Errorf("the job has no containers")
+		}
+	}
+
+	return nil
+}
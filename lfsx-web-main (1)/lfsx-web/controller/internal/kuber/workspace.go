@@ -0,0 +1,218 @@
+package kuber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
+	modelsv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Maximum time to wait until the claim of a reset workspace is removed
+const workspaceDeleteTimeout = 30 * time.Second
+
+// Maximum time to wait until the pods of the user are stopped and the claim was removed
+const workspaceResetTimeout = 2 * time.Minute
+
+// ErrNoWorkspace is returned when the user has no persistent workspace
+var ErrNoWorkspace = errors.New("the user has no workspace")
+
+// WorkspaceInfo describes the persistent workspace of a user
+type WorkspaceInfo struct {
+	Claim string `json:"claim"`
+	User  string `json:"user"`
+	Db    string `json:"db"`
+
+	// Requested and provisioned size of the volume
+	Size     string `json:"size"`
+	Capacity string `json:"capacity,omitempty"`
+
+	// Phase of the claim ("Pending", "Bound" or "Lost")
+	Phase string `json:"phase"`
+
+	// Weather the workspace is removed after the session of the user was stopped
+	Resetting bool `json:"resetting"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// workspaceClaimName returns the name of the persistent volume claim of the users workspace
+func workspaceClaimName(user *models.User) string {
+	return fmt.Sprintf("%s-workspace-%s", utils.GetEnvString("BASE_APP_NAME", "lfsx-web"), user.Identifier())
+}
+
+// ensureWorkspace returns the name of the claim of the users workspace. The claim
+// is created if it doesn't exist yet.
+//
+// When the workspace is being reset, this method blocks until the old claim was removed
+func (k *Kuber) ensureWorkspace(user *models.User) (string, error) {
+	name := workspaceClaimName(user)
+	claims := k.Client.CoreV1().PersistentVolumeClaims(k.Namespace)
+
+	// A reset workspace is only removed after the pod using it was stopped
+	deadline := time.Now().Add(workspaceDeleteTimeout)
+	for {
+		claim, err := claims.Get(context.Background(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("failed to get the workspace %q: %s", name, err)
+		}
+
+		if claim.DeletionTimestamp == nil {
+			return name, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("the workspace %q is still being reset", name)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	logger.Info("Creating the workspace %q of user %q", name, user.DbUser)
+	claim := &modelsv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: k.Namespace,
+			Labels: map[string]string{
+				"app":        utils.GetEnvString("BASE_APP_NAME", "lfsx-web") + "-workspace",
+				"appGeneric": "lfs-workspace",
+				"user":       strings.ToLower(user.DbUser),
+				"db":         strings.ToLower(user.Database.String()),
+			},
+		},
+		Spec: modelsv1.PersistentVolumeClaimSpec{
+			AccessModes: []modelsv1.PersistentVolumeAccessMode{modelsv1.ReadWriteOnce},
+			Resources: modelsv1.ResourceRequirements{
+				Requests: modelsv1.ResourceList{
+					modelsv1.ResourceStorage: k.appConfig.Workspace.Size,
+				},
+			},
+		},
+	}
+	if k.appConfig.Workspace.StorageClass != "" {
+		claim.Spec.StorageClassName = &k.appConfig.Workspace.StorageClass
+	}
+
+	_, err := claims.Create(context.Background(), claim, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("failed to create the workspace %q: %s", name, err)
+	}
+
+	return name, nil
+}
+
+// Workspace returns the persistent workspace of the given user.
+// ErrNoWorkspace is returned if the user has none
+func (k *Kuber) Workspace(user *models.User) (*WorkspaceInfo, error) {
+	claim, err := k.Client.CoreV1().PersistentVolumeClaims(k.Namespace).Get(context.Background(), workspaceClaimName(user), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, ErrNoWorkspace
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get the workspace: %s", err)
+	}
+
+	info := &WorkspaceInfo{
+		Claim:     claim.Name,
+		User:      claim.Labels["user"],
+		Db:        claim.Labels["db"],
+		Phase:     string(claim.Status.Phase),
+		Resetting: claim.DeletionTimestamp != nil,
+		CreatedAt: claim.CreationTimestamp.Time,
+	}
+	if size, ok := claim.Spec.Resources.Requests[modelsv1.ResourceStorage]; ok {
+		info.Size = size.String()
+	}
+	if capacity, ok := claim.Status.Capacity[modelsv1.ResourceStorage]; ok {
+		info.Capacity = capacity.String()
+	}
+
+	return info, nil
+}
+
+// ResetWorkspace stops the session of the given user and deletes its workspace.
+// It blocks until the workspace was removed so that the next session starts with an
+// empty workspace.
+// ErrNoWorkspace is returned if the user has none
+func (k *Kuber) ResetWorkspace(user *models.User) error {
+	if _, err := k.Workspace(user); err != nil {
+		return err
+	}
+
+	// The claim is protected until no pod is using it anymore
+	if err := k.deleteUserPods(user); err != nil {
+		return err
+	}
+
+	name := workspaceClaimName(user)
+	claims := k.Client.CoreV1().PersistentVolumeClaims(k.Namespace)
+	err := claims.Delete(context.Background(), name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return ErrNoWorkspace
+	} else if err != nil {
+		return fmt.Errorf("failed to delete the workspace %q: %s", name, err)
+	}
+
+	// The old workspace would still be mounted by a login in the meantime
+	deadline := time.Now().Add(workspaceResetTimeout)
+	for {
+		_, err := claims.Get(context.Background(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to get the workspace %q: %s", name, err)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the workspace %q was not removed within %s", name, workspaceResetTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	logger.Info("Reset the workspace %q of user %q", name, user.DbUser)
+	return nil
+}
+
+// deleteUserPods deletes all jobs and pods of the given user directly from the API
+// so that none of them is still using the workspace
+func (k *Kuber) deleteUserPods(user *models.User) error {
+	propagation := metav1.DeletePropagationBackground
+	options := metav1.DeleteOptions{PropagationPolicy: &propagation}
+	selector := metav1.ListOptions{LabelSelector: fmt.Sprintf(
+		"app=%s-lfs,db=%s,user=%s,placeholder!=true",
+		utils.GetEnvString("BASE_APP_NAME", "lfsx-web"), strings.ToLower(user.Database.String()), strings.ToLower(user.DbUser),
+	)}
+
+	jobs, err := k.Client.BatchV1().Jobs(k.Namespace).List(context.Background(), selector)
+	if err != nil {
+		return fmt.Errorf("failed to list the jobs of the user: %s", err)
+	}
+	for _, job := range jobs.Items {
+		logger.Info("Deleting job %q of user %q to reset the workspace", job.Name, user.DbUser)
+		err := k.Client.BatchV1().Jobs(k.Namespace).Delete(context.Background(), job.Name, options)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete job %q: %s", job.Name, err)
+		}
+	}
+
+	// The pods are removed in the background by the job deletion. Delete them right away
+	pods, err := k.Client.CoreV1().Pods(k.Namespace).List(context.Background(), selector)
+	if err != nil {
+		return fmt.Errorf("failed to list the pods of the user: %s", err)
+	}
+	for _, pod := range pods.Items {
+		err := k.Client.CoreV1().Pods(k.Namespace).Delete(context.Background(), pod.Name, options)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pod %q: %s", pod.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+// metrics contains the prometheus metrics of the controller and
+// serves them under "/metrics" on a separate address
+package metrics
+
+import (
+	"net/http"
+
+	"gitea.hama.de/LFS/go-logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prefix of all metrics
+const namespace = "lfsx_web"
+
+// Directions of the proxied bytes
+const (
+	DirectionToSession = "to_session"
+	DirectionToClient  = "to_client"
+)
+
+// Results of claiming a pod for a user
+const (
+	ClaimPlaceholder = "placeholder"
+	ClaimNewJob      = "new_job"
+)
+
+var (
+	// ActivePeers is the number of connected clients by protocol and db
+	ActivePeers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_peers",
+		Help:      "Number of clients that are connected to a session",
+	}, []string{"protocol", "db"})
+
+	// ProxiedBytes is the number of bytes proxied between the clients and the sessions
+	ProxiedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxied_bytes_total",
+		Help:      "Number of bytes proxied between the clients and the sessions",
+	}, []string{"protocol", "direction"})
+
+	// ClaimDuration is the time needed to get a pod for a user that had no pod before
+	ClaimDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pod_claim_duration_seconds",
+		Help:      "Time needed to assign a pod to a user by claiming a placeholder or creating a new job",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20},
+	}, []string{"result"})
+
+	// PoolSize is the number of placeholder jobs by image version
+	PoolSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "placeholder_pool_size",
+		Help:      "Number of placeholder jobs that can be claimed by a user",
+	}, []string{"image_version"})
+
+	// GarbageCollected is the number of pods and jobs removed by the garbage collector
+	GarbageCollected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "garbage_collected_total",
+		Help:      "Number of LFS pods and jobs that were removed by the garbage collector",
+	}, []string{"reason"})
+
+	// LfsxReconnects is the number of reconnects to the LFS.X WebSocket
+	LfsxReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lfsx_websocket_reconnects_total",
+		Help:      "Number of reconnects to the LFS.X WebSocket after the connection was lost",
+	})
+
+	// PingFailures is the number of keepalive pings that couldn't be send
+	PingFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "keepalive_ping_failures_total",
+		Help:      "Number of keepalive pings that failed to be send to a WebSocket",
+	})
+
+	// Logins is the number of login requests by the returned status code
+	Logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Number of login requests by the returned HTTP status code",
+	}, []string{"code"})
+)
+
+// Serve serves the metrics under "/metrics" on the given address.
+//
+// This method does block
+func Serve(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	logger.Info("Serving metrics on %q", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		logger.Error("Failed to serve metrics: %s", err)
+	}
+}
+
+// StatusRecorder records the status code written to a response
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+// NewStatusRecorder wraps the given response writer. The status defaults to 200
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *StatusRecorder) WriteHeader(status int) {
+	r.Status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -1,11 +1,17 @@
 package models
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"gitea.hama.de/LFS/go-logger"
 	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // AppConfig contains generic configuration options for the app.
@@ -17,6 +23,9 @@ type AppConfig struct {
 	// Address on which the server should be listening on
 	Address string
 
+	// Address on which the metrics are served. Empty to disable the metrics
+	MetricsAddress string
+
 	// If the application should serve an LFS.X in production mode
 	Production bool
 
@@ -38,10 +47,247 @@ type AppConfig struct {
 	// field "LfsImageName"
 	lfsImageNameFile string
 
+	// Directory with templates of the kubernetes ressources (like "deployment-lfs.yaml")
+	// that override the embedded ones. Empty to only use the embedded templates
+	TemplateDir string
+
+	// The backend that is used to run the LFS.X sessions of the users.
+	// See the constants "SessionBackend*" for possible values
+	SessionBackend string
+
+	// Options for the "process" session backend
+	ProcessBackend ProcessBackendConfig
+
+	// Options for the pool of placeholder jobs
+	Pool PoolConfig
+
+	// Resource profiles of the LFS pods and the rules to assign them to the users
+	Profiles ProfileConfig
+
+	// Options for the rollout of a new image version
+	Rollout RolloutConfig
+
+	// Options for the garbage collection of LFS pods and jobs
+	GC GCConfig
+
+	// Options for the persistent workspaces of the users
+	Workspace WorkspaceConfig
+
+	// Users (login name) that are allowed to watch the sessions of other users
+	SupportUsers []string
+
+	// Groups of users (database and login name) indexed by the group name
+	UserGroups map[string][]GroupMember
+
+	// Options for the server-side recording of sessions
+	Recording RecordingConfig
+
+	// Identity of this controller instance. It's used to record the
+	// ownership of sessions when running multiple replicas
+	Replica Replica
+
+	// Weather the notifications of the administrators are sent to the LFS.X as well.
+	// Otherwise only the browser shows them
+	NotifyLfsx bool
+
 	// Development options
 	DevConfig DevConfig
 }
 
+const (
+	// Runs every session as a job / pod inside kubernetes
+	SessionBackendKubernetes = "kubernetes"
+	// Runs every session as a child process of the controller
+	SessionBackendProcess = "process"
+)
+
+const (
+	// The next login reuses the session of an outdated image version
+	RolloutPolicyReuse = "reuse"
+	// The next login replaces the session of an outdated image version with a fresh one
+	RolloutPolicyReplace = "replace"
+)
+
+// RolloutConfig contains options for sessions that are running an
+// outdated image version after a new version was rolled out
+type RolloutConfig struct {
+
+	// Decides what happens with an outdated session on the next login.
+	// See the constants "RolloutPolicy*" for possible values
+	Policy string
+}
+
+// GCConfig contains options for the garbage collector that removes
+// stale, orphaned and stuck LFS pods and jobs
+type GCConfig struct {
+
+	// Interval in which the garbage collector runs
+	Interval time.Duration
+
+	// Pods that are pending (e.g. ImagePullBackOff) for longer are removed
+	StuckAfter time.Duration
+
+	// Sessions without a connected client for longer are removed
+	OrphanedAfter time.Duration
+
+	// Only report the pods and jobs that would be removed
+	DryRun bool
+}
+
+// WorkspaceConfig contains options for the persistent volume claim that is
+// created for every user and mounted into the LFS pods
+type WorkspaceConfig struct {
+
+	// Weather the workspace of the users is persisted. Otherwise every
+	// session starts with an empty workspace
+	Enabled bool
+
+	// Requested size of the volume (e.g. "2Gi")
+	Size resource.Quantity
+
+	// Storage class of the volume. Empty to use the default storage class
+	StorageClass string
+}
+
+// ProcessBackendConfig contains options to run the LFS.X sessions
+// as local child processes instead of kubernetes pods
+type ProcessBackendConfig struct {
+
+	// Command of the host agent (lfsx-web-lfs) that is started for every session
+	Command string
+
+	// Directory in which an own data directory is created for every user
+	DataDir string
+}
+
+// Name of the group whose users are allowed to use the admin endpoints
+const GroupAdmin = "admin"
+
+// RecordingConfig contains options for the server-side recording
+// of guacamole sessions
+type RecordingConfig struct {
+
+	// Directory (mounted volume) in which the controller reads the recordings.
+	// An empty path disables the recording
+	Path string
+
+	// Directory inside the session (pod) to which guacd writes the recordings
+	SessionPath string
+
+	// Name of the persistent volume claim that is mounted into the pods. Every pod
+	// only mounts its own directory of the volume
+	Claim string
+
+	// Recordings older than this number of days are deleted.
+	// Zero or a negative number keeps them forever
+	RetentionDays int
+
+	// A session is recorded if one of the policies matches the user
+	Policies []RecordingPolicy
+}
+
+// RecordingPolicy enables the recording for all users of a db and group.
+// The value "*" matches everything
+type RecordingPolicy struct {
+	Db    string
+	Group string
+}
+
+// Replica describes a single controller instance
+type Replica struct {
+
+	// Unique name of the replica (like the pod name)
+	Name string
+
+	// Address (host:port) under which the API of the replica is reachable
+	// from the other replicas. If empty, no requests are forwarded to this replica
+	Address string
+}
+
+// PoolConfig contains options for the pool of warm placeholder
+// jobs that can be claimed by a user
+type PoolConfig struct {
+
+	// Weather placeholders are kept and claimed on login. It has to be
+	// disabled for persisted workspaces because a volume can't be mounted
+	// into the running pod of a placeholder
+	Enabled bool
+
+	// Minimum number of idle placeholders that should always be available
+	MinIdle int
+
+	// Maximum number of idle placeholders. Surplus placeholders are removed.
+	// A schedule entry with more placeholders does override this limit
+	MaxIdle int
+
+	// Optional time of day based number of idle placeholders sorted by the start time
+	Schedule []PoolSchedule
+}
+
+// PoolSchedule changes the number of idle placeholders from a
+// specific time of day on
+type PoolSchedule struct {
+
+	// Minutes since midnight (local time) from which on this entry is active
+	Start int
+
+	// The number of idle placeholders
+	Idle int
+}
+
+// DesiredIdle returns the number of idle placeholders that should be available
+// at the given time
+func (c PoolConfig) DesiredIdle(now time.Time) int {
+	if len(c.Schedule) == 0 {
+		return c.MinIdle
+	}
+
+	// The last entry of the previous day is active until the first entry of this day starts
+	minutes := now.Hour()*60 + now.Minute()
+	active := c.Schedule[len(c.Schedule)-1]
+	for _, s := range c.Schedule {
+		if s.Start <= minutes {
+			active = s
+		}
+	}
+
+	if active.Idle < c.MinIdle {
+		return c.MinIdle
+	}
+	return active.Idle
+}
+
+// parsePoolSchedule parses a schedule in the format "07:30=15,17:00=2"
+func parsePoolSchedule(val string) ([]PoolSchedule, error) {
+	rtc := make([]PoolSchedule, 0)
+	if strings.TrimSpace(val) == "" {
+		return rtc, nil
+	}
+
+	for _, entry := range strings.Split(val, ",") {
+		start, idle, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			return nil, fmt.Errorf("missing '=' in entry %q", entry)
+		}
+
+		t, err := time.Parse("15:04", start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time in entry %q: %s", entry, err)
+		}
+		i, err := strconv.Atoi(idle)
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("invalid number of placeholders in entry %q", entry)
+		}
+
+		rtc = append(rtc, PoolSchedule{Start: t.Hour()*60 + t.Minute(), Idle: i})
+	}
+
+	sort.Slice(rtc, func(a, b int) bool {
+		return rtc[a].Start < rtc[b].Start
+	})
+	return rtc, nil
+}
+
 // The development config contains some options that are only needed
 // during the development of this app
 type DevConfig struct {
@@ -85,6 +331,7 @@ func GetAppConfig(version string) *AppConfig {
 
 	// Get the address to listen on
 	config.Address = utils.GetEnvString("APP_ADDRESS", ":4020")
+	config.MetricsAddress = utils.GetEnvString("APP_METRICS_ADDRESS", ":4030")
 
 	// Some other configuration
 	config.Production = utils.GetEnvBool("APP_PRODUCTION", true)
@@ -92,6 +339,81 @@ func GetAppConfig(version string) *AppConfig {
 	config.LfsJwtName = utils.GetEnvString("APP_LFS_SERVICE_ENDPOINT_JWT_NAME", "JWTAuthentication")
 	config.lfsImageName = utils.GetEnvString("APP_LFS_IMAGE_NAME", utils.GetEnvString("APP_LFS_IMAGE_REGISTRY", "containers-next.hama.de/registry-hama-test/lfsx-web-lfs")+":"+version)
 	config.lfsImageNameFile = utils.GetEnvString("APP_LFS_IMAGE_NAME_FILE", "")
+	config.TemplateDir = utils.GetEnvString("APP_TEMPLATE_DIR", "")
+
+	// Get session backend configs
+	config.SessionBackend = strings.ToLower(utils.GetEnvString("APP_SESSION_BACKEND", SessionBackendKubernetes))
+	if config.SessionBackend != SessionBackendKubernetes && config.SessionBackend != SessionBackendProcess {
+		logger.Fatal("Invalid session backend %q given. Expected %q or %q", config.SessionBackend, SessionBackendKubernetes, SessionBackendProcess)
+	}
+	config.ProcessBackend.Command = utils.GetEnvString("APP_SESSION_PROCESS_COMMAND", "lfsx-web-lfs")
+	config.ProcessBackend.DataDir = utils.GetEnvString("APP_SESSION_PROCESS_DATA", filepath.Join(os.TempDir(), "lfsx-web"))
+
+	// Get placeholder pool configs
+	config.Pool.Enabled = utils.GetEnvBool("APP_POOL_ENABLED", true)
+	config.Pool.MinIdle = utils.GetEnvInt("APP_POOL_MIN_IDLE", 1)
+	config.Pool.MaxIdle = utils.GetEnvInt("APP_POOL_MAX_IDLE", 3)
+	config.Pool.Schedule, err = parsePoolSchedule(utils.GetEnvString("APP_POOL_SCHEDULE", ""))
+	if err != nil {
+		logger.Fatal("Invalid schedule for the placeholder pool given: %s", err)
+	}
+
+	// Get the resource profiles
+	profilesFile := utils.GetEnvString("APP_RESOURCE_PROFILES_FILE", "")
+	config.Profiles, err = readProfileConfig(profilesFile)
+	if err != nil {
+		logger.Fatal("Invalid resource profiles given in %q: %s", profilesFile, err)
+	}
+
+	// Get rollout configs
+	config.Rollout.Policy = strings.ToLower(utils.GetEnvString("APP_ROLLOUT_POLICY", RolloutPolicyReuse))
+	if config.Rollout.Policy != RolloutPolicyReuse && config.Rollout.Policy != RolloutPolicyReplace {
+		logger.Fatal("Invalid rollout policy %q given. Expected %q or %q", config.Rollout.Policy, RolloutPolicyReuse, RolloutPolicyReplace)
+	}
+
+	// Get garbage collector configs
+	config.GC.Interval = time.Duration(utils.GetEnvInt("APP_GC_INTERVAL_SECONDS", 60)) * time.Second
+	config.GC.StuckAfter = time.Duration(utils.GetEnvInt("APP_GC_STUCK_MINUTES", 15)) * time.Minute
+	config.GC.OrphanedAfter = time.Duration(utils.GetEnvInt("APP_GC_ORPHANED_MINUTES", 120)) * time.Minute
+	config.GC.DryRun = utils.GetEnvBool("APP_GC_DRY_RUN", false)
+
+	// Get the workspace configs
+	config.Workspace.Enabled = utils.GetEnvBool("APP_WORKSPACE_ENABLED", false)
+	size := utils.GetEnvString("APP_WORKSPACE_SIZE", "2Gi")
+	if config.Workspace.Size, err = resource.ParseQuantity(size); err != nil {
+		logger.Fatal("Invalid workspace size %q given: %s", size, err)
+	}
+	config.Workspace.StorageClass = utils.GetEnvString("APP_WORKSPACE_STORAGE_CLASS", "")
+	if config.Workspace.Enabled && config.Pool.Enabled {
+		logger.Fatal("The placeholder pool can't be used with persisted workspaces. Set APP_POOL_ENABLED=false")
+	}
+
+	// Get the users of the support team
+	config.SupportUsers = splitList(utils.GetEnvString("APP_SUPPORT_USERS", ""))
+
+	// Get the user groups
+	config.UserGroups, err = parseUserGroups(utils.GetEnvString("APP_USER_GROUPS", ""))
+	if err != nil {
+		logger.Fatal("Invalid user groups given: %s", err)
+	}
+
+	// Get recording configs
+	config.Recording.Path = utils.GetEnvString("APP_RECORDING_PATH", "")
+	config.Recording.SessionPath = utils.GetEnvString("APP_RECORDING_SESSION_PATH", config.Recording.Path)
+	config.Recording.Claim = utils.GetEnvString("APP_RECORDING_CLAIM", "")
+	config.Recording.RetentionDays = utils.GetEnvInt("APP_RECORDING_RETENTION_DAYS", 30)
+	config.Recording.Policies, err = parseRecordingPolicies(utils.GetEnvString("APP_RECORDING_POLICY", ""))
+	if err != nil {
+		logger.Fatal("Invalid recording policy given: %s", err)
+	}
+
+	// Get the identity of this replica
+	hostname, _ := os.Hostname()
+	config.Replica.Name = utils.GetEnvString("APP_REPLICA_NAME", hostname)
+	config.Replica.Address = utils.GetEnvString("APP_REPLICA_ADDRESS", "")
+
+	// Get notification configs
+	config.NotifyLfsx = utils.GetEnvBool("APP_NOTIFY_LFSX", false)
 
 	// Get development configs
 	config.DevConfig.DevServer = utils.GetEnvBool("APP_DEV_USE_DEVSERVER", false)
@@ -134,6 +456,115 @@ func (c *AppConfig) GetLfsImageVersion() string {
 	return cutOff(image, maxLength)
 }
 
+// IsSupporter returns weather the given user is allowed to watch
+// the sessions of other users
+func (c *AppConfig) IsSupporter(user *User) bool {
+	for _, u := range c.SupportUsers {
+		if strings.EqualFold(u, user.DbUser) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsInGroup returns weather the given user is a member of the group.
+// The database and the login name of the user have to match
+func (c *AppConfig) IsInGroup(user *User, group string) bool {
+	for _, m := range c.UserGroups[group] {
+		if m.Database == user.Database && strings.EqualFold(m.User, user.DbUser) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ShouldRecord returns weather the session of the given user should be recorded
+func (c *AppConfig) ShouldRecord(user *User) bool {
+	if c.Recording.Path == "" {
+		return false
+	}
+
+	for _, p := range c.Recording.Policies {
+		dbMatches := p.Db == "*" || strings.EqualFold(p.Db, user.DatabaseStr) || strings.EqualFold(p.Db, user.Database.String())
+		groupMatches := p.Group == "*" || c.IsInGroup(user, p.Group)
+
+		if dbMatches && groupMatches {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GroupMember is a single user of a group. The login names are only
+// unique within a database
+type GroupMember struct {
+	Database Database
+	User     string
+}
+
+// parseUserGroups parses user groups in the format "admin=lfs/alice|lfsmig/bob,support=prj/carl"
+func parseUserGroups(val string) (map[string][]GroupMember, error) {
+	rtc := make(map[string][]GroupMember)
+
+	for _, entry := range splitList(val) {
+		group, users, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("missing '=' in entry %q", entry)
+		}
+		group = strings.TrimSpace(group)
+
+		for _, u := range strings.Split(users, "|") {
+			if u = strings.TrimSpace(u); u == "" {
+				continue
+			}
+
+			db, user, found := strings.Cut(u, "/")
+			if !found || strings.TrimSpace(user) == "" {
+				return nil, fmt.Errorf("user %q of group %q has to be given as \"db/user\"", u, group)
+			}
+			database, ok := ParseDatabase(strings.TrimSpace(db))
+			if !ok {
+				return nil, fmt.Errorf("unknown database %q of user %q in group %q", db, u, group)
+			}
+
+			rtc[group] = append(rtc[group], GroupMember{Database: database, User: strings.TrimSpace(user)})
+		}
+	}
+
+	return rtc, nil
+}
+
+// parseRecordingPolicies parses recording policies in the format "lfs:*,lfsmig:support"
+func parseRecordingPolicies(val string) ([]RecordingPolicy, error) {
+	rtc := make([]RecordingPolicy, 0)
+
+	for _, entry := range splitList(val) {
+		db, group, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, fmt.Errorf("missing ':' in entry %q", entry)
+		}
+
+		rtc = append(rtc, RecordingPolicy{Db: strings.TrimSpace(db), Group: strings.TrimSpace(group)})
+	}
+
+	return rtc, nil
+}
+
+// splitList splits a comma separated list and removes empty entries
+func splitList(val string) []string {
+	rtc := make([]string, 0)
+	for _, entry := range strings.Split(val, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			rtc = append(rtc, entry)
+		}
+	}
+
+	return rtc
+}
+
 // cutOff returns a string that is cut off after the given amount of characters
 func cutOff(val string, length int) string {
 	if len(val) > length {
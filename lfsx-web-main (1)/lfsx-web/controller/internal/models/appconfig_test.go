@@ -0,0 +1,84 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUserGroups(t *testing.T) {
+	tests := []struct {
+		name      string
+		val       string
+		expect    map[string][]GroupMember
+		expectErr bool
+	}{
+		{"empty", "", map[string][]GroupMember{}, false},
+		{
+			name:   "single group",
+			val:    "admin=lfs/alice",
+			expect: map[string][]GroupMember{"admin": {{Database: LFS, User: "alice"}}},
+		},
+		{
+			name: "multiple groups",
+			val:  "admin=lfs/alice|lfsmig/bob,controlling=prj/carl",
+			expect: map[string][]GroupMember{
+				"admin":       {{Database: LFS, User: "alice"}, {Database: MIG, User: "bob"}},
+				"controlling": {{Database: PRJ, User: "carl"}},
+			},
+		},
+		{
+			name:   "whitespace and empty members",
+			val:    " admin = LFS / alice || mig/bob ",
+			expect: map[string][]GroupMember{"admin": {{Database: LFS, User: "alice"}, {Database: MIG, User: "bob"}}},
+		},
+		{
+			name:   "group defined twice",
+			val:    "admin=lfs/alice,admin=lfs/bob",
+			expect: map[string][]GroupMember{"admin": {{Database: LFS, User: "alice"}, {Database: LFS, User: "bob"}}},
+		},
+		{"missing '='", "admin", nil, true},
+		{"missing database", "admin=alice", nil, true},
+		{"missing user", "admin=lfs/", nil, true},
+		{"unknown database", "admin=other/alice", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			groups, err := parseUserGroups(test.val)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %t, got %v", test.expectErr, err)
+			}
+			if !test.expectErr && !reflect.DeepEqual(groups, test.expect) {
+				t.Errorf("expected %v, got %v", test.expect, groups)
+			}
+		})
+	}
+}
+
+func TestIsInGroup(t *testing.T) {
+	config := AppConfig{UserGroups: map[string][]GroupMember{
+		GroupAdmin: {{Database: LFS, User: "alice"}, {Database: MIG, User: "bob"}},
+	}}
+
+	tests := []struct {
+		name   string
+		user   User
+		group  string
+		expect bool
+	}{
+		{"member", User{DbUser: "alice", Database: LFS}, GroupAdmin, true},
+		{"member case insensitive", User{DbUser: "ALICE", Database: LFS}, GroupAdmin, true},
+		{"member of another database", User{DbUser: "alice", Database: MIG}, GroupAdmin, false},
+		{"second member", User{DbUser: "bob", Database: MIG}, GroupAdmin, true},
+		{"no member", User{DbUser: "carl", Database: LFS}, GroupAdmin, false},
+		{"unknown group", User{DbUser: "alice", Database: LFS}, "support", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rtc := config.IsInGroup(&test.user, test.group); rtc != test.expect {
+				t.Errorf("expected %t, got %t", test.expect, rtc)
+			}
+		})
+	}
+}
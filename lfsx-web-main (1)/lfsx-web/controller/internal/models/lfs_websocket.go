@@ -2,7 +2,11 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
 	"math/rand"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"gitea.hama.de/LFS/go-logger"
@@ -25,9 +29,10 @@ type WebSocketData struct {
 
 func NewWebSocketData(responseTo int, messages ...WebSocketMessage) WebSocketData {
 
-	// Generate Random ID
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	id := r.Intn(1048576-0) + 0
+	// Generate Random ID. The shared global source is safe for concurrent use and
+	// randomly seeded, so messages created at the same time get different IDs.
+	// 0 is reserved for messages that don't respond to another message
+	id := rand.Intn(1048576-1) + 1
 
 	return WebSocketData{
 		ID:         id,
@@ -50,13 +55,28 @@ func (d WebSocketData) ToJson() []byte {
 // and received via the WebSocket.
 // Note that this struct should only ever contain a single  "sub" struct that
 // should have the name as given in Type.
+//
+// Every type is registered with its payload, direction and validator (see the
+// "...Type" variables). Messages and fields unknown to the controller are kept
+// when a received message is encoded again
 type WebSocketMessage struct {
 
 	// Required Field that should contain the unique type of the send message
 	Type string `json:"type"`
 
 	// Choose on of the following objects
-	LoginRequest *LoginRequest `json:"loginRequest,omitempty"`
+	LoginRequest      *LoginRequest      `json:"loginRequest,omitempty"`
+	OpenInBrowser     *OpenInBrowser     `json:"openInBrowser,omitempty"`
+	FileUploadRequest *FileUploadRequest `json:"fileUploadRequest,omitempty"`
+	ShadowSession     *ShadowSession     `json:"shadowSession,omitempty"`
+	SessionStats      *SessionStats      `json:"sessionStats,omitempty"`
+	Clipboard         *Clipboard         `json:"clipboard,omitempty"`
+	FileDownloadReady *FileDownloadReady `json:"fileDownloadReady,omitempty"`
+	Notification      *Notification      `json:"notification,omitempty"`
+	SessionOutdated   *SessionOutdated   `json:"sessionOutdated,omitempty"`
+
+	// All fields of a received message
+	raw map[string]json.RawMessage
 }
 
 // LoginRequest is send from the Kubernetes controller to automatically login to the LFS
@@ -69,13 +89,254 @@ type LoginRequest struct {
 
 const LoginRequestKey = "LoginRequest"
 
+var LoginRequestType = RegisterMessageType(LoginRequestKey, FromController,
+	func(m *WebSocketMessage) **LoginRequest { return &m.LoginRequest }, nil,
+)
+
 func NewLoginRequest(username string, password string, db string) WebSocketMessage {
-	return WebSocketMessage{
-		Type: LoginRequestKey,
-		LoginRequest: &LoginRequest{
-			Username: username,
-			Password: password,
-			Db:       db,
-		},
+	return LoginRequestType.New(LoginRequest{
+		Username: username,
+		Password: password,
+		Db:       db,
+	})
+}
+
+// LfsStartup is send from the LFS.X when it's booted up and ready for the login
+const LfsStartupKey = "LfsStartup"
+
+var LfsStartupType = RegisterMessageType[NoPayload](LfsStartupKey, FromLfsx, nil, nil)
+
+// Stop is send from the LFS.X when the user closed it. The client logs out
+const StopKey = "Stop"
+
+var StopType = RegisterMessageType[NoPayload](StopKey, FromLfsx, nil, nil)
+
+// OpenInBrowser is send from the LFS.X to open the given URL in a new tab
+// of the browser
+type OpenInBrowser struct {
+	URL string `json:"url"`
+}
+
+const OpenInBrowserKey = "OpenInBrowser"
+
+var OpenInBrowserType = RegisterMessageType(OpenInBrowserKey, FromLfsx,
+	func(m *WebSocketMessage) **OpenInBrowser { return &m.OpenInBrowser },
+	func(o *OpenInBrowser) error {
+		// Don't allow to execute scripts inside the web app (javascript:)
+		u, err := url.Parse(o.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid URL %q", o.URL)
+		}
+		return nil
+	},
+)
+
+// FileUploadRequest is send from the LFS.X to the client when the user has to
+// upload files. The client answers with a "FileUploadFinished" message
+type FileUploadRequest struct {
+	// The file types that are accepted. A comma separated list of file extensions
+	// and mime types like the "accept" attribute of a file input (e.g. ".doc,.docx,application/msword")
+	Accept string `json:"accept"`
+}
+
+const FileUploadRequestKey = "FileUploadRequest"
+
+var FileUploadRequestType = RegisterMessageType(FileUploadRequestKey, FromLfsx,
+	func(m *WebSocketMessage) **FileUploadRequest { return &m.FileUploadRequest }, nil,
+)
+
+// FileUploadFinished is send from the client as the response to a "FileUploadRequest"
+// when the user finished to upload files
+const FileUploadFinishedKey = "FileUploadFinished"
+
+var FileUploadFinishedType = RegisterMessageType[NoPayload](FileUploadFinishedKey, FromClient, nil, nil)
+
+// ShadowSession is send from the controller to the client when a supporter
+// starts or stops watching the session of the user
+type ShadowSession struct {
+	Supporter string `json:"supporter"`
+	Active    bool   `json:"active"`
+}
+
+const ShadowSessionKey = "ShadowSession"
+
+var ShadowSessionType = RegisterMessageType(ShadowSessionKey, FromController,
+	func(m *WebSocketMessage) **ShadowSession { return &m.ShadowSession }, nil,
+)
+
+func NewShadowSession(supporter string, active bool) WebSocketMessage {
+	return ShadowSessionType.New(ShadowSession{
+		Supporter: supporter,
+		Active:    active,
+	})
+}
+
+// SessionStats is send periodically from the controller to the client and
+// contains the traffic of the session. "In" is the traffic from the client to
+// the session and "Out" the traffic from the session to the client.
+//
+// The rates are calculated over the last seconds
+type SessionStats struct {
+	BytesIn     uint64 `json:"bytesIn"`
+	BytesOut    uint64 `json:"bytesOut"`
+	MessagesIn  uint64 `json:"messagesIn"`
+	MessagesOut uint64 `json:"messagesOut"`
+
+	// Number of frames ("sync" instructions) send by guacd
+	Frames uint64 `json:"frames"`
+
+	BytesInPerSecond  float64 `json:"bytesInPerSecond"`
+	BytesOutPerSecond float64 `json:"bytesOutPerSecond"`
+	FramesPerSecond   float64 `json:"framesPerSecond"`
+}
+
+const SessionStatsKey = "SessionStats"
+
+var SessionStatsType = RegisterMessageType(SessionStatsKey, FromController,
+	func(m *WebSocketMessage) **SessionStats { return &m.SessionStats }, nil,
+)
+
+func NewSessionStats(stats SessionStats) WebSocketMessage {
+	return SessionStatsType.New(stats)
+}
+
+// Clipboard is send between the client and the controller when the clipboard
+// of the browser or of the session changes. It's only used by noVNC clients,
+// guacamole clients are using clipboard streams instead
+type Clipboard struct {
+	Text string `json:"text"`
+}
+
+const ClipboardKey = "Clipboard"
+
+// Maximum size of the clipboard in bytes
+const MaxClipboardSize = 1 << 20
+
+var ClipboardType = RegisterMessageType(ClipboardKey, FromClient|FromController,
+	func(m *WebSocketMessage) **Clipboard { return &m.Clipboard },
+	func(c *Clipboard) error {
+		if len(c.Text) > MaxClipboardSize {
+			return fmt.Errorf("clipboard exceeds %d bytes", MaxClipboardSize)
+		}
+		return nil
+	},
+)
+
+func NewClipboard(text string) WebSocketMessage {
+	return ClipboardType.New(Clipboard{Text: text})
+}
+
+// FileDownloadReady is send from the controller to the client when the LFS.X
+// exported a file into the outbox directory of the session
+type FileDownloadReady struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+
+	// Path of the controller API the file can be downloaded from
+	URL string `json:"url"`
+}
+
+const FileDownloadReadyKey = "FileDownloadReady"
+
+var FileDownloadReadyType = RegisterMessageType(FileDownloadReadyKey, FromController,
+	func(m *WebSocketMessage) **FileDownloadReady { return &m.FileDownloadReady }, nil,
+)
+
+func NewFileDownloadReady(file FileDownloadReady) WebSocketMessage {
+	return FileDownloadReadyType.New(file)
+}
+
+// Notification is send from the controller to the client (and optionally to the LFS.X)
+// when an administrator broadcasts a message to all users, e.g. before a maintenance
+type Notification struct {
+	ID       string `json:"id"`
+	Text     string `json:"text"`
+	Severity string `json:"severity"`
+
+	// Optional time the client counts down to (e.g. the start of the maintenance)
+	CountdownTo *time.Time `json:"countdownTo,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Users connecting before this time do still receive the notification
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Severities of a notification
+const (
+	NotificationInfo     = "info"
+	NotificationWarning  = "warning"
+	NotificationCritical = "critical"
+)
+
+// Maximum length of the text of a notification
+const MaxNotificationLength = 1000
+
+const NotificationKey = "Notification"
+
+var NotificationType = RegisterMessageType(NotificationKey, FromController,
+	func(m *WebSocketMessage) **Notification { return &m.Notification },
+	func(n *Notification) error { return n.Validate() },
+)
+
+func NewNotification(notification Notification) WebSocketMessage {
+	return NotificationType.New(notification)
+}
+
+// Validate checks that the notification can be shown to the users
+func (n *Notification) Validate() error {
+	if strings.TrimSpace(n.Text) == "" {
+		return fmt.Errorf("the notification has no text")
+	}
+	if len(n.Text) > MaxNotificationLength {
+		return fmt.Errorf("the text of the notification exceeds %d characters", MaxNotificationLength)
+	}
+	if n.Severity != NotificationInfo && n.Severity != NotificationWarning && n.Severity != NotificationCritical {
+		return fmt.Errorf("invalid severity %q", n.Severity)
+	}
+
+	return nil
+}
+
+// IsActive returns weather the notification is still shown to users that connect at the given time
+func (n *Notification) IsActive(now time.Time) bool {
+	return now.Before(n.ExpiresAt)
+}
+
+// ActiveNotifications returns the notifications that are still active at the given
+// time sorted by their creation time
+func ActiveNotifications(notifications []Notification, now time.Time) []Notification {
+	rtc := make([]Notification, 0, len(notifications))
+	for _, n := range notifications {
+		if n.IsActive(now) {
+			rtc = append(rtc, n)
+		}
 	}
+
+	sort.Slice(rtc, func(a, b int) bool {
+		return rtc[a].CreatedAt.Before(rtc[b].CreatedAt)
+	})
+	return rtc
+}
+
+// SessionOutdated is send from the controller to the client when the session of the
+// user runs an older image version than the current one. The user should restart the
+// LFS.X when it's convenient
+type SessionOutdated struct {
+	ImageVersion   string `json:"imageVersion"`
+	CurrentVersion string `json:"currentVersion"`
+}
+
+const SessionOutdatedKey = "SessionOutdated"
+
+var SessionOutdatedType = RegisterMessageType(SessionOutdatedKey, FromController,
+	func(m *WebSocketMessage) **SessionOutdated { return &m.SessionOutdated }, nil,
+)
+
+func NewSessionOutdated(imageVersion string, currentVersion string) WebSocketMessage {
+	return SessionOutdatedType.New(SessionOutdated{
+		ImageVersion:   imageVersion,
+		CurrentVersion: currentVersion,
+	})
 }
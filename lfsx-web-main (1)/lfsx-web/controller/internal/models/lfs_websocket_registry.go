@@ -0,0 +1,235 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Direction describes who is allowed to send a WebSocket message. Multiple
+// directions can be combined
+type Direction int
+
+const (
+	// Send by the LFS.X to the client
+	FromLfsx Direction = 1 << iota
+	// Send by the client (browser) to the LFS.X
+	FromClient
+	// Send by the controller to the client or the LFS.X
+	FromController
+)
+
+func (d Direction) String() string {
+	var names []string
+	if d&FromLfsx != 0 {
+		names = append(names, "LFS.X")
+	}
+	if d&FromClient != 0 {
+		names = append(names, "client")
+	}
+	if d&FromController != 0 {
+		names = append(names, "controller")
+	}
+	return strings.Join(names, "|")
+}
+
+// NoPayload is the payload of messages that don't contain any data
+type NoPayload struct{}
+
+// MessageDefinition describes a registered message type independent of its payload
+type MessageDefinition struct {
+	// Unique type of the message as given in WebSocketMessage.Type
+	Key string
+
+	// Who is allowed to send the message
+	Direction Direction
+
+	// Validates a received message. The presence of the payload is already checked
+	validate func(msg WebSocketMessage) error
+
+	// Weather the message has to contain a payload
+	hasPayload func(msg WebSocketMessage) bool
+}
+
+// Validate checks that the given message, received from the given direction,
+// is a valid message of this type
+func (d MessageDefinition) Validate(msg WebSocketMessage, from Direction) error {
+	if d.Direction&from == 0 {
+		return fmt.Errorf("message %q may only be sent by the %s", d.Key, d.Direction)
+	}
+	if d.hasPayload != nil && !d.hasPayload(msg) {
+		return fmt.Errorf("message %q has no payload", d.Key)
+	}
+	if d.validate != nil {
+		return d.validate(msg)
+	}
+
+	return nil
+}
+
+// MessageType is a registered message type with the payload T
+type MessageType[T any] struct {
+	MessageDefinition
+
+	// Returns the field of the payload inside the message. Nil for messages without payload
+	field func(msg *WebSocketMessage) **T
+}
+
+// Is returns weather the given message is from this type
+func (t MessageType[T]) Is(msg WebSocketMessage) bool {
+	return msg.Type == t.Key
+}
+
+// Payload returns the payload of the given message. False is returned if the
+// message is not from this type or doesn't contain a payload
+func (t MessageType[T]) Payload(msg WebSocketMessage) (*T, bool) {
+	if !t.Is(msg) {
+		return nil, false
+	}
+	if t.field == nil {
+		return new(T), true
+	}
+
+	payload := *t.field(&msg)
+	return payload, payload != nil
+}
+
+// New creates a new message of this type with the given payload
+func (t MessageType[T]) New(payload T) WebSocketMessage {
+	msg := WebSocketMessage{Type: t.Key}
+	if t.field != nil {
+		*t.field(&msg) = &payload
+	}
+
+	return msg
+}
+
+var (
+	registry     = make(map[string]MessageDefinition)
+	registryLock sync.RWMutex
+)
+
+// RegisterMessageType registers a new message type with a payload that is stored within the
+// given field of the message. The validator is optional.
+//
+// It panics if the type was already registered
+func RegisterMessageType[T any](key string, direction Direction, field func(msg *WebSocketMessage) **T, validate func(payload *T) error) MessageType[T] {
+	t := MessageType[T]{
+		MessageDefinition: MessageDefinition{Key: key, Direction: direction},
+		field:             field,
+	}
+	if field != nil {
+		t.hasPayload = func(msg WebSocketMessage) bool {
+			return *field(&msg) != nil
+		}
+	}
+	if validate != nil {
+		t.validate = func(msg WebSocketMessage) error {
+			payload, _ := t.Payload(msg)
+			return validate(payload)
+		}
+	}
+
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, exists := registry[key]; exists {
+		panic(fmt.Sprintf("message type %q is already registered", key))
+	}
+	registry[key] = t.MessageDefinition
+
+	return t
+}
+
+// LookupMessageType returns the definition of the registered message type with the given key
+func LookupMessageType(key string) (MessageDefinition, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	def, ok := registry[key]
+	return def, ok
+}
+
+// ValidateMessage validates the given message that was received from the given direction.
+// Messages of unknown types are always valid so that they are passed through
+func ValidateMessage(msg WebSocketMessage, from Direction) error {
+	if msg.Type == "" {
+		return fmt.Errorf("message has no type")
+	}
+
+	def, ok := LookupMessageType(msg.Type)
+	if !ok {
+		return nil
+	}
+
+	return def.Validate(msg, from)
+}
+
+// webSocketMessage is used to (un)marshal the known fields of a
+// WebSocketMessage without its own JSON methods
+type webSocketMessage WebSocketMessage
+
+// The types of the JSON fields of a WebSocketMessage by their name
+var messageFields = func() map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+
+	t := reflect.TypeOf(webSocketMessage{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = t.Field(i).Type
+		}
+	}
+
+	return fields
+}()
+
+// UnmarshalJSON decodes the message and keeps the received JSON so that
+// fields unknown to the controller are not lost when encoding it again
+func (m *WebSocketMessage) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*webSocketMessage)(m)); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &m.raw)
+}
+
+// MarshalJSON encodes the message. Fields that were received but are unknown to the
+// controller are encoded as received. The same applies to payloads that weren't changed
+func (m WebSocketMessage) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(webSocketMessage(m))
+	if err != nil || len(m.raw) == 0 {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	for name, raw := range m.raw {
+		fieldType, isKnown := messageFields[name]
+		current, isSet := fields[name]
+
+		if !isKnown || (isSet && isUnchanged(fieldType, raw, current)) {
+			fields[name] = raw
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+// isUnchanged returns weather the received JSON of a field decodes to
+// the same value as the current value of the field
+func isUnchanged(fieldType reflect.Type, received json.RawMessage, current json.RawMessage) bool {
+	value := reflect.New(fieldType)
+	if err := json.Unmarshal(received, value.Interface()); err != nil {
+		return false
+	}
+
+	decoded, err := json.Marshal(value.Elem().Interface())
+	return err == nil && bytes.Equal(decoded, current)
+}
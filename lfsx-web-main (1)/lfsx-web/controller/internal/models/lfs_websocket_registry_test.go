@@ -0,0 +1,129 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestWebSocketMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"unknown type", `{"customMessage":{"z":1,"a":[1,2,3],"nested":{"b":null}},"type":"CustomMessage"}`},
+		{"unknown field of a known type", `{"seq":7,"type":"Stop"}`},
+		{"unknown field inside a payload", `{"openInBrowser":{"target":"_blank","url":"https://example.com"},"type":"OpenInBrowser"}`},
+		{"unchanged payload with a different order", `{"shadowSession":{"active":true,"supporter":"alice"},"type":"ShadowSession"}`},
+		{"number formats", `{"sessionStats":{"bytesInPerSecond":1e3,"bytesOutPerSecond":2.50},"type":"SessionStats"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var msg WebSocketMessage
+			if err := json.Unmarshal([]byte(test.data), &msg); err != nil {
+				t.Fatalf("failed to unmarshal: %s", err)
+			}
+
+			data, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatalf("failed to marshal: %s", err)
+			}
+			if string(data) != test.data {
+				t.Errorf("expected %s, got %s", test.data, data)
+			}
+		})
+	}
+}
+
+func TestWebSocketMessageModified(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		modify func(msg *WebSocketMessage)
+		expect string
+	}{
+		{
+			name:   "changed payload",
+			data:   `{"openInBrowser":{"target":"_blank","url":"https://example.com"},"type":"OpenInBrowser"}`,
+			modify: func(msg *WebSocketMessage) { msg.OpenInBrowser.URL = "https://example.org" },
+			expect: `{"openInBrowser":{"url":"https://example.org"},"type":"OpenInBrowser"}`,
+		},
+		{
+			name:   "removed payload",
+			data:   `{"openInBrowser":{"url":"https://example.com"},"seq":7,"type":"OpenInBrowser"}`,
+			modify: func(msg *WebSocketMessage) { msg.OpenInBrowser = nil },
+			expect: `{"seq":7,"type":"OpenInBrowser"}`,
+		},
+		{
+			name:   "added payload",
+			data:   `{"seq":7,"type":"Clipboard"}`,
+			modify: func(msg *WebSocketMessage) { msg.Clipboard = &Clipboard{Text: "text"} },
+			expect: `{"clipboard":{"text":"text"},"seq":7,"type":"Clipboard"}`,
+		},
+		{
+			name:   "changed type",
+			data:   `{"customMessage":{"a":1},"type":"CustomMessage"}`,
+			modify: func(msg *WebSocketMessage) { msg.Type = "Other" },
+			expect: `{"customMessage":{"a":1},"type":"Other"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var msg WebSocketMessage
+			if err := json.Unmarshal([]byte(test.data), &msg); err != nil {
+				t.Fatalf("failed to unmarshal: %s", err)
+			}
+			test.modify(&msg)
+
+			data, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatalf("failed to marshal: %s", err)
+			}
+			if string(data) != test.expect {
+				t.Errorf("expected %s, got %s", test.expect, data)
+			}
+		})
+	}
+}
+
+func TestWebSocketMessageWithoutRaw(t *testing.T) {
+	data, err := json.Marshal(NewClipboard("text"))
+	if err != nil {
+		t.Fatalf("failed to marshal: %s", err)
+	}
+
+	expect := `{"type":"Clipboard","clipboard":{"text":"text"}}`
+	if string(data) != expect {
+		t.Errorf("expected %s, got %s", expect, data)
+	}
+}
+
+func TestIsUnchanged(t *testing.T) {
+	tests := []struct {
+		name      string
+		fieldType reflect.Type
+		received  string
+		current   string
+		expect    bool
+	}{
+		{"equal", reflect.TypeOf(&OpenInBrowser{}), `{"url":"https://example.com"}`, `{"url":"https://example.com"}`, true},
+		{"whitespace", reflect.TypeOf(&OpenInBrowser{}), `{ "url" : "https://example.com" }`, `{"url":"https://example.com"}`, true},
+		{"unknown field", reflect.TypeOf(&OpenInBrowser{}), `{"target":"_blank","url":"https://example.com"}`, `{"url":"https://example.com"}`, true},
+		{"changed value", reflect.TypeOf(&OpenInBrowser{}), `{"url":"https://example.com"}`, `{"url":"https://example.org"}`, false},
+		{"different order", reflect.TypeOf(&ShadowSession{}), `{"active":true,"supporter":"alice"}`, `{"supporter":"alice","active":true}`, true},
+		{"string", reflect.TypeOf(""), `"Clipboard"`, `"Clipboard"`, true},
+		{"changed string", reflect.TypeOf(""), `"Clipboard"`, `"Stop"`, false},
+		{"invalid type", reflect.TypeOf(""), `{"url":"https://example.com"}`, `""`, false},
+		{"invalid JSON", reflect.TypeOf(&OpenInBrowser{}), `{"url":`, `{"url":""}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rtc := isUnchanged(test.fieldType, json.RawMessage(test.received), json.RawMessage(test.current)); rtc != test.expect {
+				t.Errorf("expected %t, got %t", test.expect, rtc)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Name of the profile that is used when no rule matches
+const DefaultProfile = "default"
+
+// ResourceProfile describes the resources and the scheduling of the LFS pods
+// of the users the profile is assigned to
+type ResourceProfile struct {
+	Name string `json:"name"`
+
+	CPURequest    string `json:"cpuRequest"`
+	MemoryRequest string `json:"memoryRequest"`
+	CPULimit      string `json:"cpuLimit"`
+	MemoryLimit   string `json:"memoryLimit"`
+
+	// Optional scheduling constraints of the pods
+	NodeSelector  map[string]string `json:"nodeSelector,omitempty"`
+	Tolerations   []Toleration      `json:"tolerations,omitempty"`
+	PriorityClass string            `json:"priorityClass,omitempty"`
+
+	// Number of idle placeholders of this profile. The default profile uses the
+	// options of the pool instead
+	MinIdle int `json:"minIdle"`
+	MaxIdle int `json:"maxIdle"`
+}
+
+// Toleration of a node taint like in a pod spec
+type Toleration struct {
+	Key      string `json:"key,omitempty"`
+	Operator string `json:"operator,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Effect   string `json:"effect,omitempty"`
+}
+
+// ProfileRule assigns a profile to all users matching the db, user and group.
+// An empty value or "*" matches everything
+type ProfileRule struct {
+	Db      string `json:"db,omitempty"`
+	User    string `json:"user,omitempty"`
+	Group   string `json:"group,omitempty"`
+	Profile string `json:"profile"`
+}
+
+// ProfileConfig contains the resource profiles and the rules to assign them.
+// The first matching rule wins
+type ProfileConfig struct {
+	Profiles []ResourceProfile `json:"profiles"`
+	Rules    []ProfileRule     `json:"rules"`
+}
+
+// defaultResourceProfile is used when no profile named "default" is configured
+var defaultResourceProfile = ResourceProfile{
+	Name:          DefaultProfile,
+	CPURequest:    "1000m",
+	MemoryRequest: "800Mi",
+	CPULimit:      "2500m",
+	MemoryLimit:   "1700Mi",
+}
+
+// readProfileConfig reads the profiles and rules from the given JSON file. Without
+// a file only the default profile is available
+func readProfileConfig(path string) (ProfileConfig, error) {
+	config := ProfileConfig{}
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return config, err
+		}
+		if err := json.Unmarshal(content, &config); err != nil {
+			return config, fmt.Errorf("invalid JSON: %s", err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i := range config.Profiles {
+		p := &config.Profiles[i]
+		if p.Name == "" {
+			return config, fmt.Errorf("profile %d has no name", i)
+		}
+		if names[p.Name] {
+			return config, fmt.Errorf("profile %q is defined twice", p.Name)
+		}
+		names[p.Name] = true
+
+		// Resources that aren't given are taken from the default profile
+		p.CPURequest = orDefault(p.CPURequest, defaultResourceProfile.CPURequest)
+		p.MemoryRequest = orDefault(p.MemoryRequest, defaultResourceProfile.MemoryRequest)
+		p.CPULimit = orDefault(p.CPULimit, defaultResourceProfile.CPULimit)
+		p.MemoryLimit = orDefault(p.MemoryLimit, defaultResourceProfile.MemoryLimit)
+	}
+	if !names[DefaultProfile] {
+		config.Profiles = append(config.Profiles, defaultResourceProfile)
+	}
+
+	for _, r := range config.Rules {
+		if !names[r.Profile] && r.Profile != DefaultProfile {
+			return config, fmt.Errorf("rule references the unknown profile %q", r.Profile)
+		}
+	}
+
+	return config, nil
+}
+
+// Profile returns the profile with the given name
+func (c ProfileConfig) Profile(name string) (ResourceProfile, bool) {
+	for _, p := range c.Profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return ResourceProfile{}, false
+}
+
+// ResourceProfile returns the profile of the first rule that matches the given user
+func (c *AppConfig) ResourceProfile(user *User) ResourceProfile {
+	for _, r := range c.Profiles.Rules {
+		dbMatches := matchesRule(r.Db, user.DatabaseStr) || matchesRule(r.Db, user.Database.String())
+		userMatches := matchesRule(r.User, user.DbUser)
+		groupMatches := r.Group == "" || r.Group == "*" || c.IsInGroup(user, r.Group)
+
+		if dbMatches && userMatches && groupMatches {
+			if p, ok := c.Profiles.Profile(r.Profile); ok {
+				return p
+			}
+		}
+	}
+
+	p, _ := c.Profiles.Profile(DefaultProfile)
+	return p
+}
+
+// matchesRule returns weather the value of a rule matches the given value
+func matchesRule(rule string, val string) bool {
+	return rule == "" || rule == "*" || strings.EqualFold(rule, val)
+}
+
+// orDefault returns the given value or the default value if it's empty
+func orDefault(val string, defaultValue string) string {
+	if val == "" {
+		return defaultValue
+	}
+
+	return val
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResourceProfile(t *testing.T) {
+	config := AppConfig{
+		UserGroups: map[string][]GroupMember{
+			"controlling": {{Database: LFS, User: "carl"}},
+		},
+		Profiles: ProfileConfig{
+			Profiles: []ResourceProfile{
+				{Name: "large"}, {Name: "small"}, {Name: "gpu"}, {Name: DefaultProfile},
+			},
+			Rules: []ProfileRule{
+				{Db: "lfs", Group: "controlling", Profile: "large"},
+				{Db: "*", User: "alice", Profile: "gpu"},
+				{Db: "lfsprj", Profile: "small"},
+				{Db: "mig", User: "erin", Profile: "large"},
+				{Db: "mig", User: "bob", Profile: "missing"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		user   User
+		expect string
+	}{
+		{"group rule", User{DbUser: "carl", Database: LFS, DatabaseStr: "lfs"}, "large"},
+		{"group rule of another database", User{DbUser: "carl", Database: PRJ, DatabaseStr: "lfsprj"}, "small"},
+		{"user rule", User{DbUser: "Alice", Database: MIG, DatabaseStr: "lfsmig"}, "gpu"},
+		{"first matching rule wins", User{DbUser: "alice", Database: PRJ, DatabaseStr: "lfsprj"}, "gpu"},
+		{"database rule by name", User{DbUser: "dave", Database: PRJ, DatabaseStr: "LFSPRJ"}, "small"},
+		{"database rule by short name", User{DbUser: "erin", Database: MIG, DatabaseStr: "lfsmig"}, "large"},
+		{"rule of an unknown profile", User{DbUser: "bob", Database: MIG, DatabaseStr: "lfsmig"}, DefaultProfile},
+		{"no matching rule", User{DbUser: "dave", Database: LFS, DatabaseStr: "lfs"}, DefaultProfile},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if p := config.ResourceProfile(&test.user); p.Name != test.expect {
+				t.Errorf("expected profile %q, got %q", test.expect, p.Name)
+			}
+		})
+	}
+}
+
+func TestReadProfileConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		profiles  []string
+		expectErr bool
+	}{
+		{"empty", `{}`, []string{DefaultProfile}, false},
+		{"added default profile", `{"profiles":[{"name":"large"}],"rules":[{"db":"lfs","profile":"large"}]}`, []string{"large", DefaultProfile}, false},
+		{"own default profile", `{"profiles":[{"name":"default","cpuLimit":"4"}]}`, []string{DefaultProfile}, false},
+		{"rule of the default profile", `{"rules":[{"user":"alice","profile":"default"}]}`, []string{DefaultProfile}, false},
+		{"invalid JSON", `{"profiles":`, nil, true},
+		{"profile without name", `{"profiles":[{"cpuLimit":"4"}]}`, nil, true},
+		{"profile defined twice", `{"profiles":[{"name":"large"},{"name":"large"}]}`, nil, true},
+		{"unknown profile of rule", `{"rules":[{"db":"lfs","profile":"large"}]}`, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "profiles.json")
+			if err := os.WriteFile(path, []byte(test.content), 0o600); err != nil {
+				t.Fatalf("failed to write profiles: %s", err)
+			}
+
+			config, err := readProfileConfig(path)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %t, got %v", test.expectErr, err)
+			}
+			if test.expectErr {
+				return
+			}
+
+			if len(config.Profiles) != len(test.profiles) {
+				t.Fatalf("expected profiles %v, got %v", test.profiles, config.Profiles)
+			}
+			for i, name := range test.profiles {
+				if config.Profiles[i].Name != name {
+					t.Errorf("expected profile %q at %d, got %q", name, i, config.Profiles[i].Name)
+				}
+				if config.Profiles[i].CPURequest == "" || config.Profiles[i].MemoryLimit == "" {
+					t.Errorf("profile %q has no default resources", name)
+				}
+			}
+		})
+	}
+}
@@ -32,6 +32,22 @@ func NewDatabase(name string) Database {
 		return MIG
 	}
 }
+
+// ParseDatabase returns the database with the given name ("lfsprj") or short
+// name ("prj") that was given by an administrator (URL or config).
+// Unlike NewDatabase, unknown names are reported instead of falling back to MIG
+func ParseDatabase(name string) (Database, bool) {
+	switch strings.ToLower(name) {
+	case "lfsprj", "prj":
+		return PRJ, true
+	case "lfsmig", "mig":
+		return MIG, true
+	case "lfs":
+		return LFS, true
+	default:
+		return Unknown, false
+	}
+}
 func (db Database) String() string {
 	switch db {
 	case PRJ:
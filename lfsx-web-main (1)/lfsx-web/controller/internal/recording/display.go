@@ -0,0 +1,390 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"sort"
+	"strconv"
+
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/guacamole"
+	_ "golang.org/x/image/webp"
+)
+
+// Composite operation of guacamole that replaces the destination
+// with the source. All other operations are drawn as "over"
+const channelMaskSrc = 0xC
+
+// Display reproduces the state of the guacamole client display from
+// the drawing instructions of a recording.
+//
+// Only the instructions that are send by guacd for the remote desktop are
+// supported: images streams, rectangle fills and copies between layers
+type Display struct {
+	layers  map[int]*layer
+	streams map[string]*imageStream
+
+	// Number of image streams that couldn't be decoded
+	Skipped int
+
+	// Weather the display was changed since the last rendered image
+	dirty bool
+}
+
+// layer is a single layer or off-screen buffer (negative index) of the display
+type layer struct {
+	img *image.RGBA
+
+	// Position relative to the parent layer and the stacking order
+	parent  int
+	x, y, z int
+	opacity uint8
+
+	// Rectangles of the current path that will be filled by "cfill"
+	path []image.Rectangle
+}
+
+// imageStream is an image that is received in multiple blobs
+type imageStream struct {
+	mask  int
+	layer int
+	x, y  int
+	data  bytes.Buffer
+}
+
+// NewDisplay creates an empty display
+func NewDisplay() *Display {
+	return &Display{
+		layers:  map[int]*layer{0: newLayer()},
+		streams: make(map[string]*imageStream),
+	}
+}
+
+func newLayer() *layer {
+	return &layer{
+		img:     image.NewRGBA(image.Rect(0, 0, 0, 0)),
+		opacity: 0xFF,
+	}
+}
+
+// Size returns the size of the default layer
+func (d *Display) Size() (int, int) {
+	bounds := d.layers[0].img.Bounds()
+	return bounds.Dx(), bounds.Dy()
+}
+
+// Handle applies the given instruction to the display. Instructions
+// that don't change the display are ignored
+func (d *Display) Handle(ins *guacamole.Instruction) error {
+	var err error
+
+	switch ins.Opcode {
+	case "size":
+		err = d.size(ins.Args)
+	case "img":
+		err = d.beginImage(ins.Args)
+	case "blob":
+		err = d.blob(ins.Args)
+	case "end":
+		err = d.endImage(ins.Args)
+	case "rect":
+		err = d.rect(ins.Args)
+	case "cfill":
+		err = d.cfill(ins.Args)
+	case "copy":
+		err = d.copy(ins.Args)
+	case "move":
+		err = d.move(ins.Args)
+	case "shade":
+		err = d.shade(ins.Args)
+	case "dispose":
+		err = d.dispose(ins.Args)
+	case "reset":
+		err = d.resetPath(ins.Args, 0)
+	case "cstroke", "lfill", "lstroke":
+		// Other path operations are not supported. Make sure that the path doesn't grow forever
+		err = d.resetPath(ins.Args, 1)
+	default:
+		return nil
+	}
+
+	d.dirty = true
+	if err != nil {
+		return fmt.Errorf("invalid %q instruction: %s", ins.Opcode, err)
+	}
+	return nil
+}
+
+// Image renders all visible layers into a single image
+func (d *Display) Image() *image.RGBA {
+	d.dirty = false
+
+	base := d.layers[0].img
+	rtc := image.NewRGBA(base.Bounds())
+	draw.Draw(rtc, rtc.Bounds(), base, image.Point{}, draw.Src)
+
+	// Draw the visible layers in their stacking order
+	indexes := make([]int, 0, len(d.layers))
+	for index := range d.layers {
+		if index > 0 {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Slice(indexes, func(a, b int) bool {
+		if d.layers[indexes[a]].z == d.layers[indexes[b]].z {
+			return indexes[a] < indexes[b]
+		}
+		return d.layers[indexes[a]].z < d.layers[indexes[b]].z
+	})
+
+	for _, index := range indexes {
+		l := d.layers[index]
+		pos := d.position(index)
+		dst := l.img.Bounds().Add(pos)
+		mask := image.NewUniform(color.Alpha{A: l.opacity})
+		draw.DrawMask(rtc, dst, l.img, image.Point{}, mask, image.Point{}, draw.Over)
+	}
+
+	return rtc
+}
+
+// position returns the absolute position of a layer on the display
+func (d *Display) position(index int) image.Point {
+	pos := image.Point{}
+
+	// Guard against loops within the parents
+	for i := 0; i < len(d.layers) && index > 0; i++ {
+		l, ok := d.layers[index]
+		if !ok {
+			break
+		}
+		pos = pos.Add(image.Pt(l.x, l.y))
+		index = l.parent
+	}
+
+	return pos
+}
+
+// layer returns the layer with the given index. A layer that was
+// never used before is created
+func (d *Display) layer(index int) *layer {
+	l, ok := d.layers[index]
+	if !ok {
+		l = newLayer()
+		d.layers[index] = l
+	}
+
+	return l
+}
+
+// draw draws the source image into the layer. Buffers grow automatically
+// to the size of the drawn content while visible layers clip it
+func (d *Display) draw(index int, r image.Rectangle, src image.Image, sp image.Point, mask int) {
+	l := d.layer(index)
+	if index < 0 && !r.In(l.img.Bounds()) {
+		l.resize(l.img.Bounds().Union(image.Rect(0, 0, r.Max.X, r.Max.Y)).Size())
+	}
+
+	op := draw.Over
+	if mask == channelMaskSrc {
+		op = draw.Src
+	}
+	draw.Draw(l.img, r, src, sp, op)
+}
+
+// resize changes the size of the layer while keeping its content
+func (l *layer) resize(size image.Point) {
+	img := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(img, img.Bounds(), l.img, image.Point{}, draw.Src)
+	l.img = img
+}
+
+// size: layer, width, height
+func (d *Display) size(args []string) error {
+	v, err := ints(args, 3)
+	if err != nil {
+		return err
+	}
+	if v[1] < 0 || v[2] < 0 {
+		return fmt.Errorf("negative size %dx%d", v[1], v[2])
+	}
+
+	d.layer(v[0]).resize(image.Pt(v[1], v[2]))
+	return nil
+}
+
+// img: stream, mask, layer, mimetype, x, y
+func (d *Display) beginImage(args []string) error {
+	if len(args) < 6 {
+		return fmt.Errorf("expected 6 arguments but got %d", len(args))
+	}
+	v, err := ints([]string{args[1], args[2], args[4], args[5]}, 4)
+	if err != nil {
+		return err
+	}
+
+	d.streams[args[0]] = &imageStream{mask: v[0], layer: v[1], x: v[2], y: v[3]}
+	return nil
+}
+
+// blob: stream, base64 data
+func (d *Display) blob(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("expected 2 arguments but got %d", len(args))
+	}
+
+	// Blobs of other streams (e.g. audio) are ignored
+	stream, ok := d.streams[args[0]]
+	if !ok {
+		return nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(args[1])
+	if err != nil {
+		return err
+	}
+	stream.data.Write(data)
+	return nil
+}
+
+// end: stream
+func (d *Display) endImage(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("expected 1 argument but got %d", len(args))
+	}
+
+	stream, ok := d.streams[args[0]]
+	if !ok {
+		return nil
+	}
+	delete(d.streams, args[0])
+
+	img, _, err := image.Decode(&stream.data)
+	if err != nil {
+		d.Skipped++
+		return nil
+	}
+
+	bounds := img.Bounds()
+	dst := image.Rect(stream.x, stream.y, stream.x+bounds.Dx(), stream.y+bounds.Dy())
+	d.draw(stream.layer, dst, img, bounds.Min, stream.mask)
+	return nil
+}
+
+// rect: layer, x, y, width, height
+func (d *Display) rect(args []string) error {
+	v, err := ints(args, 5)
+	if err != nil {
+		return err
+	}
+
+	l := d.layer(v[0])
+	l.path = append(l.path, image.Rect(v[1], v[2], v[1]+v[3], v[2]+v[4]))
+	return nil
+}
+
+// cfill: mask, layer, red, green, blue, alpha
+func (d *Display) cfill(args []string) error {
+	v, err := ints(args, 6)
+	if err != nil {
+		return err
+	}
+
+	// The color components are not premultiplied
+	c := image.NewUniform(color.NRGBA{R: uint8(v[2]), G: uint8(v[3]), B: uint8(v[4]), A: uint8(v[5])})
+	l := d.layer(v[1])
+	for _, r := range l.path {
+		d.draw(v[1], r, c, image.Point{}, v[0])
+	}
+	l.path = nil
+
+	return nil
+}
+
+// resetPath clears the current path of the layer given in the argument at the index
+func (d *Display) resetPath(args []string, index int) error {
+	if len(args) <= index {
+		return fmt.Errorf("expected %d arguments but got %d", index+1, len(args))
+	}
+	target, err := strconv.Atoi(args[index])
+	if err != nil {
+		return fmt.Errorf("argument %d is not a number: %q", index, args[index])
+	}
+
+	d.layer(target).path = nil
+	return nil
+}
+
+// copy: source layer, x, y, width, height, mask, destination layer, x, y
+func (d *Display) copy(args []string) error {
+	v, err := ints(args, 9)
+	if err != nil {
+		return err
+	}
+
+	// Copying a layer into itself works because draw handles overlapping rectangles
+	src := d.layer(v[0]).img
+	dst := image.Rect(v[7], v[8], v[7]+v[3], v[8]+v[4])
+	d.draw(v[6], dst, src, image.Pt(v[1], v[2]), v[5])
+	return nil
+}
+
+// move: layer, parent, x, y, z
+func (d *Display) move(args []string) error {
+	v, err := ints(args, 5)
+	if err != nil {
+		return err
+	}
+
+	l := d.layer(v[0])
+	l.parent, l.x, l.y, l.z = v[1], v[2], v[3], v[4]
+	return nil
+}
+
+// shade: layer, opacity
+func (d *Display) shade(args []string) error {
+	v, err := ints(args, 2)
+	if err != nil {
+		return err
+	}
+
+	d.layer(v[0]).opacity = uint8(v[1])
+	return nil
+}
+
+// dispose: layer
+func (d *Display) dispose(args []string) error {
+	v, err := ints(args, 1)
+	if err != nil {
+		return err
+	}
+
+	// The default layer can't be removed
+	if v[0] != 0 {
+		delete(d.layers, v[0])
+	}
+	return nil
+}
+
+// ints parses the first n arguments as integers
+func ints(args []string, n int) ([]int, error) {
+	if len(args) < n {
+		return nil, fmt.Errorf("expected %d arguments but got %d", n, len(args))
+	}
+
+	rtc := make([]int, n)
+	for i := 0; i < n; i++ {
+		v, err := strconv.Atoi(args[i])
+		if err != nil {
+			return nil, fmt.Errorf("argument %d is not a number: %q", i, args[i])
+		}
+		rtc[i] = v
+	}
+
+	return rtc, nil
+}
@@ -0,0 +1,236 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/guacamole"
+)
+
+var (
+	red         = color.RGBA{R: 255, A: 255}
+	green       = color.RGBA{G: 255, A: 255}
+	blue        = color.RGBA{B: 255, A: 255}
+	transparent = color.RGBA{}
+)
+
+// encodePNG returns a PNG of the given size filled with a single color
+func encodePNG(t *testing.T, width int, height int, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode image: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDisplay(t *testing.T) {
+	ins := guacamole.NewInstruction
+	bluePNG := encodePNG(t, 2, 2, blue)
+	half := len(bluePNG) / 2
+
+	tests := []struct {
+		name          string
+		instructions  []*guacamole.Instruction
+		width, height int
+		pixels        map[image.Point]color.RGBA
+		skipped       int
+		expectErr     bool
+	}{
+		{
+			name:         "size",
+			instructions: []*guacamole.Instruction{ins("size", "0", "4", "3")},
+			width:        4, height: 3,
+			pixels: map[image.Point]color.RGBA{{0, 0}: transparent, {3, 2}: transparent},
+		},
+		{
+			name: "filled rectangle",
+			instructions: []*guacamole.Instruction{
+				ins("size", "0", "4", "4"),
+				ins("rect", "0", "1", "1", "2", "2"),
+				ins("cfill", "12", "0", "255", "0", "0", "255"),
+			},
+			width: 4, height: 4,
+			pixels: map[image.Point]color.RGBA{{0, 0}: transparent, {1, 1}: red, {2, 2}: red, {3, 3}: transparent},
+		},
+		{
+			name: "reset path",
+			instructions: []*guacamole.Instruction{
+				ins("size", "0", "2", "2"),
+				ins("rect", "0", "0", "0", "2", "2"),
+				ins("reset", "0"),
+				ins("cfill", "12", "0", "255", "0", "0", "255"),
+			},
+			width: 2, height: 2,
+			pixels: map[image.Point]color.RGBA{{0, 0}: transparent, {1, 1}: transparent},
+		},
+		{
+			name: "fill is clipped to the visible layer",
+			instructions: []*guacamole.Instruction{
+				ins("size", "0", "2", "2"),
+				ins("rect", "0", "0", "0", "5", "5"),
+				ins("cfill", "12", "0", "255", "0", "0", "255"),
+			},
+			width: 2, height: 2,
+			pixels: map[image.Point]color.RGBA{{0, 0}: red, {1, 1}: red},
+		},
+		{
+			name: "image stream",
+			instructions: []*guacamole.Instruction{
+				ins("size", "0", "4", "4"),
+				ins("img", "1", "12", "0", "image/png", "2", "2"),
+				ins("blob", "1", base64.StdEncoding.EncodeToString(bluePNG)),
+				ins("end", "1"),
+			},
+			width: 4, height: 4,
+			pixels: map[image.Point]color.RGBA{{1, 1}: transparent, {2, 2}: blue, {3, 3}: blue},
+		},
+		{
+			name: "image stream of multiple blobs",
+			instructions: []*guacamole.Instruction{
+				ins("size", "0", "2", "2"),
+				ins("img", "1", "12", "0", "image/png", "0", "0"),
+				ins("blob", "1", base64.StdEncoding.EncodeToString(bluePNG[:half])),
+				ins("blob", "1", base64.StdEncoding.EncodeToString(bluePNG[half:])),
+				ins("end", "1"),
+			},
+			width: 2, height: 2,
+			pixels: map[image.Point]color.RGBA{{0, 0}: blue, {1, 1}: blue},
+		},
+		{
+			name: "undecodable image",
+			instructions: []*guacamole.Instruction{
+				ins("size", "0", "2", "2"),
+				ins("img", "1", "12", "0", "image/png", "0", "0"),
+				ins("blob", "1", base64.StdEncoding.EncodeToString([]byte("no image"))),
+				ins("end", "1"),
+			},
+			width: 2, height: 2,
+			pixels:  map[image.Point]color.RGBA{{0, 0}: transparent},
+			skipped: 1,
+		},
+		{
+			name: "blob of an unknown stream",
+			instructions: []*guacamole.Instruction{
+				ins("blob", "5", "AAAA"),
+				ins("end", "5"),
+			},
+		},
+		{
+			name: "copy from a buffer",
+			instructions: []*guacamole.Instruction{
+				ins("size", "0", "4", "4"),
+				ins("rect", "-1", "0", "0", "1", "1"),
+				ins("cfill", "12", "-1", "0", "255", "0", "255"),
+				ins("copy", "-1", "0", "0", "1", "1", "12", "0", "3", "3"),
+			},
+			width: 4, height: 4,
+			pixels: map[image.Point]color.RGBA{{0, 0}: transparent, {3, 3}: green},
+		},
+		{
+			name: "moved layer",
+			instructions: []*guacamole.Instruction{
+				ins("size", "0", "4", "4"),
+				ins("size", "1", "2", "2"),
+				ins("rect", "1", "0", "0", "2", "2"),
+				ins("cfill", "12", "1", "0", "0", "255", "255"),
+				ins("move", "1", "0", "2", "0", "1"),
+			},
+			width: 4, height: 4,
+			pixels: map[image.Point]color.RGBA{{1, 0}: transparent, {2, 0}: blue, {3, 1}: blue, {2, 2}: transparent},
+		},
+		{
+			name: "shaded layer",
+			instructions: []*guacamole.Instruction{
+				ins("size", "0", "2", "2"),
+				ins("size", "1", "2", "2"),
+				ins("rect", "1", "0", "0", "2", "2"),
+				ins("cfill", "12", "1", "0", "0", "255", "255"),
+				ins("shade", "1", "0"),
+			},
+			width: 2, height: 2,
+			pixels: map[image.Point]color.RGBA{{0, 0}: transparent},
+		},
+		{
+			name: "disposed layer",
+			instructions: []*guacamole.Instruction{
+				ins("size", "0", "2", "2"),
+				ins("size", "1", "2", "2"),
+				ins("rect", "1", "0", "0", "2", "2"),
+				ins("cfill", "12", "1", "0", "0", "255", "255"),
+				ins("dispose", "1"),
+				ins("dispose", "0"),
+			},
+			width: 2, height: 2,
+			pixels: map[image.Point]color.RGBA{{0, 0}: transparent},
+		},
+		{
+			name:         "ignored instruction",
+			instructions: []*guacamole.Instruction{ins("size", "0", "1", "1"), ins("mouse", "0", "0")},
+			width:        1, height: 1,
+		},
+		{
+			name:         "invalid number",
+			instructions: []*guacamole.Instruction{ins("size", "0", "x", "2")},
+			expectErr:    true,
+		},
+		{
+			name:         "negative size",
+			instructions: []*guacamole.Instruction{ins("size", "0", "-1", "2")},
+			expectErr:    true,
+		},
+		{
+			name:         "missing arguments",
+			instructions: []*guacamole.Instruction{ins("copy", "0", "0", "0")},
+			expectErr:    true,
+		},
+		{
+			name:         "invalid blob",
+			instructions: []*guacamole.Instruction{ins("img", "1", "12", "0", "image/png", "0", "0"), ins("blob", "1", "%%%")},
+			expectErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			display := NewDisplay()
+
+			var err error
+			for _, instruction := range test.instructions {
+				if err = display.Handle(instruction); err != nil {
+					break
+				}
+			}
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %t, got %v", test.expectErr, err)
+			}
+			if test.expectErr {
+				return
+			}
+
+			if width, height := display.Size(); width != test.width || height != test.height {
+				t.Errorf("expected size %dx%d, got %dx%d", test.width, test.height, width, height)
+			}
+			if display.Skipped != test.skipped {
+				t.Errorf("expected %d skipped images, got %d", test.skipped, display.Skipped)
+			}
+
+			img := display.Image()
+			for point, expect := range test.pixels {
+				if c := img.RGBAAt(point.X, point.Y); c != expect {
+					t.Errorf("expected color %v at %v, got %v", expect, point, c)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/guacamole"
+)
+
+// Name of the file containing the timeline of a rendered recording
+const TimelineFile = "timeline.json"
+
+// Timeline describes a rendered recording. All offsets are given in
+// milliseconds since the first frame of the recording
+type Timeline struct {
+	Width     int        `json:"width"`
+	Height    int        `json:"height"`
+	Start     int64      `json:"start"`
+	Duration  int64      `json:"duration"`
+	Syncs     []Sync     `json:"syncs"`
+	Keyframes []Keyframe `json:"keyframes"`
+}
+
+// Sync is a single frame boundary ("sync" instruction) of the recording
+type Sync struct {
+	Timestamp int64 `json:"timestamp"`
+	Offset    int64 `json:"offset"`
+
+	// Index of the last keyframe that was written up to this frame
+	Keyframe int `json:"keyframe"`
+}
+
+// Keyframe is a rendered image of the display
+type Keyframe struct {
+	Timestamp int64  `json:"timestamp"`
+	Offset    int64  `json:"offset"`
+	File      string `json:"file"`
+}
+
+// Render replays the recording and writes a PNG of the display into the
+// output directory whenever the given interval elapsed. The timeline of
+// the recording is written as "timeline.json" next to the images
+func Render(r io.Reader, output string, interval time.Duration) (*Timeline, error) {
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %s", err)
+	}
+
+	display := NewDisplay()
+	reader := guacamole.NewDumpReader(r)
+	timeline := &Timeline{Syncs: make([]Sync, 0), Keyframes: make([]Keyframe, 0)}
+	nextKeyframe := int64(0)
+
+	for {
+		ins, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			// The recording of a crashed session ends abruptly
+			logger.Warning("Recording ends within an instruction")
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read recording: %s", err)
+		}
+
+		if ins.Opcode != "sync" {
+			if err := display.Handle(ins); err != nil {
+				logger.Debug("Skipping instruction: %s", err)
+			}
+			continue
+		}
+
+		if len(ins.Args) < 1 {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(ins.Args[0], 10, 64)
+		if err != nil {
+			logger.Debug("Skipping sync with invalid timestamp %q", ins.Args[0])
+			continue
+		}
+		if len(timeline.Syncs) == 0 {
+			timeline.Start = timestamp
+		}
+		offset := timestamp - timeline.Start
+
+		if offset >= nextKeyframe && display.dirty {
+			written, err := writeKeyframe(display, output, timeline, timestamp, offset)
+			if err != nil {
+				return nil, err
+			} else if written {
+				nextKeyframe = offset + interval.Milliseconds()
+			}
+		}
+
+		timeline.Syncs = append(timeline.Syncs, Sync{Timestamp: timestamp, Offset: offset, Keyframe: len(timeline.Keyframes) - 1})
+		timeline.Duration = offset
+	}
+
+	// Always include the final state of the display
+	if display.dirty && len(timeline.Syncs) > 0 {
+		last := timeline.Syncs[len(timeline.Syncs)-1]
+		if _, err := writeKeyframe(display, output, timeline, last.Timestamp, last.Offset); err != nil {
+			return nil, err
+		}
+	}
+
+	timeline.Width, timeline.Height = display.Size()
+	if display.Skipped > 0 {
+		logger.Warning("Skipped %d images with an unsupported format", display.Skipped)
+	}
+
+	data, err := json.MarshalIndent(timeline, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal timeline: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(output, TimelineFile), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write timeline: %s", err)
+	}
+
+	return timeline, nil
+}
+
+// writeKeyframe writes the current state of the display as the next keyframe.
+// Returns false if the display has no size yet
+func writeKeyframe(display *Display, output string, timeline *Timeline, timestamp int64, offset int64) (bool, error) {
+	if width, height := display.Size(); width == 0 || height == 0 {
+		return false, nil
+	}
+
+	name := fmt.Sprintf("keyframe-%05d.png", len(timeline.Keyframes))
+	file, err := os.Create(filepath.Join(output, name))
+	if err != nil {
+		return false, fmt.Errorf("failed to create keyframe: %s", err)
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, display.Image()); err != nil {
+		return false, fmt.Errorf("failed to write keyframe %q: %s", name, err)
+	}
+
+	timeline.Keyframes = append(timeline.Keyframes, Keyframe{Timestamp: timestamp, Offset: offset, File: name})
+	return true, nil
+}
@@ -0,0 +1,205 @@
+// recording manages the server-side recordings of the guacamole
+// sessions that were written by guacd into a shared volume
+package recording
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+)
+
+// File extension of all recordings
+const Extension = ".guac"
+
+// Store provides access to the recordings inside the mounted volume
+// and removes recordings that exceeded the retention time
+type Store struct {
+
+	// Directory containing all recordings
+	path string
+
+	// Recordings older than this are deleted
+	retention time.Duration
+}
+
+// Recording describes a single recorded session
+type Recording struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// NewStore creates a new store for the recordings in the configured path
+func NewStore(config models.RecordingConfig) *Store {
+	return &Store{
+		path:      config.Path,
+		retention: time.Duration(config.RetentionDays) * 24 * time.Hour,
+	}
+}
+
+// NewRecordingName returns a unique file name for a new recording
+// of the given user
+func NewRecordingName(user *models.User) string {
+	return fmt.Sprintf("%s_%s%s", user.Identifier(), time.Now().Format("20060102-150405"), Extension)
+}
+
+// List returns all recordings sorted from the newest to the oldest one.
+//
+// The recordings of the sessions are written into an own directory of
+// every pod. Recordings in the root directory are listed as well
+func (s *Store) List() ([]Recording, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recordings: %s", err)
+	}
+
+	rtc := make([]Recording, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			sessionEntries, err := os.ReadDir(filepath.Join(s.path, e.Name()))
+			if err != nil {
+				logger.Debug("Failed to read recordings of session directory %q: %s", e.Name(), err)
+				continue
+			}
+			for _, se := range sessionEntries {
+				rtc = appendRecording(rtc, se)
+			}
+			continue
+		}
+
+		rtc = appendRecording(rtc, e)
+	}
+
+	sort.Slice(rtc, func(a, b int) bool {
+		return rtc[a].Modified.After(rtc[b].Modified)
+	})
+	return rtc, nil
+}
+
+// appendRecording appends the given directory entry to the list if it's a recording
+func appendRecording(recordings []Recording, e os.DirEntry) []Recording {
+	if e.IsDir() || !strings.HasSuffix(e.Name(), Extension) {
+		return recordings
+	}
+
+	info, err := e.Info()
+	if err != nil {
+		return recordings
+	}
+	return append(recordings, Recording{Name: e.Name(), Size: info.Size(), Modified: info.ModTime()})
+}
+
+// Open opens the recording with the given name for reading
+func (s *Store) Open(name string) (*os.File, error) {
+	path, err := s.file(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(path)
+}
+
+// Delete removes the recording with the given name
+func (s *Store) Delete(name string) error {
+	path, err := s.file(name)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Run deletes all recordings that exceeded the retention time periodically
+// until the given context is canceled.
+// Without a retention time (zero or negative) the recordings are kept forever.
+//
+// This method does block
+func (s *Store) Run(ctx context.Context) {
+	if s.retention <= 0 {
+		logger.Info("Keeping the recordings forever because no retention time is set")
+		return
+	}
+
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	s.cleanup()
+	for {
+		select {
+		case <-ticker.C:
+			s.cleanup()
+		case <-ctx.Done():
+			logger.Info("Stopped cleaning up the recordings")
+			return
+		}
+	}
+}
+
+// cleanup deletes all recordings that exceeded the retention time and
+// the empty directories of sessions that were stopped
+func (s *Store) cleanup() {
+	recordings, err := s.List()
+	if err != nil {
+		logger.Warning("Failed to clean up recordings: %s", err)
+		return
+	}
+
+	for _, r := range recordings {
+		if time.Since(r.Modified) > s.retention {
+			logger.Debug("Deleting recording %q because it exceeded the retention time", r.Name)
+			if err := s.Delete(r.Name); err != nil {
+				logger.Warning("Failed to delete recording %q: %s", r.Name, err)
+			}
+		}
+	}
+
+	// A directory is only removed if it's empty. The directory of a running session is kept
+	// because it's modified on every new recording
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && e.IsDir() && time.Since(info.ModTime()) > s.retention {
+			os.Remove(filepath.Join(s.path, e.Name()))
+		}
+	}
+}
+
+// file returns the path of the recording with the given name. The
+// recording is searched in the root directory and in the directories of the
+// sessions. Names pointing outside of the recording directory are rejected
+func (s *Store) file(name string) (string, error) {
+	if name != filepath.Base(name) || !strings.HasSuffix(name, Extension) {
+		return "", fmt.Errorf("invalid recording name %q", name)
+	}
+
+	path := filepath.Join(s.path, name)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read recordings: %s", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		sessionPath := filepath.Join(s.path, e.Name(), name)
+		if _, err := os.Stat(sessionPath); err == nil {
+			return sessionPath, nil
+		}
+	}
+
+	return "", os.ErrNotExist
+}
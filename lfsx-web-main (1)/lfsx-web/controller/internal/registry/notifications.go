@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"sync"
+	"time"
+
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+)
+
+// NotificationStore keeps the notifications of the administrators for all
+// controller replicas. Every replica delivers the stored notifications to
+// the users connected to it
+type NotificationStore interface {
+
+	// AddNotification stores the given notification. Expired notifications are removed
+	AddNotification(notification models.Notification) error
+
+	// Notifications returns all stored notifications that are still active
+	// sorted by their creation time
+	Notifications() ([]models.Notification, error)
+}
+
+// LocalNotifications keeps the notifications in memory for a controller
+// that runs as a single instance
+type LocalNotifications struct {
+	notifications []models.Notification
+	lock          sync.Mutex
+}
+
+// NewLocalNotificationStore creates a notification store for a single controller instance
+func NewLocalNotificationStore() *LocalNotifications {
+	return &LocalNotifications{}
+}
+
+// AddNotification stores the given notification in memory
+func (l *LocalNotifications) AddNotification(notification models.Notification) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.notifications = append(models.ActiveNotifications(l.notifications, time.Now()), notification)
+	return nil
+}
+
+// Notifications returns all active notifications
+func (l *LocalNotifications) Notifications() ([]models.Notification, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.notifications = models.ActiveNotifications(l.notifications, time.Now())
+	return append([]models.Notification(nil), l.notifications...), nil
+}
@@ -0,0 +1,62 @@
+// registry records which controller replica holds the WebSocket
+// connection of a user session.
+//
+// When the controller runs with multiple replicas, only the owning
+// replica can talk with the LFS.X of a user. All other replicas
+// forward their requests to the owner
+package registry
+
+import (
+	"errors"
+
+	"gitea.hama.de/LFS/lfsx-web/controller/internal/models"
+)
+
+// ErrSessionOwned is returned when a session is already owned by
+// another controller replica
+var ErrSessionOwned = errors.New("session is owned by another replica")
+
+// Registry keeps track of the owner of every connected session
+type Registry interface {
+
+	// Acquire records this replica as the owner of the session of the given user.
+	// If another replica does already own the session "ErrSessionOwned" is returned
+	Acquire(user *models.User) error
+
+	// Release removes the ownership of this replica for the given user
+	Release(user *models.User)
+
+	// Owner returns the replica that currently owns the session of the user.
+	// The second parameter is false if no replica owns the session
+	Owner(user *models.User) (*models.Replica, bool)
+
+	// Replicas returns all other replicas that currently own a session
+	Replicas() []models.Replica
+}
+
+// Local is a registry for a controller that runs as a single instance.
+// The sessions are tracked by the VNC proxy itself, so nothing is recorded
+type Local struct{}
+
+// NewLocalRegistry creates a registry for a single controller instance
+func NewLocalRegistry() *Local {
+	return &Local{}
+}
+
+// Acquire does always succeed
+func (l *Local) Acquire(user *models.User) error {
+	return nil
+}
+
+// Release does nothing
+func (l *Local) Release(user *models.User) {}
+
+// Owner never returns an owner because there are no other replicas
+func (l *Local) Owner(user *models.User) (*models.Replica, bool) {
+	return nil, false
+}
+
+// Replicas never returns a replica because there are no other replicas
+func (l *Local) Replicas() []models.Replica {
+	return nil
+}
@@ -12,14 +12,14 @@ function App() {
 	)
 }
 
-export function notify(message: string, type: TypeOptions, theme?: "light" | "dark" | "default") {
+export function notify(message: string, type: TypeOptions, theme?: "light" | "dark" | "default", autoClose: number | false = 4000) {
 	if (!theme || theme === "default") {
 		theme = document.getElementById("dark") ? "dark" : "light"
 	}
   
 	toast(message, {
 		position: "top-right",
-		autoClose: 4000,
+		autoClose: autoClose,
 		hideProgressBar: false,
 		closeOnClick: true,
 		pauseOnHover: true,
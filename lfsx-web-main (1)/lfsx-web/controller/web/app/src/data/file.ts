@@ -3,4 +3,23 @@ export type FileUploadRequest = {
 	// Defines the file types the file input should accept. See the JavaScript file description of "accept"
 	// for more informations. E.g.: .doc,.docx,.xml,application/msword
 	accept: string
-}
\ No newline at end of file
+}
+
+/** Send from the controller when the LFS.X exported a file that can be downloaded */
+export type FileDownloadReady = {
+	name: string
+	size: number
+	modifiedAt: string
+	// Path of the API to download the file from
+	url: string
+}
+
+/** Downloads the given file by the browser */
+export function downloadFile(file: FileDownloadReady) {
+	const link = document.createElement('a')
+	link.href = file.url
+	link.download = file.name
+	document.body.appendChild(link)
+	link.click()
+	link.remove()
+}
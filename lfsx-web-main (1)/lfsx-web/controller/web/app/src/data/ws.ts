@@ -1,4 +1,4 @@
-import { FileUploadRequest } from "./file"
+import { FileDownloadReady, FileUploadRequest } from "./file"
 
 
 export type WebSocketData = {
@@ -16,13 +16,61 @@ export type WebSocketData = {
 export type WebSocketMessage = {
 
 	// The type of the message
-	type: "LoginRequest" | "LfsStartup" | "Stop" | "OpenInBrowser" | "FileUploadRequest" | "FileUploadFinished"
+	type: "LoginRequest" | "LfsStartup" | "Stop" | "OpenInBrowser" | "FileUploadRequest" | "FileUploadFinished" | "ShadowSession" | "SessionStats" | "Clipboard" | "FileDownloadReady" | "Notification" | "SessionOutdated"
 
 	// One of the following types as the message data
 	openInBrowser?: OpenInBrowser 
 	fileUploadRequest?: FileUploadRequest
+	shadowSession?: ShadowSession
+	sessionStats?: SessionStats
+	clipboard?: Clipboard
+	fileDownloadReady?: FileDownloadReady
+	notification?: Notification
+	sessionOutdated?: SessionOutdated
+}
+
+/** Send between the client and the controller when a clipboard changes (noVNC only) */
+export type Clipboard = {
+	text: string
+}
+
+/** Send from the controller when an administrator broadcasts a message to all users */
+export type Notification = {
+	id: string
+	text: string
+	severity: "info" | "warning" | "critical"
+
+	/** Optional time (ISO 8601) the message counts down to */
+	countdownTo?: string
+
+	createdAt: string
+	expiresAt: string
+}
+
+/** Send from the controller when the session runs an outdated image version */
+export type SessionOutdated = {
+	imageVersion: string
+	currentVersion: string
 }
 
 export type OpenInBrowser = {
 	url: string
+}
+
+/** Send from the controller when a supporter starts or stops watching the session */
+export type ShadowSession = {
+	supporter: string
+	active: boolean
+}
+
+/** Send periodically from the controller with the traffic of the session */
+export type SessionStats = {
+	bytesIn: number
+	bytesOut: number
+	messagesIn: number
+	messagesOut: number
+	frames: number
+	bytesInPerSecond: number
+	bytesOutPerSecond: number
+	framesPerSecond: number
 }
\ No newline at end of file
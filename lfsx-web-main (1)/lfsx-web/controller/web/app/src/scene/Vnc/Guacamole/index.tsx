@@ -7,6 +7,7 @@ import SecurityHelper from '../../../services/SecuriyHelper';
 // @ts-ignore
 import Keyboard from '../../../components/NoVNC/core/input/keyboard.js'
 import LoadingAnimation from '../../../components/LoadingAnimation';
+import { notify } from '../../../App';
 
 const Guacamole: React.ForwardRefRenderFunction<GuacamoleHandler, GuacamoleProps> = (props, ref) => {
 
@@ -28,6 +29,31 @@ const Guacamole: React.ForwardRefRenderFunction<GuacamoleHandler, GuacamoleProps
 	// NoVNC keyboard
 	const vncKeyboard = useRef<any>()
 
+	// Uploads the files dropped onto the display to the upload the LFS.X requested. The controller
+	// rejects files while no upload is requested or that are not accepted by the request
+	const onDrop = (e: React.DragEvent) => {
+		e.preventDefault()
+		if (!guaRef.current) return
+
+		Array.from(e.dataTransfer.files).forEach(file => {
+			// eslint-disable-next-line @typescript-eslint/no-non-null-assertion
+			const stream = guaRef.current!.createFileStream(file.type, file.name)
+			const writer = new Gua.BlobWriter(stream)
+
+			writer.oncomplete = () => {
+				writer.sendEnd()
+				notify("Die Datei \"" + file.name + "\" wurde hochgeladen", 'success')
+			}
+			// The stream is closed by the client on an error
+			writer.onerror = (_blob: Blob, _offset: number, status: Gua.Status) => {
+				console.log("Failed to upload " + file.name + ": " + status.message)
+				notify("Die Datei \"" + file.name + "\" konnte nicht hochgeladen werden", 'error')
+			}
+
+			writer.sendBlob(file)
+		})
+	}
+
 	// Register events
 	useEffect(() => {
 		let stillValid = true
@@ -61,8 +87,12 @@ const Guacamole: React.ForwardRefRenderFunction<GuacamoleHandler, GuacamoleProps
 				return
 			}
 
-			const tunnel = new Gua.WebSocketTunnel(props.url)
-			tunnel.receiveTimeout = 50000
+			// Fall back to the HTTP tunnel when no WebSocket connection can be established (e.g. a proxy strips the upgrade)
+			const wsTunnel = new Gua.WebSocketTunnel(props.url)
+			const httpTunnel = new Gua.HTTPTunnel(props.tunnelUrl)
+			wsTunnel.receiveTimeout = 50000
+			httpTunnel.receiveTimeout = 50000
+			const tunnel = new Gua.ChainedTunnel(wsTunnel, httpTunnel)
 	
 			guaRef.current = new Gua.Client(tunnel)
 	
@@ -73,6 +103,12 @@ const Guacamole: React.ForwardRefRenderFunction<GuacamoleHandler, GuacamoleProps
 			displayRef.current?.appendChild(guaRef.current.getDisplay().getElement())
 			displayRef.current?.focus()
 	
+			// Errors sent by the server (like a takeover of the session)
+			guaRef.current.onerror = (error: Gua.Status) => {
+				stateRef.current = 5
+				props.onSocketClose({ code: error.code, reason: error.message ?? "Error", wasClean: true } as CloseEvent)
+			}
+
 			// Error handler
 			tunnel.onerror = (error: Gua.Status) => {
 				stateRef.current = 5
@@ -80,19 +116,19 @@ const Guacamole: React.ForwardRefRenderFunction<GuacamoleHandler, GuacamoleProps
 				console.log("Tunnel closed: " + error.message)
 			}
 
-			const handleServerClipboardChange = (stream: any, mimetype: any) => {
+			const handleServerClipboardChange = (stream: Gua.InputStream, mimetype: string) => {
 				if (mimetype === "text/plain") {
-					// stream.onblob = (data) => copyToClipboard(atob(data));
-					stream.onblob = (data: any) => {
-						const serverClipboard = atob(data);
-						// we don't want action if our knowledge of server cliboard is unchanged
-						// and also don't want to fire if we just selected several space character accidentaly
-						// which hapens often in SSH session
+					// The controller sends the clipboard of the session as UTF-8
+					const reader = new Gua.StringReader(stream)
+					let serverClipboard = ""
+					reader.ontext = (text: string) => {
+						serverClipboard += text
+					}
+					reader.onend = () => {
+						// Don't override the clipboard of the client when only whitespaces were selected
 						if (serverClipboard.trim() !== "") {
-							// Put data received form server to client's clipboard
-							const text = convertFromGuacamole(serverClipboard)
-							console.log("Received server clipboard: " + text)
-							navigator.clipboard.writeText(text);
+							console.log("Received server clipboard")
+							navigator.clipboard.writeText(serverClipboard);
 						}
 					}
 				} else {
@@ -166,6 +202,7 @@ const Guacamole: React.ForwardRefRenderFunction<GuacamoleHandler, GuacamoleProps
 				"scheme=vnc&useGuacamole=true&userIdentifier=" + encodeURIComponent(SecurityHelper.getUserIdentification())
 				+ "&quality=" + customizations.quality 
 				+ "&scale=" + customizations.scalingFactor
+				+ (props.takeover ? "&takeover=true" : "")
 			)
 		},
 
@@ -179,17 +216,17 @@ const Guacamole: React.ForwardRefRenderFunction<GuacamoleHandler, GuacamoleProps
 			if (guaRef.current) guaRef.current.sendKeyEvent(down ? 1 : 0, keysym)
 		},
 
+		resize(width, height) {
+			// The controller applies the size to the remote desktop
+			if (guaRef.current) guaRef.current.sendSize(width, height)
+		},
+
 		clipboardPaste(text) {
 			if (guaRef.current) {
 				const stream = guaRef.current.createClipboardStream("text/plain");
 				const writer = new Gua.StringWriter(stream)
-				console.log("Sending clipboard text: " + text)
-
-				writer.onack = () => {
-					writer.sendEnd()
-					stream.sendEnd()
-				}
 
+				// The controller writes the text into the clipboard of the session
 				writer.sendText(text)
 				writer.sendEnd()
 			}
@@ -219,6 +256,8 @@ const Guacamole: React.ForwardRefRenderFunction<GuacamoleHandler, GuacamoleProps
 				ref={displayRef as any} 
 				style={{ width: "100%", height: "100%", position: "absolute", backgroundColor: "#e8e6e6" }}
 				onClick={parentOnClickHandler}
+				onDragOver={(e) => e.preventDefault()}
+				onDrop={onDrop}
 			>
 			</div>
 		</>
@@ -228,10 +267,14 @@ const Guacamole: React.ForwardRefRenderFunction<GuacamoleHandler, GuacamoleProps
 
 export type GuacamoleProps = {
 	url: string
+	// URL of the HTTP tunnel used when the WebSocket connection fails
+	tunnelUrl: string
 	className: string
 	ref: React.MutableRefObject<Gua.Client | undefined>
 	onSocketClose: (e: CloseEvent) => void
-	disconnectReason: { code: "USER_ALREADY_EXISTS" | "UNKNOWN", message: string  } | null
+	disconnectReason: { code: "USER_ALREADY_EXISTS" | "SESSION_TAKEN_OVER" | "SESSION_CLOSED_BY_ADMIN" | "UNKNOWN", message: string  } | null
+	// Close an existing connection of the user on connect
+	takeover: boolean
 	onConnect: () => void
 
 	onKeyType: (keysym: number, desc: string, down: boolean) => boolean
@@ -242,6 +285,7 @@ export type GuacamoleHandler = {
 	connect: () => void
 	disconnect: () => void
 	sendKey: (keysym: number, code: string, down?: boolean) => void
+	resize: (width: number, height: number) => void
 	clipboardPaste: (text: string) => void
 	focus: () => void
 
@@ -254,14 +298,3 @@ export function instanceOfGuacamoleHandler(object: any): object is GuacamoleHand
 }
 
 export default forwardRef(Guacamole)
-
-function convertFromGuacamole(str: string): string {
-	return str
-		.replaceAll("\u00C3\u0083\u00C2\u00A4", "ä")
-		.replaceAll("\u00C3\u0083\u00C2\u0084", "Ä")
-		.replaceAll("\u00C3\u0083\u00C2\u00BC", "ü")
-		.replaceAll("\u00C3\u0083\u00C2\u009C", "Ü")
-		.replaceAll("\u00C3\u0083\u00C2\u0096", "Ö")
-		.replaceAll("\u00C3\u0083\u00C2\u00B6", "ö")
-		.replaceAll("\u00C3\u0083\u00C2\u009F", "ß")
-}
\ No newline at end of file
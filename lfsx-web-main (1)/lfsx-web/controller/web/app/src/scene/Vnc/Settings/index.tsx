@@ -3,6 +3,7 @@ import { CustomizationContext, useCustomizations } from '../../../provider/Custo
 import './index.css'
 import { useEffectAfterMount } from '../../../services/helper'
 import { GenericModal } from '../../../components/GenericModal'
+import { SessionStats } from '../../../data/ws'
 
 export function VncSettings(props: VncSettingsProps) {
 
@@ -71,12 +72,33 @@ export function VncSettings(props: VncSettingsProps) {
 				</select>
 			</label>
 			<br />
+
+			{ props.stats && 
+				<div className="grid stats">
+					<span>Verbindung:</span>
+					<span>
+						↓ {formatBytes(props.stats.bytesOutPerSecond)}/s &nbsp;
+						↑ {formatBytes(props.stats.bytesInPerSecond)}/s &nbsp;
+						{props.stats.framesPerSecond > 0 && props.stats.framesPerSecond.toFixed(1) + " Bilder/s"}
+					</span>
+				</div>
+			}
 				
 		</GenericModal>
 	)
 }
 
+/** Formats the given number of bytes human readable */
+function formatBytes(bytes: number): string {
+	if (bytes < 1024) return bytes.toFixed(0) + " B"
+	if (bytes < 1024 * 1024) return (bytes / 1024).toFixed(1) + " KB"
+	return (bytes / 1024 / 1024).toFixed(1) + " MB"
+}
+
 export type VncSettingsProps = {
 	setVisible: (visible: boolean) => void
 	visible: boolean
+
+	/** Traffic of the current session */
+	stats: SessionStats | null
 }
\ No newline at end of file
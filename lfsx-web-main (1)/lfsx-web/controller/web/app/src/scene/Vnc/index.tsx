@@ -5,8 +5,8 @@ import LoadingAnimation from '../../components/LoadingAnimation';
 import { RequestHelper, StandardResponse } from '../../services/RequestService';
 import { getItems, hasItemChanged, toogleFullscreen } from './toolbar';
 import { probe, resizeWindow, scaleWindowHot } from '../../data/vnc';
-import { WebSocketMessage } from '../../data/ws';
-import { connect, send } from './ws';
+import { Notification, SessionStats, WebSocketMessage } from '../../data/ws';
+import { connect, disconnect, send } from './ws';
 import { useNavigate } from 'react-router-dom';
 import { doLogout } from '../../data/login';
 import { VncSettings } from './Settings';
@@ -16,12 +16,25 @@ import Guacamole, { GuacamoleHandler, instanceOfGuacamoleHandler } from './Guaca
 import { useEffectAfterMount } from '../../services/helper';
 import { UploadDialog } from './UploadDialog';
 import { notify } from '../../App';
+import { downloadFile } from '../../data/file';
+
+// Time to wait before pressing Ctrl + V after the clipboard was sent over the
+// LFS.X WebSocket. The keys of noVNC are sent over another connection
+const clipboardPasteDelay = 300
 
 export default function Vnc() {
 
 	const [ isLoading, setLoading ] = useState(true)
-	const [ disconnectReason, setDisconnectReason ] = useState<{ code: "USER_ALREADY_EXISTS" | "UNKNOWN", message: string  } | null>(null)
+	const [ disconnectReason, setDisconnectReason ] = useState<{ code: "USER_ALREADY_EXISTS" | "SESSION_TAKEN_OVER" | "SESSION_CLOSED_BY_ADMIN" | "UNKNOWN", message: string  } | null>(null)
+
+	// Close the connection of the user in another window when connecting
+	const [ takeover, setTakeover ] = useState(false)
+	// The session was taken over by another window. No reconnects are made
+	const wasTakenOver = useRef(false)
 	const [ settingsVisible, setSettingsVisible ] = useState(false)
+
+	// Traffic of the session send by the controller
+	const [ sessionStats, setSessionStats ] = useState<SessionStats | null>(null)
 	
 	// Show a paste field for a short moment to be able to pase a text into the LFS.X 
 	const [ showPasteField, setShowPasteField ] = useState(false)
@@ -47,7 +60,8 @@ export default function Vnc() {
 
 	// URL to connect to
 	const baseURL = (location.protocol == "http:" ? "ws" : "wss") + "://" + location.host + "/api/vnc/ws"
-	const url = baseURL + '?userIdentifier=' + encodeURIComponent(SecurityHelper.getUserIdentification())
+	const tunnelURL = location.protocol + "//" + location.host + "/api/vnc/tunnel"
+	const url = baseURL + '?userIdentifier=' + encodeURIComponent(SecurityHelper.getUserIdentification()) + (takeover ? '&takeover=true' : '')
 
 	// Grab / ungrab keyboard for guacamole
 	useEffect(() => {
@@ -91,10 +105,39 @@ export default function Vnc() {
 		} )
 	}, [ customizations.scalingFactor ])
 
+	// IDs of the notifications of the administrators that were already shown
+	const shownNotifications = useRef(new Set<string>())
+
 	const reconnectsOnClose = useRef(0)
 	const onSocketClose = (e: CloseEvent) => {
 		console.log("Closed connection to WebSocket (" + e.code + ": " + e.reason + ")")
 
+		// The session was taken over by another window -> don't steal it back by reconnecting
+		if (e.reason?.startsWith("SESSION_TAKEN_OVER") || wasTakenOver.current) {
+			if (!wasTakenOver.current) {
+				wasTakenOver.current = true
+				disconnect()
+				setLoading(false)
+				setDisconnectReason({
+					code: "SESSION_TAKEN_OVER",
+					message: "Die Sitzung wurde in einem anderen Fenster übernommen" + e.reason.substring("SESSION_TAKEN_OVER".length)
+				})
+			}
+			return
+		}
+
+		// The session was closed by an administrator -> don't reconnect automatically
+		if (e.reason?.startsWith("SESSION_CLOSED_BY_ADMIN")) {
+			wasTakenOver.current = true
+			disconnect()
+			setLoading(false)
+			setDisconnectReason({
+				code: "SESSION_CLOSED_BY_ADMIN",
+				message: "Die Sitzung wurde von einem Administrator beendet" + e.reason.substring("SESSION_CLOSED_BY_ADMIN".length)
+			})
+			return
+		}
+
 		// Determine the reason why the connection was closed
 		probe(customizations).then(res => {
 			setLoading(false)
@@ -126,6 +169,21 @@ export default function Vnc() {
 		})
 	}
 
+	/** Closes the connection of the user in another window and connects this window */
+	const takeoverSession = () => {
+		wasTakenOver.current = false
+		reconnectsOnClose.current = 0
+		setDisconnectReason(null)
+		setLoading(true)
+		setTakeover(true)
+
+		// Wait until the components received the takeover flag
+		setTimeout(() => {
+			ref.current?.connect()
+			connect(onWebSocketMessage)
+		}, 200)
+	}
+
 	let lastResizeId = 0;
 	const onResize = () => {
 
@@ -136,7 +194,12 @@ export default function Vnc() {
 		lastResizeId = setTimeout(() => {
 			console.log("Sending reseize request")
 			const size = getBrowserSize()
-			resizeWindow(size.width, size.height)
+			if (ref.current && instanceOfGuacamoleHandler(ref.current)) {
+				// Guacamole sends the size over the existing connection
+				ref.current.resize(size.width, size.height)
+			} else {
+				resizeWindow(size.width, size.height)
+			}
 		}, 100)
 	}
 	const getBrowserSize = () => {
@@ -187,6 +250,10 @@ export default function Vnc() {
 
 	/** Called when a WebSocket message was received from the LFS.X / controller */
 	const onWebSocketMessage = (id: number, responseTo: number | null, message: WebSocketMessage) => {
+		if (message.type === "SessionStats" && message.sessionStats) {
+			setSessionStats(message.sessionStats)
+			return
+		}
 		console.log("Received message from LFS.X WebSocket: " + message.type)
 
 		if (message.type === "Stop") {
@@ -196,6 +263,46 @@ export default function Vnc() {
 			window.open(message.openInBrowser.url, '_blank')?.focus()
 		} else if (message.type === "FileUploadRequest" && message.fileUploadRequest) {
 			setShowUploadDialog({ accept: message.fileUploadRequest.accept, id: id })
+		} else if (message.type === "FileDownloadReady" && message.fileDownloadReady) {
+			notify("Die Datei \"" + message.fileDownloadReady.name + "\" wird heruntergeladen", 'info')
+			downloadFile(message.fileDownloadReady)
+		} else if (message.type === "Clipboard" && message.clipboard) {
+			if (customizations.clipBoardSupport && navigator.clipboard) {
+				navigator.clipboard.writeText(message.clipboard.text)
+			}
+		} else if (message.type === "Notification" && message.notification) {
+			showNotification(message.notification)
+		} else if (message.type === "SessionOutdated" && message.sessionOutdated) {
+			notify("Eine neue Version des LFS.X ist verfügbar. Bitte beenden Sie das LFS.X bei Gelegenheit und melden Sie sich erneut an", 'warning', undefined, false)
+		} else if (message.type === "ShadowSession" && message.shadowSession) {
+			if (message.shadowSession.active) {
+				notify(message.shadowSession.supporter + " sieht sich Ihre Sitzung an", 'warning')
+			} else {
+				notify(message.shadowSession.supporter + " sieht sich Ihre Sitzung nicht mehr an", 'info')
+			}
+		}
+	}
+
+	/** Shows a notification of an administrator. A notification is only shown once,
+	 * even if it's sent again after a reconnect */
+	const showNotification = (notification: Notification) => {
+		if (shownNotifications.current.has(notification.id)) {
+			return
+		}
+		shownNotifications.current.add(notification.id)
+
+		let text = notification.text
+		if (notification.countdownTo) {
+			const countdownTo = new Date(notification.countdownTo)
+			const minutes = Math.max(0, Math.round((countdownTo.getTime() - Date.now()) / 60000))
+			text += " (in " + minutes + " Minuten um " + countdownTo.toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" }) + " Uhr)"
+		}
+
+		// Warnings stay visible until the user closes them
+		if (notification.severity === "info") {
+			notify(text, 'info', undefined, 10000)
+		} else {
+			notify(text, notification.severity === "critical" ? 'error' : 'warning', undefined, false)
 		}
 	}
 
@@ -283,19 +390,29 @@ export default function Vnc() {
 				}) }
 			</div>
 
-			<VncSettings setVisible={setSettingsVisible} visible={settingsVisible}/>
+			<VncSettings setVisible={setSettingsVisible} visible={settingsVisible} stats={sessionStats}/>
 
 			{showPasteField && <div id="paste-field" style={{ top: mousePosition.current.y - 30, left: mousePosition.current.x - 50, zIndex: 10 }} > 
 				<textarea 
 					autoFocus={true} rows={4} cols={40} 
 					onChange={ (e) => { 
-						ref.current?.clipboardPaste(convertToLatin1(e.target.value));
+						// The controller writes the text into the clipboard of the session. Guacamole
+						// clients send it within the connection, so the keys are handled afterwards
+						const isGuacamole = ref.current && instanceOfGuacamoleHandler(ref.current)
+						if (isGuacamole) {
+							(ref.current as GuacamoleHandler).clipboardPaste(e.target.value)
+						} else {
+							send(null, { type: "Clipboard", clipboard: { text: e.target.value } })
+						}
+
 						// Send STRG + V to lFS.X
-						ref.current?.sendKey(65507, "ControlLeft", true)
-						ref.current?.sendKey(118, "KeyV", true) 
-						ref.current?.sendKey(118, "KeyV", false)
-						ref.current?.sendKey(65507, "ControlLeft", false)
-						onKeyType(0, "ControlLeft", false)
+						setTimeout(() => {
+							ref.current?.sendKey(65507, "ControlLeft", true)
+							ref.current?.sendKey(118, "KeyV", true) 
+							ref.current?.sendKey(118, "KeyV", false)
+							ref.current?.sendKey(65507, "ControlLeft", false)
+							onKeyType(0, "ControlLeft", false)
+						}, isGuacamole ? 0 : clipboardPasteDelay)
 					}}
 				/>
 			</div>}
@@ -328,7 +445,7 @@ export default function Vnc() {
 				qualityLevel={customizations.quality === "low" ? 9 : customizations.quality == "medium" ? 5 : 8}		// Default: 6 | Max: 9 | More is better!
 				compressionLevel={customizations.quality === "low" ? 2 : customizations.quality == "medium" ? 1 : 0}	// Default: 2 | Min: 0 (Disabled)
 				onSocketCloseEvent={onSocketClose}
-				onConnect={() => { onResize(); setDisconnectReason(null); reconnectsOnClose.current = 0 }}
+				onConnect={() => { onResize(); setDisconnectReason(null); setTakeover(false); reconnectsOnClose.current = 0 }}
 				loadingUI={isLoading ? 
 					<div className="loading-wrapper"> <LoadingAnimation text='Anwendung wird geladen' /></div> 
 					: 
@@ -337,21 +454,29 @@ export default function Vnc() {
 				autoConnect={false}
 				retryDuration={5 * 1000}	// Only after 5 minutes
 				onKeyType={onKeyType}
-				onClipboard={(e) => e && customizations.clipBoardSupport && navigator.clipboard.writeText(convertFromLatin1(e?.detail.text)) }
 				onMouseMove={(e) => mousePosition.current = { x: e.pageX, y: e.pageY }}
 			/>}
 
 			{ useGuacamole === true && <Guacamole 
 				className='vnc'
 				url={baseURL}
+				tunnelUrl={tunnelURL}
 				ref={ref as any}
 				onSocketClose={onSocketClose}
 				disconnectReason={disconnectReason}
-				onConnect={() => { onResize(); setDisconnectReason(null); reconnectsOnClose.current = 0 }}
+				takeover={takeover}
+				onConnect={() => { onResize(); setDisconnectReason(null); setTakeover(false); reconnectsOnClose.current = 0 }}
 				onKeyType={onKeyType}
 				onMouseMove={(e) => mousePosition.current = { x: e.x, y: e.y }}
 			/>}
 
+			{ (disconnectReason?.code === "USER_ALREADY_EXISTS" || disconnectReason?.code === "SESSION_TAKEN_OVER") &&
+				<button className='takeover-button' onClick={takeoverSession}>Sitzung in diesem Fenster fortsetzen</button>
+			}
+			{ disconnectReason?.code === "SESSION_CLOSED_BY_ADMIN" &&
+				<button className='takeover-button' onClick={takeoverSession}>Erneut verbinden</button>
+			}
+
 		</div>
 	);
 
@@ -363,7 +488,7 @@ export default function Vnc() {
 	* 
 	* @param res 	The response of the probe action
 */
-export function getDisconnectReason(res: StandardResponse): { code: "USER_ALREADY_EXISTS" | "UNKNOWN", message: string } {
+export function getDisconnectReason(res: StandardResponse): { code: "USER_ALREADY_EXISTS" | "SESSION_TAKEN_OVER" | "SESSION_CLOSED_BY_ADMIN" | "UNKNOWN", message: string } {
 	if (res.data === null) {
 		return{code: "UNKNOWN", message: "Es trat ein unbekannter Fehler auf"}
 	} else {
@@ -381,37 +506,3 @@ export function getDisconnectReason(res: StandardResponse): { code: "USER_ALREAD
 		}
 	}
 }
-
-/**
- * Converts the given string to a UTF-8 JavaScript string.
- * 
- * @param str 	Latin-1 (ISO 8859-1) String to convert
- */
-export function convertFromLatin1(str: string): string {
-	return str
-		.replaceAll("\u00c3\u00a4", "ä")
-		.replaceAll("\u00c3\u0084", "Ä")
-		.replaceAll("\u00c3\u00bc", "ü")
-		.replaceAll("\u00c3\u009c", "Ü")
-		.replaceAll("\u00c3\u0096", "Ö")
-		.replaceAll("\u00c3\u00b6", "ö")
-		.replaceAll("\u00c3\u009f", "ß")
-}
-
-/**
- * Converts the given string to the ISO 8859-1 charset that VNC 
- * does understand for clipboard support
- * 
- * @param str 	UTF-8 String to convert
- */
-export function convertToLatin1(str: string): string {
-	return str
-		.replaceAll("ä", "\u00c3\u00a4")
-		.replaceAll("Ä", "\u00c3\u0084")
-		.replaceAll("ü", "\u00c3\u00bc")
-		.replaceAll("Ü", "\u00c3\u009c")
-		.replaceAll("Ö", "\u00c3\u0096")
-		.replaceAll("ö", "\u00c3\u00b6")
-		.replaceAll("ß", "\u00c3\u009f")
-		//return str.replaceAll("ä", "\u00e4")
-}
\ No newline at end of file
@@ -3,7 +3,11 @@ import SecurityHelper from "../../services/SecuriyHelper";
 
 let ws: WebSocket | null = null;
 
+// Weather the WebSocket should be reconnected after it was closed
+let reconnect = true;
+
 export function connect(onMessage: (id: number, responseTo: number | null, message: WebSocketMessage) => void) {
+	reconnect = true
 
 	const wsUrl = (location.protocol === 'https:' ? 'wss' : 'ws') + '://'  + location.host + "/api/app/ws?userIdentifier=" + encodeURIComponent(SecurityHelper.getUserIdentification())
 	ws = new WebSocket(wsUrl);
@@ -21,10 +25,16 @@ export function connect(onMessage: (id: number, responseTo: number | null, messa
 	};
   
 	ws.onclose = function(e) {
-		console.log('WebSocket to the LFS.X was closed. Reconnecting in 2 seconds', e.reason);
 		ws = null;
+		if (!reconnect) {
+			console.log('WebSocket to the LFS.X was closed', e.reason);
+			return
+		}
+
+		console.log('WebSocket to the LFS.X was closed. Reconnecting in 2 seconds', e.reason);
 		setTimeout(function() {
-			connect(onMessage);
+			// The WebSocket may be disconnected on purpose in the meantime
+			if (reconnect) connect(onMessage);
 		}, 2000);
 	};
   
@@ -35,6 +45,15 @@ export function connect(onMessage: (id: number, responseTo: number | null, messa
 	};
 }
 
+/**
+ * Closes the WebSocket to the LFS.X without reconnecting again
+ */
+export function disconnect() {
+	reconnect = false
+	if (ws !== null) ws.close();
+	ws = null;
+}
+
 export function send(responseTo: number | null, ...messages: Array<WebSocketMessage>) {
 	const data: WebSocketData = {
 		id: Math.floor(Math.random() * 1048576) + 1,
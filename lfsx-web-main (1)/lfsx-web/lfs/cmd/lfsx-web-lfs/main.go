@@ -1,9 +1,14 @@
 package main
 
 import (
+	"os"
+	"os/signal"
+	"syscall"
+
 	"gitea.hama.de/LFS/go-logger"
 	"gitea.hama.de/LFS/go-webserver/webserver"
 	"gitea.hama.de/LFS/lfsx-web/lfs/internal/api"
+	"gitea.hama.de/LFS/lfsx-web/lfs/internal/desktop"
 	"gitea.hama.de/LFS/lfsx-web/lfs/internal/lfs"
 	"gitea.hama.de/LFS/lfsx-web/lfs/internal/models"
 )
@@ -16,12 +21,35 @@ func main() {
 	// Apply gneric configuration options
 	conf := models.GetAppConfig(version)
 
+	// Start sway, wayvnc and guacd if they aren't started by the entrypoint of the container
+	var desk *desktop.Desktop
+	if conf.StartDesktop {
+		var err error
+		if desk, err = desktop.Start(conf); err != nil {
+			logger.Fatal("Failed to start the desktop: %s", err)
+		}
+	}
+
 	// Start the LFS
 	lfs, err := lfs.StartLfs(conf)
 	if err != nil {
 		logger.Fatal("Failed to start the LFS: %s", err)
 	}
 
+	// The started processes would keep running after the session was stopped
+	if desk != nil {
+		go func() {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+			<-signals
+
+			logger.Info("Stopping the LFS.X and the desktop")
+			lfs.Stop()
+			desk.Stop()
+			os.Exit(0)
+		}()
+	}
+
 	// Build the web app
 	webApp := webserver.WebServer[api.Api]{
 		Logger: logger.GetGlobalLogger(),
@@ -11,7 +11,7 @@ sway &
 sleep 0.5
 
 # Start guacamole in foreground
-/opt/guacamole/sbin/guacd -b 0.0.0.0 -L debug -f &
+/opt/guacamole/sbin/guacd -b 0.0.0.0 -l "${APP_LFS_GUACD_PORT:-4822}" -L debug -f &
 
 # Start a new firefox instance
 # firefox --new-instance &
@@ -1,12 +1,15 @@
 package api
 
 import (
+	"context"
 	"net/http"
 	"os"
 
 	"gitea.hama.de/LFS/go-logger"
 	"gitea.hama.de/LFS/go-webserver/response"
 	"gitea.hama.de/LFS/go-webserver/webserver"
+	"gitea.hama.de/LFS/lfsx-web/lfs/internal/api/clipboard"
+	"gitea.hama.de/LFS/lfsx-web/lfs/internal/api/files"
 	"gitea.hama.de/LFS/lfsx-web/lfs/internal/api/kubernetes"
 	"gitea.hama.de/LFS/lfsx-web/lfs/internal/api/vnc"
 	"gitea.hama.de/LFS/lfsx-web/lfs/internal/lfs"
@@ -48,10 +51,20 @@ func (api *Api) routes(r chi.Router) {
 	kubernetes.RegisterHandlers(r, api.Config, api.Lfs)
 
 	// VNC endpoints
-	api.vncService = vnc.NewVncService(api.Lfs)
+	api.vncService = vnc.NewVncService(api.Config, api.Lfs)
 	vnc.RegisterHandlers(r, api.vncService)
 	go api.vncService.StartUserConnectionsCheck()
 
+	// Clipboard of the session
+	clipboardService := clipboard.NewClipboardService()
+	clipboard.RegisterHandlers(r, clipboardService)
+	go clipboardService.Watch(context.Background())
+
+	// Files exported by the LFS.X
+	fileService := files.NewFileService(api.Config.OutboxDir)
+	files.RegisterHandlers(r, fileService)
+	go fileService.Watch(context.Background())
+
 	// Extra endpoints
 	api.extras(r)
 }
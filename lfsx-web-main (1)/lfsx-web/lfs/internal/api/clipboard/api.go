@@ -0,0 +1,82 @@
+package clipboard
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/go-webserver/errors"
+	"gitea.hama.de/LFS/go-webserver/response"
+	"gitea.hama.de/LFS/lfsx-web/controller/pkg/utils"
+	"github.com/go-chi/chi"
+)
+
+// Maximum time a request waits for a change of the clipboard
+const maxWaitTime = 25 * time.Second
+
+type Service interface {
+	Get() Clipboard
+	Wait(ctx context.Context, version int) (Clipboard, bool)
+	Set(text string) error
+}
+
+type ressource struct {
+	service Service
+}
+
+func RegisterHandlers(r chi.Router, service Service) {
+	res := ressource{service: service}
+
+	r.Get("/clipboard", res.Get)
+	r.Post("/clipboard", res.Set)
+}
+
+// Get returns the content of the clipboard.
+//
+// When the query parameter "since" is given, the request waits until the version
+// of the clipboard is newer than the given version. If the clipboard didn't change
+// within the wait time, 204 is returned
+func (res ressource) Get(w http.ResponseWriter, r *http.Request) {
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		response.WriteJson(res.service.Get(), 200, w)
+		return
+	}
+
+	version, err := strconv.Atoi(since)
+	if err != nil {
+		errors.Write(w, errors.BadRequest("Invalid version given"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), maxWaitTime)
+	defer cancel()
+
+	if clipboard, changed := res.service.Wait(ctx, version); changed {
+		response.WriteJson(clipboard, 200, w)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Set writes the text of the body into the clipboard
+func (res ressource) Set(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Text string `json:"text"`
+	}
+
+	if _, err := utils.DecodeBody(&data, r); err != nil {
+		errors.Write(w, err)
+		return
+	}
+
+	if err := res.service.Set(data.Text); err != nil {
+		logger.Warning("Failed to write the clipboard: %s", err)
+		errors.Write(w, errors.NewError("Failed to write the clipboard", 500))
+		return
+	}
+
+	response.WriteText("OK", 200, w)
+}
@@ -0,0 +1,156 @@
+package clipboard
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+)
+
+// Clipboard is the text content of the wayland clipboard
+type Clipboard struct {
+	// Incremented with every change of the clipboard
+	Version int `json:"version"`
+
+	Text string `json:"text"`
+}
+
+// ClipboardService reads and writes the wayland clipboard of the
+// session with wl-clipboard (data-control protocol)
+type ClipboardService struct {
+	current Clipboard
+
+	// Closed and replaced when the clipboard changes
+	changed chan struct{}
+
+	lock sync.Mutex
+}
+
+// NewClipboardService creates a new service to access the clipboard
+func NewClipboardService() *ClipboardService {
+	return &ClipboardService{
+		changed: make(chan struct{}),
+	}
+}
+
+// Get returns the current content of the clipboard
+func (c *ClipboardService) Get() Clipboard {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.current
+}
+
+// Wait returns the content of the clipboard as soon as the version is newer
+// than the given version. When the context is done before, false is returned
+func (c *ClipboardService) Wait(ctx context.Context, version int) (Clipboard, bool) {
+	for {
+		c.lock.Lock()
+		current, changed := c.current, c.changed
+		c.lock.Unlock()
+
+		if current.Version > version {
+			return current, true
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return current, false
+		}
+	}
+}
+
+// Set writes the given text into the clipboard
+func (c *ClipboardService) Set(text string) error {
+	cmd := exec.Command("wl-copy", "--type", "text/plain;charset=utf-8")
+	cmd.Stdin = strings.NewReader(text)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, output)
+	}
+
+	// The watcher would notice the change as well. But the next read should already return the new content
+	c.update(text)
+	return nil
+}
+
+// Watch reports all changes of the clipboard. When the watcher exits (e.g. the compositor
+// is not started yet), it's restarted after some seconds.
+//
+// This method does block until the given context is canceled
+func (c *ClipboardService) Watch(ctx context.Context) {
+	for {
+		if err := c.watch(ctx); err != nil {
+			logger.Warning("Failed to watch the clipboard: %s", err)
+		}
+
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watch runs "wl-paste --watch" that prints a line for every change of the clipboard
+func (c *ClipboardService) watch(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "wl-paste", "--type", "text", "--watch", "echo")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		text, err := c.read()
+		if err != nil {
+			logger.Debug("Failed to read the changed clipboard: %s", err)
+			continue
+		}
+		c.update(text)
+	}
+
+	return cmd.Wait()
+}
+
+// read reads the text of the clipboard
+func (c *ClipboardService) read() (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("wl-paste", "--type", "text", "--no-newline")
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		// An empty clipboard is no error
+		if strings.Contains(stderr.String(), "Nothing is copied") || strings.Contains(stderr.String(), "No selection") {
+			return "", nil
+		}
+		return "", fmt.Errorf("%s: %s", err, stderr.String())
+	}
+
+	return string(output), nil
+}
+
+// update stores the given text as the new content and notifies all waiting requests
+func (c *ClipboardService) update(text string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if text == c.current.Text && c.current.Version > 0 {
+		return
+	}
+
+	c.current = Clipboard{Version: c.current.Version + 1, Text: text}
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
@@ -0,0 +1,82 @@
+package files
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/go-webserver/errors"
+	"gitea.hama.de/LFS/go-webserver/response"
+	"github.com/go-chi/chi"
+)
+
+// Maximum time a request waits for new files
+const maxWaitTime = 25 * time.Second
+
+type Service interface {
+	List() Outbox
+	Wait(ctx context.Context, version int) (Outbox, bool)
+	Open(name string) (*os.File, File, error)
+}
+
+type ressource struct {
+	service Service
+}
+
+func RegisterHandlers(r chi.Router, service Service) {
+	res := ressource{service: service}
+
+	r.Get("/files", res.List)
+	r.Get("/files/{name}", res.Download)
+}
+
+// List returns all files of the outbox that are ready to download.
+//
+// When the query parameter "since" is given, the request waits until a file became
+// ready after the given version. Only those files are returned then. If no file
+// became ready within the wait time, 204 is returned
+func (res ressource) List(w http.ResponseWriter, r *http.Request) {
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		response.WriteJson(res.service.List(), 200, w)
+		return
+	}
+
+	version, err := strconv.Atoi(since)
+	if err != nil {
+		errors.Write(w, errors.BadRequest("Invalid version given"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), maxWaitTime)
+	defer cancel()
+
+	if outbox, changed := res.service.Wait(ctx, version); changed {
+		response.WriteJson(outbox, 200, w)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Download streams the file with the given name as an attachment
+func (res ressource) Download(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	fd, file, err := res.service.Open(name)
+	if os.IsNotExist(err) {
+		errors.Write(w, errors.NewError("File not found", 404))
+		return
+	} else if err != nil {
+		logger.Warning("Failed to open the file %q of the outbox: %s", name, err)
+		errors.Write(w, errors.NewError("Failed to open the file", 500))
+		return
+	}
+	defer fd.Close()
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	http.ServeContent(w, r, file.Name, file.ModifiedAt, fd)
+}
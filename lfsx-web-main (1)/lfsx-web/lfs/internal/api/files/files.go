@@ -0,0 +1,213 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+)
+
+// Interval in which the outbox directory is checked for new files
+const pollInterval = 2 * time.Second
+
+// File is a file inside the outbox directory that is ready to download
+type File struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+
+	// Version of the outbox in which the file became ready
+	Version int `json:"version"`
+}
+
+// Outbox contains all files that are ready to download
+type Outbox struct {
+	// Incremented whenever a file becomes ready
+	Version int    `json:"version"`
+	Files   []File `json:"files"`
+}
+
+// FileService provides the files the LFS.X exports into the outbox directory.
+//
+// A file is ready to download when its size and modification time didn't change
+// between two checks. So files that are still written by the LFS.X are not reported
+type FileService struct {
+	dir string
+
+	version int
+	ready   map[string]File
+
+	// Files that were seen at the last check but aren't ready yet
+	pending map[string]os.FileInfo
+
+	// Closed and replaced when a file becomes ready
+	changed chan struct{}
+
+	lock sync.Mutex
+}
+
+// NewFileService creates a new service for the given outbox directory
+func NewFileService(dir string) *FileService {
+	return &FileService{
+		dir:     dir,
+		ready:   make(map[string]File),
+		pending: make(map[string]os.FileInfo),
+		changed: make(chan struct{}),
+	}
+}
+
+// List returns all files that are ready to download
+func (f *FileService) List() Outbox {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.outbox(0)
+}
+
+// Wait returns the files that became ready after the given version. When the
+// context is done before any file became ready, false is returned
+func (f *FileService) Wait(ctx context.Context, version int) (Outbox, bool) {
+	for {
+		f.lock.Lock()
+		outbox, changed := f.outbox(version), f.changed
+		f.lock.Unlock()
+
+		if len(outbox.Files) > 0 {
+			return outbox, true
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return outbox, false
+		}
+	}
+}
+
+// Open opens the ready file with the given name for reading
+func (f *FileService) Open(name string) (*os.File, File, error) {
+	f.lock.Lock()
+	file, ok := f.ready[name]
+	f.lock.Unlock()
+
+	if !ok {
+		return nil, File{}, os.ErrNotExist
+	}
+
+	fd, err := os.Open(filepath.Join(f.dir, file.Name))
+	if err != nil {
+		return nil, File{}, err
+	}
+
+	return fd, file, nil
+}
+
+// Watch checks the outbox directory periodically for new files.
+//
+// This method does block until the given context is canceled
+func (f *FileService) Watch(ctx context.Context) {
+	// The LFS.X only writes into an existing directory
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		logger.Warning("Failed to create the outbox directory %q: %s", f.dir, err)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.check(); err != nil {
+			logger.Debug("Failed to check the outbox directory: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check reads the outbox directory and updates the ready files
+func (f *FileService) check() error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %s", f.dir, err)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	existing := make(map[string]bool, len(entries))
+	pending := make(map[string]os.FileInfo)
+	hasChanged := false
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || isTemporary(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		existing[info.Name()] = true
+
+		// Already reported and not changed since then
+		if file, ok := f.ready[info.Name()]; ok && file.Size == info.Size() && file.ModifiedAt.Equal(info.ModTime()) {
+			continue
+		}
+
+		// The file didn't change since the last check
+		if last, ok := f.pending[info.Name()]; ok && last.Size() == info.Size() && last.ModTime().Equal(info.ModTime()) {
+			f.version++
+			f.ready[info.Name()] = File{Name: info.Name(), Size: info.Size(), ModifiedAt: info.ModTime(), Version: f.version}
+			hasChanged = true
+			continue
+		}
+
+		pending[info.Name()] = info
+	}
+
+	// Remove deleted files
+	for name := range f.ready {
+		if !existing[name] {
+			delete(f.ready, name)
+		}
+	}
+	f.pending = pending
+
+	if hasChanged {
+		close(f.changed)
+		f.changed = make(chan struct{})
+	}
+
+	return nil
+}
+
+// outbox returns the ready files that are newer than the given version
+func (f *FileService) outbox(version int) Outbox {
+	outbox := Outbox{Version: f.version, Files: make([]File, 0)}
+	for _, file := range f.ready {
+		if file.Version > version {
+			outbox.Files = append(outbox.Files, file)
+		}
+	}
+
+	sort.Slice(outbox.Files, func(i, j int) bool {
+		return outbox.Files[i].Version < outbox.Files[j].Version
+	})
+
+	return outbox
+}
+
+// isTemporary returns weather the file with the given name is a hidden
+// or temporary file that is never offered for download
+func isTemporary(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~$") ||
+		strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".part")
+}
@@ -35,12 +35,15 @@ type VncService struct {
 	// A list of predefined scaling properties indexed by the scaling factor based on 100%
 	ScalingModes map[int]models.Scaling
 
+	// Port of the VNC server to check for connected users
+	VncPort int
+
 	// LFS instance
 	lfs *lfs.Lfs
 }
 
 // NewVncService constructs a new VNC Service to manage the display output
-func NewVncService(lfs *lfs.Lfs) *VncService {
+func NewVncService(config *models.AppConfig, lfs *lfs.Lfs) *VncService {
 	return &VncService{
 		DisplayName: "HEADLESS-1",
 		Uptime:      time.Now(),
@@ -51,7 +54,8 @@ func NewVncService(lfs *lfs.Lfs) *VncService {
 			175: {Scaling: 100, ScalingFont: 175, CursorSize: 32},
 			200: {Scaling: 200, ScalingFont: 100, CursorSize: 24},
 		},
-		lfs: lfs,
+		VncPort: config.VncPort,
+		lfs:     lfs,
 	}
 }
 
@@ -136,7 +140,7 @@ func (v *VncService) StartUserConnectionsCheck() {
 
 	for {
 		time.Sleep(30 * time.Second)
-		cmd := exec.Command("bash", "-c", "netstat -anpt | grep '5910' | grep -E 'ESTABLISHED \\d{2,}/wayvnc' | grep -c 'tcp'")
+		cmd := exec.Command("bash", "-c", fmt.Sprintf("netstat -anpt | grep '%d' | grep -E 'ESTABLISHED \\d{2,}/wayvnc' | grep -c 'tcp'", v.VncPort))
 
 		output, rtc, err := v.execute(cmd)
 		if err != nil {
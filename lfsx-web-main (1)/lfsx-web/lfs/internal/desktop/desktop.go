@@ -0,0 +1,176 @@
+// The desktop package starts the graphical environment of a session (sway, wayvnc
+// and guacd) when it isn't started by the entrypoint of the container.
+// This is the case for sessions of the "process" backend of the controller
+package desktop
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gitea.hama.de/LFS/go-logger"
+	"gitea.hama.de/LFS/lfsx-web/lfs/internal/models"
+)
+
+// Maximum time to wait until sway created its sockets
+const swayStartTimeout = 10 * time.Second
+
+// Maximum time to wait until a process exited after it was terminated
+const stopTimeout = 5 * time.Second
+
+// Desktop contains the started processes of the graphical environment
+type Desktop struct {
+	processes []*process
+	lock      sync.Mutex
+}
+
+// process is a single started program of the desktop
+type process struct {
+	cmd *exec.Cmd
+
+	// Closed after the process exited
+	exited chan struct{}
+}
+
+// Start starts sway with its own runtime directory and afterwards wayvnc and guacd on
+// the ports of the given configuration.
+//
+// The environment of this process is changed so that the LFS.X and all other programs
+// started later on use the started sway instance
+func Start(config *models.AppConfig) (*Desktop, error) {
+	d := &Desktop{}
+
+	// Every session needs an own runtime directory for the sockets of sway
+	runtimeDir := filepath.Join(config.DataDir, "runtime")
+	if err := os.RemoveAll(runtimeDir); err != nil {
+		return nil, fmt.Errorf("failed to clean runtime directory %q: %s", runtimeDir, err)
+	}
+	if err := os.MkdirAll(runtimeDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create runtime directory %q: %s", runtimeDir, err)
+	}
+
+	// Don't connect to a compositor of the host
+	os.Unsetenv("WAYLAND_DISPLAY")
+	os.Unsetenv("SWAYSOCK")
+	os.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	os.Setenv("XDG_SESSION_TYPE", "wayland")
+	os.Setenv("WLR_BACKENDS", "headless")
+	os.Setenv("WLR_HEADLESS_OUTPUTS", "1")
+	os.Setenv("WLR_LIBINPUT_NO_DEVICES", "1")
+	os.Setenv("WLR_RENDERER_ALLOW_SOFTWARE", "1")
+	os.Setenv("WLR_NO_HARDWARE_CURSORS", "1")
+	os.Setenv("GDK_BACKEND", "wayland")
+
+	// Without a configured file the config of the container (or the host) would start wayvnc on the default port
+	swayConfig := config.SwayConfig
+	if swayConfig == "" {
+		swayConfig = filepath.Join(runtimeDir, "sway.conf")
+		if err := os.WriteFile(swayConfig, []byte("output HEADLESS-1 resolution 1920x1080\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write sway config: %s", err)
+		}
+	}
+	if err := d.start("sway", "--config", swayConfig); err != nil {
+		return nil, err
+	}
+
+	waylandDisplay, swaySock, err := waitForSockets(runtimeDir, d.processes[0].exited)
+	if err != nil {
+		d.Stop()
+		return nil, err
+	}
+	os.Setenv("WAYLAND_DISPLAY", waylandDisplay)
+	os.Setenv("SWAYSOCK", swaySock)
+
+	// The controller connects via the loopback interface
+	if err := d.start("wayvnc", "--keyboard=de", "--render-cursor", "127.0.0.1", strconv.Itoa(config.VncPort)); err != nil {
+		d.Stop()
+		return nil, err
+	}
+	if err := d.start(config.GuacdPath, "-b", "127.0.0.1", "-l", strconv.Itoa(config.GuacdPort), "-f"); err != nil {
+		d.Stop()
+		return nil, err
+	}
+
+	logger.Info("Started the desktop on %s (VNC port %d, guacd port %d)", waylandDisplay, config.VncPort, config.GuacdPort)
+	return d, nil
+}
+
+// start starts the given program. Its output is piped to this terminal
+func (d *Desktop) start(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %q: %s", name, err)
+	}
+
+	p := &process{cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		err := cmd.Wait()
+		logger.Info("Desktop process %q exited: %v", name, err)
+		close(p.exited)
+	}()
+
+	d.lock.Lock()
+	d.processes = append(d.processes, p)
+	d.lock.Unlock()
+
+	return nil
+}
+
+// Stop terminates all started processes in the reverse order they were started
+// and waits until they exited
+func (d *Desktop) Stop() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for i := len(d.processes) - 1; i >= 0; i-- {
+		p := d.processes[i]
+		p.cmd.Process.Signal(syscall.SIGTERM)
+
+		select {
+		case <-p.exited:
+		case <-time.After(stopTimeout):
+			logger.Warning("Killing desktop process %q because it didn't exit within %s", p.cmd.Path, stopTimeout)
+			p.cmd.Process.Kill()
+		}
+	}
+	d.processes = nil
+}
+
+// waitForSockets waits until sway created the wayland and the IPC socket in the
+// runtime directory and returns the name of the wayland display and the path of the IPC socket
+func waitForSockets(runtimeDir string, exited chan struct{}) (string, string, error) {
+	deadline := time.After(swayStartTimeout)
+
+	for {
+		displays, _ := filepath.Glob(filepath.Join(runtimeDir, "wayland-*"))
+		ipcSockets, _ := filepath.Glob(filepath.Join(runtimeDir, "sway-ipc.*.sock"))
+
+		display := ""
+		for _, d := range displays {
+			if !strings.HasSuffix(d, ".lock") {
+				display = filepath.Base(d)
+			}
+		}
+		if display != "" && len(ipcSockets) > 0 {
+			return display, ipcSockets[0], nil
+		}
+
+		select {
+		case <-time.After(100 * time.Millisecond):
+			continue
+		case <-exited:
+			return "", "", fmt.Errorf("sway exited before it was ready")
+		case <-deadline:
+			return "", "", fmt.Errorf("timeout while waiting for the sockets of sway")
+		}
+	}
+}
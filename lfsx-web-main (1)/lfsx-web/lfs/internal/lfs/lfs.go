@@ -73,3 +73,10 @@ func StartLfs(config *models.AppConfig) (*Lfs, error) {
 
 	return l, nil
 }
+
+// Stop terminates the LFS.X and all of its child processes
+func (l *Lfs) Stop() {
+	if l.Process != nil && l.Process.Process != nil {
+		syscall.Kill(-l.Process.Process.Pid, syscall.SIGTERM)
+	}
+}
@@ -11,6 +11,26 @@ type AppConfig struct {
 
 	// Address on which the server should be listening on
 	Address string
+
+	// Port on which the VNC server of this session is listening on
+	VncPort int
+
+	// Port on which guacd of this session is listening on
+	GuacdPort int
+
+	// Weather this app starts sway, wayvnc and guacd itself. Otherwise they
+	// are started by the entrypoint of the container
+	StartDesktop bool
+
+	// Path of guacd and an optional config for sway. Only used when the desktop is started by this app
+	GuacdPath  string
+	SwayConfig string
+
+	// Directory with the data of the LFS.X
+	DataDir string
+
+	// Directory the LFS.X exports files into that can be downloaded by the user
+	OutboxDir string
 }
 
 // GetAppConfig gets all configuration options from the current environment variables.
@@ -28,5 +48,14 @@ func GetAppConfig(version string) *AppConfig {
 	return &AppConfig{
 		Version: version,
 		Address: utils.GetEnvString("APP_LFS_ADDRESS", ":4021"),
+		VncPort: utils.GetEnvInt("APP_LFS_VNC_PORT", 5910),
+
+		GuacdPort:    utils.GetEnvInt("APP_LFS_GUACD_PORT", 4822),
+		StartDesktop: utils.GetEnvBool("APP_LFS_START_DESKTOP", false),
+		GuacdPath:    utils.GetEnvString("APP_LFS_GUACD_PATH", "/opt/guacamole/sbin/guacd"),
+		SwayConfig:   utils.GetEnvString("APP_LFS_SWAY_CONFIG", ""),
+		DataDir:      utils.GetEnvString("APP_LFS_PROC_DATA", "/opt/lfs-user"),
+
+		OutboxDir: utils.GetEnvString("APP_LFS_OUTBOX_DIR", "/opt/lfs-user/outbox"),
 	}
 }
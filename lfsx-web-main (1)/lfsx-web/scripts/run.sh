@@ -13,6 +13,10 @@ export APP_DEV_USE_DEVSERVER="true"
 export APP_DEV_VNC_ADDRESS="localhost:5910"
 export APP_DEV_GUACAMOL_ADDRESS="localhost:4822"
 # ----- #
+# Start an own LFS.X host agent for every user instead of using the fixed addresses above #
+# export APP_SESSION_BACKEND="process"
+# export APP_SESSION_PROCESS_COMMAND="/opt/go-lfs/go-lfs"
+# ----- #
 export APP_PRODUCTION="false"
 export APP_LFS_SERVICE_ENDPOINT="https://webapi.hama.com/lfstest/"
 export APP_LFS_SERVICE_ENDPOINT_JWT_NAME="cookie-javalfs"